@@ -1,33 +1,204 @@
 package auth
 
 import (
-	"os"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 )
 
-var jwtKey = []byte(os.Getenv("jwt-secret"))
-
 const (
-	DefaultExpiryHours = 24
-	ExpireByHours      = 10
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 7 * 24 * time.Hour
+
+	AccessTokenType            = "access"
+	RefreshTokenType           = "refresh"
+	EmailVerificationTokenType = "verify_email"
+	MFAChallengeTokenType      = "mfa_challenge"
+
+	MFAChallengeTTL = 5 * time.Minute
 )
 
+var ErrInvalidToken = errors.New("invalid token")
+
 type Claims struct {
 	Email string `json:"email"`
+	Role  string `json:"role,omitempty"`
+	// Type tells access tokens apart from refresh tokens so one cannot be used in place of the other.
+	Type string `json:"typ,omitempty"`
+	// Family is shared by every token issued from the same login, and changes only on a fresh login.
+	Family string `json:"fam,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateJWT(email string) (string, error) {
-	expirationTime := time.Now().Add(DefaultExpiryHours * time.Hour)
-	claims := &Claims{
+// TokenPair is what a successful login or refresh hands back to the client.
+type TokenPair struct {
+	Email            string
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+	Family           string
+	RefreshID        string
+}
+
+// GenerateTokenPair issues a short-lived access token and a refresh token that share
+// the given family. An empty family starts a new one.
+func GenerateTokenPair(email string, role string, family string) (TokenPair, error) {
+	var pair TokenPair
+
+	if family == "" {
+		family = NewTokenID()
+	}
+
+	now := time.Now()
+	pair.Email = email
+	pair.Family = family
+	pair.AccessExpiresAt = now.Add(AccessTokenTTL)
+	pair.RefreshExpiresAt = now.Add(RefreshTokenTTL)
+	pair.RefreshID = NewTokenID()
+
+	access, err := signClaims(&Claims{
+		Email:  email,
+		Role:   role,
+		Type:   AccessTokenType,
+		Family: family,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        NewTokenID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(pair.AccessExpiresAt),
+		},
+	})
+	if err != nil {
+		return pair, err
+	}
+
+	refresh, err := signClaims(&Claims{
+		Email:  email,
+		Role:   role,
+		Type:   RefreshTokenType,
+		Family: family,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        pair.RefreshID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(pair.RefreshExpiresAt),
+		},
+	})
+	if err != nil {
+		return pair, err
+	}
+
+	pair.AccessToken = access
+	pair.RefreshToken = refresh
+	return pair, nil
+}
+
+// GenerateEmailVerificationToken signs a token proving ownership of an email address,
+// to be sent to that address in a verification link.
+func GenerateEmailVerificationToken(email string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	return signClaims(&Claims{
+		Email: email,
+		Type:  EmailVerificationTokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        NewTokenID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	})
+}
+
+// ParseEmailVerificationToken returns the email address a verification token was issued for.
+func ParseEmailVerificationToken(tokenString string) (string, error) {
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return "", err
+	}
+	if claims.Type != EmailVerificationTokenType {
+		return "", ErrInvalidToken
+	}
+	return claims.Email, nil
+}
+
+// GenerateMFAChallengeToken proves the password step of a login succeeded.
+// It is exchanged for real tokens together with a second factor.
+func GenerateMFAChallengeToken(email string) (string, error) {
+	now := time.Now()
+	return signClaims(&Claims{
 		Email: email,
+		Type:  MFAChallengeTokenType,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			ID:        NewTokenID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(MFAChallengeTTL)),
 		},
+	})
+}
+
+// ParseMFAChallengeToken returns the claims of a valid MFA challenge token.
+func ParseMFAChallengeToken(tokenString string) (*Claims, error) {
+	claims, err := ParseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type != MFAChallengeTokenType {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// IsAccessToken reports whether the claims may be used to authenticate an API request.
+func (c *Claims) IsAccessToken() bool {
+	return c.Type == AccessTokenType
+}
+
+// ParseToken verifies the signature and expiry of a token and returns its claims.
+func ParseToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, Keys().Keyfunc)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// NewTokenID returns a random identifier suitable for a jti or a token family.
+func NewTokenID() string {
+	return randomHex(16)
+}
+
+// NewSecret returns a random, unguessable token to hand out to a user, e.g. in a reset link.
+func NewSecret() string {
+	return randomHex(32)
+}
+
+// APIKeyPrefix marks API keys so that they are easy to recognise, e.g. by secret scanners.
+const APIKeyPrefix = "grk_"
+
+// NewAPIKey returns a new random API key.
+func NewAPIKey() string {
+	return APIKeyPrefix + randomHex(24)
+}
+
+// HashToken returns the SHA-256 of a secret token. Only the hash of a secret is ever stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
 	}
+	return hex.EncodeToString(b)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+func signClaims(claims *Claims) (string, error) {
+	return Keys().Sign(claims)
 }
@@ -0,0 +1,457 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrUnknownKey         = errors.New("unknown signing key")
+	ErrUnsupportedKeyType = errors.New("unsupported key type, expected RSA or Ed25519")
+)
+
+// SigningKey is a private key together with the kid and algorithm it signs with.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+}
+
+// VerificationKey is a public key that tokens may be verified against.
+type VerificationKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	Public crypto.PublicKey
+}
+
+// JSONWebKey is a single public key as published on the JWKS endpoint (RFC 7517).
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// KeyManager signs tokens with a single active key and verifies them against any
+// key it knows about, so that tokens signed before a rotation remain valid.
+type KeyManager struct {
+	mu           sync.RWMutex
+	signing      SigningKey
+	verification map[string]VerificationKey
+	order        []string
+}
+
+var (
+	keys     *KeyManager
+	keysOnce sync.Once
+)
+
+// InitKeys loads the key manager from the environment and panics if the keys cannot be read.
+func InitKeys() {
+	km, err := LoadKeyManager()
+	if err != nil {
+		panic(fmt.Sprintf("failed to load JWT keys: %v", err))
+	}
+	SetKeys(km)
+}
+
+// SetKeys replaces the key manager used to sign and verify tokens.
+func SetKeys(km *KeyManager) {
+	keysOnce.Do(func() {})
+	keys = km
+}
+
+// Keys returns the key manager in use, loading it from the environment on first use.
+func Keys() *KeyManager {
+	keysOnce.Do(func() {
+		km, err := LoadKeyManager()
+		if err != nil {
+			panic(fmt.Sprintf("failed to load JWT keys: %v", err))
+		}
+		keys = km
+	})
+	return keys
+}
+
+// LoadKeyManager builds a key manager from PEM files named in the environment:
+//
+//	JWT_SIGNING_KEY_FILE       private RSA or Ed25519 key used to sign new tokens
+//	JWT_SIGNING_KEY_ID         optional kid for the signing key, defaults to its RFC 7638 thumbprint
+//	JWT_VERIFICATION_KEY_FILES comma separated public (or private) keys that are still accepted
+//
+// When no signing key is configured an ephemeral Ed25519 key is generated, but only when
+// APP_ENV is dev or test: tokens would not survive a restart nor be accepted by other replicas.
+func LoadKeyManager() (*KeyManager, error) {
+	var signing SigningKey
+	var err error
+
+	if path := os.Getenv("JWT_SIGNING_KEY_FILE"); path != "" {
+		signing, err = LoadSigningKey(path, os.Getenv("JWT_SIGNING_KEY_ID"))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if env := config.Env("APP_ENV", ""); env != "dev" && env != "test" {
+			return nil, errors.New("JWT_SIGNING_KEY_FILE is required unless APP_ENV is dev or test")
+		}
+
+		log.Println("JWT_SIGNING_KEY_FILE is not set, signing tokens with an ephemeral Ed25519 key")
+		signing, err = GenerateSigningKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	km := NewKeyManager(signing)
+
+	for _, path := range strings.Split(os.Getenv("JWT_VERIFICATION_KEY_FILES"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		key, err := LoadVerificationKey(path)
+		if err != nil {
+			return nil, err
+		}
+		km.AddVerificationKey(key)
+	}
+
+	return km, nil
+}
+
+func NewKeyManager(signing SigningKey) *KeyManager {
+	km := &KeyManager{verification: map[string]VerificationKey{}}
+	km.setSigningKey(signing)
+	return km
+}
+
+// Rotate makes the given key the active signing key. The previous key stays available
+// for verification until it is removed with RemoveKey.
+func (km *KeyManager) Rotate(signing SigningKey) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.setSigningKey(signing)
+}
+
+// AddVerificationKey accepts tokens signed by another key, typically the one being rotated out.
+func (km *KeyManager) AddVerificationKey(key VerificationKey) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	km.addVerificationKey(key)
+}
+
+// RemoveKey stops accepting tokens signed with the given kid. The active signing key cannot be removed.
+func (km *KeyManager) RemoveKey(kid string) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+
+	if kid == km.signing.ID {
+		return
+	}
+
+	delete(km.verification, kid)
+	for i, id := range km.order {
+		if id == kid {
+			km.order = append(km.order[:i], km.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// SigningKeyID returns the kid placed on newly issued tokens.
+func (km *KeyManager) SigningKeyID() string {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	return km.signing.ID
+}
+
+// Sign signs the claims with the active key and sets the kid header.
+func (km *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	km.mu.RLock()
+	signing := km.signing
+	km.mu.RUnlock()
+
+	token := jwt.NewWithClaims(signing.Method, claims)
+	token.Header["kid"] = signing.ID
+	return token.SignedString(signing.Private)
+}
+
+// Keyfunc resolves the verification key for a token from its kid header.
+func (km *KeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	km.mu.RLock()
+	key, ok := km.verification[kid]
+	km.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+
+	// Never let the token choose the algorithm
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, ErrInvalidToken
+	}
+
+	return key.Public, nil
+}
+
+// JWKS returns every key that tokens may currently be verified against.
+func (km *KeyManager) JWKS() JSONWebKeySet {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, kid := range km.order {
+		jwk, err := toJSONWebKey(km.verification[kid])
+		if err != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func (km *KeyManager) setSigningKey(signing SigningKey) {
+	km.signing = signing
+	km.addVerificationKey(VerificationKey{ID: signing.ID, Method: signing.Method, Public: signing.Private.Public()})
+}
+
+func (km *KeyManager) addVerificationKey(key VerificationKey) {
+	if _, ok := km.verification[key.ID]; !ok {
+		km.order = append(km.order, key.ID)
+	}
+	km.verification[key.ID] = key
+}
+
+// GenerateSigningKey creates a fresh Ed25519 signing key.
+func GenerateSigningKey() (SigningKey, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return NewSigningKey(private, "")
+}
+
+// NewSigningKey wraps an RSA or Ed25519 private key. An empty kid defaults to the key's thumbprint.
+func NewSigningKey(private crypto.Signer, kid string) (SigningKey, error) {
+	method, err := methodForKey(private.Public())
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	if kid == "" {
+		kid, err = Thumbprint(private.Public())
+		if err != nil {
+			return SigningKey{}, err
+		}
+	}
+
+	return SigningKey{ID: kid, Method: method, Private: private}, nil
+}
+
+// NewVerificationKey wraps an RSA or Ed25519 public key. An empty kid defaults to the key's thumbprint.
+func NewVerificationKey(public crypto.PublicKey, kid string) (VerificationKey, error) {
+	method, err := methodForKey(public)
+	if err != nil {
+		return VerificationKey{}, err
+	}
+
+	if kid == "" {
+		kid, err = Thumbprint(public)
+		if err != nil {
+			return VerificationKey{}, err
+		}
+	}
+
+	return VerificationKey{ID: kid, Method: method, Public: public}, nil
+}
+
+// LoadSigningKey reads a PKCS#1 or PKCS#8 private key from a PEM file.
+func LoadSigningKey(path string, kid string) (SigningKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	var private interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return SigningKey{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return SigningKey{}, ErrUnsupportedKeyType
+	}
+	return NewSigningKey(signer, kid)
+}
+
+// LoadVerificationKey reads a public key from a PEM file. A private key is also accepted,
+// in which case only its public half is kept.
+func LoadVerificationKey(path string) (VerificationKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return VerificationKey{}, err
+	}
+
+	if strings.Contains(block.Type, "PRIVATE KEY") {
+		signing, err := LoadSigningKey(path, "")
+		if err != nil {
+			return VerificationKey{}, err
+		}
+		return NewVerificationKey(signing.Private.Public(), "")
+	}
+
+	var public interface{}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		public, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return VerificationKey{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return NewVerificationKey(public, "")
+}
+
+// Thumbprint computes the RFC 7638 JWK thumbprint of a public key.
+func Thumbprint(public crypto.PublicKey) (string, error) {
+	var canonical string
+
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		canonical = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, encodeRSAExponent(key.E), b64(key.N.Bytes()))
+	case ed25519.PublicKey:
+		canonical = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%s"}`, b64(key))
+	default:
+		return "", ErrUnsupportedKeyType
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return b64(sum[:]), nil
+}
+
+func toJSONWebKey(key VerificationKey) (JSONWebKey, error) {
+	jwk := JSONWebKey{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+
+	switch public := key.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = b64(public.N.Bytes())
+		jwk.E = encodeRSAExponent(public.E)
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = b64(public)
+	default:
+		return jwk, ErrUnsupportedKeyType
+	}
+
+	return jwk, nil
+}
+
+// PublicKey decodes the key so that tokens signed by another issuer can be verified.
+// RSA, P-256 and Ed25519 keys are understood.
+func (jwk JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch {
+	case jwk.Kty == "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case jwk.Kty == "EC" && jwk.Crv == "P-256":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case jwk.Kty == "OKP" && jwk.Crv == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, ErrUnsupportedKeyType
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, ErrUnsupportedKeyType
+	}
+}
+
+func methodForKey(public crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, ErrUnsupportedKeyType
+	}
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return block, nil
+}
+
+func encodeRSAExponent(e int) string {
+	return b64(big.NewInt(int64(e)).Bytes())
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/go-redis/redis/v8"
+)
+
+// LoginThrottlePrefix namespaces every Redis key used to throttle logins.
+const LoginThrottlePrefix = "auth:login:"
+
+// PasswordResetThrottlePrefix namespaces every Redis key used to throttle password reset requests.
+const PasswordResetThrottlePrefix = "auth:reset:"
+
+const (
+	accountFailuresPrefix = LoginThrottlePrefix + "failures:account:"
+	ipFailuresPrefix      = LoginThrottlePrefix + "failures:ip:"
+	accountLockPrefix     = LoginThrottlePrefix + "lock:account:"
+	ipLockPrefix          = LoginThrottlePrefix + "lock:ip:"
+	accountDelayPrefix    = LoginThrottlePrefix + "delay:account:"
+)
+
+// LoginRetryAfter returns how long the client must wait before it may try to log in to
+// the account again. Zero means a login attempt is allowed now.
+func LoginRetryAfter(email string, ip string) (time.Duration, error) {
+	email = normaliseEmail(email)
+
+	pipe := config.Client.Pipeline()
+	ttls := []*redis.DurationCmd{
+		pipe.PTTL(config.Ctx, accountLockPrefix+email),
+		pipe.PTTL(config.Ctx, ipLockPrefix+ip),
+		pipe.PTTL(config.Ctx, accountDelayPrefix+email),
+	}
+	if _, err := pipe.Exec(config.Ctx); err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	for _, ttl := range ttls {
+		// Missing keys report a negative TTL
+		if ttl.Val() > wait {
+			wait = ttl.Val()
+		}
+	}
+	return wait, nil
+}
+
+// RecordLoginFailure counts a failed login against the account and the client IP, and
+// returns how long the client now has to wait before trying again.
+func RecordLoginFailure(email string, ip string) (time.Duration, error) {
+	settings := config.LoginThrottleSettings()
+	email = normaliseEmail(email)
+
+	pipe := config.Client.TxPipeline()
+	accountFailures := countInWindow(pipe, accountFailuresPrefix+email, settings.FailureWindow)
+	ipFailures := countInWindow(pipe, ipFailuresPrefix+ip, settings.FailureWindow)
+	if _, err := pipe.Exec(config.Ctx); err != nil {
+		return 0, err
+	}
+
+	if ipFailures.Val() >= int64(settings.MaxIPFailures) {
+		if err := config.Client.Set(config.Ctx, ipLockPrefix+ip, 1, settings.LockoutDuration).Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	if accountFailures.Val() >= int64(settings.MaxAccountFailures) {
+		if err := config.Client.Set(config.Ctx, accountLockPrefix+email, 1, settings.LockoutDuration).Err(); err != nil {
+			return 0, err
+		}
+	} else if accountFailures.Val() > int64(settings.DelayAfter) {
+		delay := progressiveDelay(settings, accountFailures.Val())
+		if err := config.Client.Set(config.Ctx, accountDelayPrefix+email, 1, delay).Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	return LoginRetryAfter(email, ip)
+}
+
+// RecordPasswordResetRequest counts a request for a password reset link against the email and
+// the client IP. It reports whether a link may be sent to the email, and how long the client IP
+// has to wait when it has asked too often. Unknown emails count too, so that they cannot be told
+// apart from registered ones.
+func RecordPasswordResetRequest(email string, ip string) (bool, time.Duration, error) {
+	settings := config.PasswordResetThrottleSettings()
+	email = normaliseEmail(email)
+
+	pipe := config.Client.TxPipeline()
+	emailRequests := countInWindow(pipe, PasswordResetThrottlePrefix+"email:"+email, settings.Window)
+	ipRequests := countInWindow(pipe, PasswordResetThrottlePrefix+"ip:"+ip, settings.Window)
+	ipWindow := pipe.PTTL(config.Ctx, PasswordResetThrottlePrefix+"ip:"+ip)
+	if _, err := pipe.Exec(config.Ctx); err != nil {
+		return false, 0, err
+	}
+
+	if ipRequests.Val() > int64(settings.MaxPerIP) {
+		return false, ipWindow.Val(), nil
+	}
+	return emailRequests.Val() <= int64(settings.MaxPerEmail), 0, nil
+}
+
+// countInWindow counts a hit on key in pipe. The window starts with the first hit: SET NX only
+// creates a counter, with its expiry, when there is none yet, and INCR keeps the expiry. Unlike
+// EXPIRE NX it needs no Redis 7.
+func countInWindow(pipe redis.Pipeliner, key string, window time.Duration) *redis.IntCmd {
+	pipe.SetNX(config.Ctx, key, 0, window)
+	return pipe.Incr(config.Ctx, key)
+}
+
+// ResetLoginFailures clears the failed attempts on an account after a successful login.
+// The per IP counter is deliberately left alone, otherwise an attacker who owns one
+// account could keep resetting it while guessing the passwords of others.
+func ResetLoginFailures(email string) error {
+	email = normaliseEmail(email)
+	return config.Client.Del(config.Ctx, accountFailuresPrefix+email, accountDelayPrefix+email).Err()
+}
+
+// UnlockAccount lifts a lockout on an account and forgets its failed attempts.
+func UnlockAccount(email string) error {
+	email = normaliseEmail(email)
+	return config.Client.Del(config.Ctx, accountFailuresPrefix+email, accountDelayPrefix+email, accountLockPrefix+email).Err()
+}
+
+// UnlockIP lifts a lockout on a client IP and forgets its failed attempts.
+func UnlockIP(ip string) error {
+	return config.Client.Del(config.Ctx, ipFailuresPrefix+ip, ipLockPrefix+ip).Err()
+}
+
+func progressiveDelay(settings config.LoginThrottle, failures int64) time.Duration {
+	delay := settings.BaseDelay
+	for i := int64(settings.DelayAfter) + 1; i < failures; i++ {
+		delay *= 2
+		if delay >= settings.MaxDelay {
+			return settings.MaxDelay
+		}
+	}
+	return delay
+}
+
+func normaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,39 @@
+package auth
+
+import "github.com/fokosun/go-rest-api/config"
+
+const (
+	mfaAttemptsPrefix = "auth:mfa:attempts:"
+
+	// MaxMFAAttempts is how many wrong codes a single challenge token tolerates.
+	MaxMFAAttempts = 5
+)
+
+// RecordMFAFailure counts a wrong second factor against a challenge token and revokes
+// the challenge once too many have failed, forcing the password step to be repeated.
+// The failure should also be counted against the account with RecordLoginFailure.
+func RecordMFAFailure(claims *Claims) error {
+	key := mfaAttemptsPrefix + claims.ID
+
+	attempts, err := config.Client.Incr(config.Ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	config.Client.Expire(config.Ctx, key, MFAChallengeTTL)
+
+	if attempts >= MaxMFAAttempts {
+		return RevokeToken(claims.ID, claims.ExpiresAt.Time)
+	}
+	return nil
+}
+
+// CompleteMFAChallenge revokes a challenge token once it has been exchanged for real tokens.
+func CompleteMFAChallenge(claims *Claims) error {
+	config.Client.Del(config.Ctx, mfaAttemptsPrefix+claims.ID)
+	return RevokeToken(claims.ID, claims.ExpiresAt.Time)
+}
+
+// MFAIssuer is the name authenticator apps show next to the account.
+func MFAIssuer() string {
+	return config.Env("MFA_ISSUER", "Books Store")
+}
@@ -0,0 +1,372 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	oidcStatePrefix = "auth:oidc:state:"
+
+	// OIDCStateTTL is how long a user has to complete sign in at the identity provider.
+	OIDCStateTTL = 10 * time.Minute
+)
+
+var (
+	ErrUnknownProvider  = errors.New("unknown identity provider")
+	ErrInvalidOIDCState = errors.New("invalid or expired login state")
+	ErrInvalidIDToken   = errors.New("invalid id token")
+)
+
+// OIDCProvider is an OpenID Connect identity provider users can sign in with.
+// Its endpoints and keys are discovered from the issuer on first use.
+type OIDCProvider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	HTTPClient   *http.Client
+
+	mu        sync.RWMutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// IDTokenClaims are the claims we rely on from a provider's ID token.
+type IDTokenClaims struct {
+	Email           string `json:"email"`
+	EmailVerified   bool   `json:"email_verified"`
+	Nonce           string `json:"nonce"`
+	Name            string `json:"name"`
+	GivenName       string `json:"given_name"`
+	FamilyName      string `json:"family_name"`
+	AuthorizedParty string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// OIDCState is what we remember about a sign in between the redirect to the provider and its callback.
+type OIDCState struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+var (
+	oidcProviders     map[string]*OIDCProvider
+	oidcProvidersOnce sync.Once
+	oidcProvidersMu   sync.RWMutex
+)
+
+// LoadOIDCProviders reads the identity providers named in OIDC_PROVIDERS (comma separated).
+// Each provider NAME is configured with:
+//
+//	OIDC_NAME_ISSUER        issuer URL, used for discovery
+//	OIDC_NAME_CLIENT_ID     client id registered with the provider
+//	OIDC_NAME_CLIENT_SECRET client secret registered with the provider
+//	OIDC_NAME_REDIRECT_URL  optional, defaults to APP_URL/auth/oidc/name/callback
+func LoadOIDCProviders() map[string]*OIDCProvider {
+	providers := map[string]*OIDCProvider{}
+
+	for _, name := range strings.Split(config.Env("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers[name] = &OIDCProvider{
+			Name:         name,
+			Issuer:       config.Env(prefix+"ISSUER", ""),
+			ClientID:     config.Env(prefix+"CLIENT_ID", ""),
+			ClientSecret: config.Env(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  config.Env(prefix+"REDIRECT_URL", config.AppURL()+"/auth/oidc/"+name+"/callback"),
+		}
+	}
+
+	return providers
+}
+
+// SetOIDCProvider registers an identity provider, replacing any with the same name.
+func SetOIDCProvider(p *OIDCProvider) {
+	oidcProvidersOnce.Do(loadOIDCProviders)
+
+	oidcProvidersMu.Lock()
+	defer oidcProvidersMu.Unlock()
+	oidcProviders[p.Name] = p
+}
+
+// GetOIDCProvider looks up a configured identity provider by name.
+func GetOIDCProvider(name string) (*OIDCProvider, error) {
+	oidcProvidersOnce.Do(loadOIDCProviders)
+
+	oidcProvidersMu.RLock()
+	defer oidcProvidersMu.RUnlock()
+
+	p, ok := oidcProviders[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return p, nil
+}
+
+func loadOIDCProviders() {
+	oidcProviders = LoadOIDCProviders()
+}
+
+// BeginOIDCLogin remembers a new state, nonce and PKCE verifier and returns the
+// provider URL the user should be sent to.
+func BeginOIDCLogin(ctx context.Context, p *OIDCProvider) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	state := OIDCState{Provider: p.Name, Verifier: NewSecret(), Nonce: NewSecret()}
+	stateID := NewSecret()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	if err := config.Client.Set(config.Ctx, oidcStatePrefix+stateID, data, OIDCStateTTL).Err(); err != nil {
+		return "", err
+	}
+
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(scopes, " "))
+	query.Set("state", stateID)
+	query.Set("nonce", state.Nonce)
+	query.Set("code_challenge", PKCEChallenge(state.Verifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// ConsumeOIDCState returns the state for a callback and forgets it, so that it can only be used once.
+func ConsumeOIDCState(stateID string) (OIDCState, error) {
+	var state OIDCState
+	if stateID == "" {
+		return state, ErrInvalidOIDCState
+	}
+
+	pipe := config.Client.TxPipeline()
+	get := pipe.Get(config.Ctx, oidcStatePrefix+stateID)
+	pipe.Del(config.Ctx, oidcStatePrefix+stateID)
+	if _, err := pipe.Exec(config.Ctx); err != nil {
+		return state, ErrInvalidOIDCState
+	}
+
+	if err := json.Unmarshal([]byte(get.Val()), &state); err != nil {
+		return state, ErrInvalidOIDCState
+	}
+	return state, nil
+}
+
+// PKCEChallenge derives the S256 code challenge for a verifier (RFC 7636).
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return b64(sum[:])
+}
+
+// Exchange trades an authorization code for the provider's ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code string, verifier string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("client_secret", p.ClientSecret)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: token endpoint returned %s", p.Name, resp.Status)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.IDToken == "" {
+		return "", fmt.Errorf("%s: token response has no id_token", p.Name)
+	}
+
+	return token.IDToken, nil
+}
+
+// VerifyIDToken checks an ID token's signature against the provider's JWKS and
+// that it was issued by the provider, to us, for the sign in that carried nonce.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawToken string, nonce string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+
+	parser := jwt.Parser{ValidMethods: []string{"RS256", "ES256", "EdDSA"}}
+	token, err := parser.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidIDToken
+	}
+
+	if claims.Issuer != p.Issuer || !claims.VerifyAudience(p.ClientID, true) || claims.Subject == "" {
+		return nil, ErrInvalidIDToken
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID {
+		return nil, ErrInvalidIDToken
+	}
+	if claims.ExpiresAt == nil || nonce == "" || claims.Nonce != nonce {
+		return nil, ErrInvalidIDToken
+	}
+
+	return claims, nil
+}
+
+// key finds a signing key by kid, fetching the JWKS again once if the provider has rotated.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.RLock()
+	key, ok := p.keys[kid]
+	p.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+func (p *OIDCProvider) refreshKeys(ctx context.Context) error {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	var set JSONWebKeySet
+	if err := p.getJSON(ctx, discovery.JWKSURI, &set); err != nil {
+		return err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		public, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = public
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.RLock()
+	discovery := p.discovery
+	p.mu.RUnlock()
+	if discovery != nil {
+		return discovery, nil
+	}
+
+	discovery = &oidcDiscovery{}
+	if err := p.getJSON(ctx, strings.TrimRight(p.Issuer, "/")+"/.well-known/openid-configuration", discovery); err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != p.Issuer {
+		return nil, fmt.Errorf("%s: discovery document is for issuer %q", p.Name, discovery.Issuer)
+	}
+
+	p.mu.Lock()
+	p.discovery = discovery
+	p.mu.Unlock()
+	return discovery, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s returned %s", p.Name, endpoint, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *OIDCProvider) client() *http.Client {
+	if p.HTTPClient != nil {
+		return p.HTTPClient
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
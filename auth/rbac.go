@@ -0,0 +1,90 @@
+package auth
+
+import "github.com/fokosun/go-rest-api/models"
+
+type Permission string
+
+const (
+	PermUsersRead   Permission = "users:read"
+	PermUsersWrite  Permission = "users:write"
+	PermUsersManage Permission = "users:manage"
+
+	PermAuthorsRead   Permission = "authors:read"
+	PermAuthorsWrite  Permission = "authors:write"
+	PermAuthorsManage Permission = "authors:manage"
+
+	PermBooksRead   Permission = "books:read"
+	PermBooksWrite  Permission = "books:write"
+	PermBooksManage Permission = "books:manage"
+
+	PermRatingsRead   Permission = "ratings:read"
+	PermRatingsWrite  Permission = "ratings:write"
+	PermRatingsManage Permission = "ratings:manage"
+
+	PermWebhooksManage Permission = "webhooks:manage"
+)
+
+// AllPermissions lists every permission, and so every scope an API key can be given.
+var AllPermissions = []Permission{
+	PermUsersRead, PermUsersWrite, PermUsersManage,
+	PermAuthorsRead, PermAuthorsWrite, PermAuthorsManage,
+	PermBooksRead, PermBooksWrite, PermBooksManage,
+	PermRatingsRead, PermRatingsWrite, PermRatingsManage,
+	PermWebhooksManage,
+}
+
+// readerPermissions let a user browse the catalogue and manage what they created themselves.
+var readerPermissions = []Permission{
+	PermUsersRead, PermUsersWrite,
+	PermAuthorsRead, PermAuthorsWrite,
+	PermBooksRead, PermBooksWrite,
+	PermRatingsRead, PermRatingsWrite,
+}
+
+// librarianPermissions additionally allow curating the catalogue created by other users.
+var librarianPermissions = append([]Permission{
+	PermAuthorsManage, PermBooksManage, PermRatingsManage,
+}, readerPermissions...)
+
+var adminPermissions = append([]Permission{PermUsersManage, PermWebhooksManage}, librarianPermissions...)
+
+var rolePermissions = map[string][]Permission{
+	models.RoleReader:    readerPermissions,
+	models.RoleLibrarian: librarianPermissions,
+	models.RoleAdmin:     adminPermissions,
+}
+
+// HasPermission reports whether the given role grants the permission.
+func HasPermission(role string, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionsFor lists the permissions granted to a role.
+func PermissionsFor(role string) []Permission {
+	return rolePermissions[role]
+}
+
+// IsPermission reports whether the string names a known permission.
+func IsPermission(name string) bool {
+	for _, p := range AllPermissions {
+		if string(p) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether a permission is among the scopes granted to an API key.
+func HasScope(scopes []string, permission Permission) bool {
+	for _, scope := range scopes {
+		if scope == string(permission) {
+			return true
+		}
+	}
+	return false
+}
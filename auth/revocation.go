@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	revokedTokenPrefix  = "auth:revoked:jti:"
+	revokedFamilyPrefix = "auth:revoked:family:"
+	refreshFamilyPrefix = "auth:refresh:family:"
+	userFamiliesPrefix  = "auth:user:families:"
+)
+
+var (
+	ErrTokenRevoked = errors.New("token has been revoked")
+	ErrTokenReused  = errors.New("refresh token has already been used")
+)
+
+// rotateRefreshScript swaps the current refresh token id of a family, but only if the
+// presented id is still the current one. Returns 1 on success and 0 on reuse.
+var rotateRefreshScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+	return 1
+end
+return 0
+`)
+
+// RevokeToken adds a token id to the revocation list until the token would have expired anyway.
+func RevokeToken(jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if jti == "" || ttl <= 0 {
+		return nil
+	}
+	return config.Client.Set(config.Ctx, revokedTokenPrefix+jti, 1, ttl).Err()
+}
+
+// RevokeFamily revokes every access and refresh token issued from the same login.
+func RevokeFamily(family string) error {
+	if family == "" {
+		return nil
+	}
+
+	pipe := config.Client.TxPipeline()
+	pipe.Set(config.Ctx, revokedFamilyPrefix+family, 1, RefreshTokenTTL)
+	pipe.Del(config.Ctx, refreshFamilyPrefix+family)
+	_, err := pipe.Exec(config.Ctx)
+	return err
+}
+
+// IsRevoked reports whether the token itself or its family has been revoked.
+func IsRevoked(claims *Claims) (bool, error) {
+	keys := []string{revokedTokenPrefix + claims.ID}
+	if claims.Family != "" {
+		keys = append(keys, revokedFamilyPrefix+claims.Family)
+	}
+
+	n, err := config.Client.Exists(config.Ctx, keys...).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// RevokeAllForUser revokes every token family issued to a user, signing them out everywhere.
+func RevokeAllForUser(email string) error {
+	families, err := config.Client.SMembers(config.Ctx, userFamiliesPrefix+email).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, family := range families {
+		if err := RevokeFamily(family); err != nil {
+			return err
+		}
+	}
+
+	return config.Client.Del(config.Ctx, userFamiliesPrefix+email).Err()
+}
+
+// TrackRefreshToken records the refresh token id that is currently valid for a family,
+// and remembers the family against the user so that it can be revoked with RevokeAllForUser.
+func TrackRefreshToken(pair TokenPair) error {
+	pipe := config.Client.TxPipeline()
+	pipe.Set(config.Ctx, refreshFamilyPrefix+pair.Family, pair.RefreshID, RefreshTokenTTL)
+	pipe.SAdd(config.Ctx, userFamiliesPrefix+pair.Email, pair.Family)
+	pipe.Expire(config.Ctx, userFamiliesPrefix+pair.Email, RefreshTokenTTL)
+	_, err := pipe.Exec(config.Ctx)
+	return err
+}
+
+// RotateRefreshToken exchanges a valid refresh token for a new token pair in the same family.
+// The role is passed in rather than copied from the claims so that role changes take effect.
+// Presenting a refresh token that has already been rotated revokes the whole family.
+func RotateRefreshToken(claims *Claims, role string) (TokenPair, error) {
+	if claims.Type != RefreshTokenType {
+		return TokenPair{}, ErrInvalidToken
+	}
+
+	revoked, err := IsRevoked(claims)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if revoked {
+		return TokenPair{}, ErrTokenRevoked
+	}
+
+	pair, err := GenerateTokenPair(claims.Email, role, claims.Family)
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	swapped, err := rotateRefreshScript.Run(
+		config.Ctx,
+		config.Client,
+		[]string{refreshFamilyPrefix + claims.Family},
+		claims.ID,
+		pair.RefreshID,
+		RefreshTokenTTL.Milliseconds(),
+	).Int()
+	if err != nil {
+		return TokenPair{}, err
+	}
+
+	if swapped == 0 {
+		if err := RevokeFamily(claims.Family); err != nil {
+			return TokenPair{}, err
+		}
+		return TokenPair{}, ErrTokenReused
+	}
+
+	return pair, nil
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Env returns the value of an environment variable, or fallback when it is not set.
+func Env(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// EnvInt returns an environment variable as an int, or fallback when it is not set or invalid.
+func EnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// EnvBool returns an environment variable as a bool, or fallback when it is not set or invalid.
+func EnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// EnvFloat returns an environment variable as a float64, or fallback when it is not set or invalid.
+func EnvFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// EnvDuration returns an environment variable such as "15m" as a duration, or fallback when it is not set or invalid.
+func EnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// AppURL is the public base URL used to build links sent to users.
+func AppURL() string {
+	return strings.TrimRight(Env("APP_URL", "http://localhost:8080"), "/")
+}
+
+// PasswordResetTTL is how long a password reset link stays valid.
+func PasswordResetTTL() time.Duration {
+	return EnvDuration("PASSWORD_RESET_TTL", time.Hour)
+}
+
+// TrashRetention is how long deleted books, authors, users and ratings are kept before they are purged.
+func TrashRetention() time.Duration {
+	return EnvDuration("TRASH_RETENTION", 30*24*time.Hour)
+}
+
+// RatingPrior is what a book's score is worked out from before it has many ratings: it counts as
+// having weight ratings of mean, so that a single rating of 5 does not make it the best book.
+func RatingPrior() (mean float64, weight float64) {
+	mean = EnvFloat("RATING_PRIOR_MEAN", 3)
+	weight = EnvFloat("RATING_PRIOR_WEIGHT", 5)
+	if weight < 0 {
+		weight = 0
+	}
+	return mean, weight
+}
+
+// ReviewAutoApprove publishes reviews that the moderation filter does not flag without waiting
+// for a moderator.
+func ReviewAutoApprove() bool {
+	return EnvBool("REVIEW_AUTO_APPROVE", true)
+}
+
+// EmailVerificationTTL is how long an email verification link stays valid.
+func EmailVerificationTTL() time.Duration {
+	return EnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+}
+
+// RequireEmailVerification makes login refuse accounts that have not verified their email address.
+func RequireEmailVerification() bool {
+	return EnvBool("REQUIRE_EMAIL_VERIFICATION", false)
+}
+
+// LoginThrottle holds the thresholds for brute-force protection on login.
+type LoginThrottle struct {
+	// MaxAccountFailures locks an account after this many failed logins within FailureWindow.
+	MaxAccountFailures int
+	// MaxIPFailures locks out a client IP after this many failed logins, across all accounts, within FailureWindow.
+	MaxIPFailures   int
+	FailureWindow   time.Duration
+	LockoutDuration time.Duration
+	// After DelayAfter failures an account must wait BaseDelay before the next attempt, doubling with each further failure up to MaxDelay.
+	DelayAfter int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+func LoginThrottleSettings() LoginThrottle {
+	return LoginThrottle{
+		MaxAccountFailures: EnvInt("LOGIN_MAX_ACCOUNT_FAILURES", 5),
+		MaxIPFailures:      EnvInt("LOGIN_MAX_IP_FAILURES", 50),
+		FailureWindow:      EnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		LockoutDuration:    EnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		DelayAfter:         EnvInt("LOGIN_DELAY_AFTER", 2),
+		BaseDelay:          EnvDuration("LOGIN_BASE_DELAY", time.Second),
+		MaxDelay:           EnvDuration("LOGIN_MAX_DELAY", time.Minute),
+	}
+}
+
+// PasswordResetThrottle limits how often password reset links can be asked for.
+type PasswordResetThrottle struct {
+	// MaxPerEmail links are sent to an address within Window, further requests are quietly dropped.
+	MaxPerEmail int
+	// MaxPerIP requests, for any addresses, are accepted from a client IP within Window.
+	MaxPerIP int
+	Window   time.Duration
+}
+
+func PasswordResetThrottleSettings() PasswordResetThrottle {
+	return PasswordResetThrottle{
+		MaxPerEmail: EnvInt("PASSWORD_RESET_MAX_PER_EMAIL", 3),
+		MaxPerIP:    EnvInt("PASSWORD_RESET_MAX_PER_IP", 20),
+		Window:      EnvDuration("PASSWORD_RESET_WINDOW", time.Hour),
+	}
+}
+
+// Recommendations controls how books are found to be similar from their ratings.
+type Recommendations struct {
+	// MinCoRatings is how many readers must have rated two books before they can be similar.
+	MinCoRatings int
+	// Neighbours is how many of the most similar books are kept for each book.
+	Neighbours int
+	// RebuildInterval is how often the similarities are worked out again from the ratings.
+	RebuildInterval time.Duration
+}
+
+func RecommendationSettings() Recommendations {
+	return Recommendations{
+		MinCoRatings:    EnvInt("RECOMMENDATIONS_MIN_CO_RATINGS", 2),
+		Neighbours:      EnvInt("RECOMMENDATIONS_NEIGHBOURS", 50),
+		RebuildInterval: EnvDuration("RECOMMENDATIONS_REBUILD_INTERVAL", time.Hour),
+	}
+}
+
+// WebhookRetry controls how failed webhook deliveries are retried.
+type WebhookRetry struct {
+	// MaxAttempts is how many times a delivery is tried before it is dead-lettered.
+	MaxAttempts int
+	// A failed delivery waits BaseDelay before it is retried, doubling with each attempt up to MaxDelay, with jitter.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// DisableAfter disables an endpoint once this many attempts in a row have failed.
+	DisableAfter int
+	// PollInterval is how often deliveries whose job was lost are looked for.
+	PollInterval time.Duration
+	// RequeueAfter is how long a delivery may be overdue before its job is taken to be lost.
+	RequeueAfter time.Duration
+}
+
+func WebhookRetrySettings() WebhookRetry {
+	return WebhookRetry{
+		MaxAttempts:  EnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		BaseDelay:    EnvDuration("WEBHOOK_RETRY_BASE_DELAY", 30*time.Second),
+		MaxDelay:     EnvDuration("WEBHOOK_RETRY_MAX_DELAY", 6*time.Hour),
+		DisableAfter: EnvInt("WEBHOOK_DISABLE_AFTER", 25),
+		PollInterval: EnvDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second),
+		RequeueAfter: EnvDuration("WEBHOOK_REQUEUE_AFTER", 15*time.Minute),
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"context"
 	"log"
+	"os"
 
 	"github.com/go-redis/redis/v8"
 )
@@ -13,10 +14,15 @@ var (
 )
 
 func ConnectToRedisServer() {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+
 	Client = redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379", // Use the address of the Redis service
-		Password: "",               // No password set
-		DB:       0,                // Use default DB
+		Addr:     addr,                        // Use the address of the Redis service
+		Password: os.Getenv("REDIS_PASSWORD"), // No password set by default
+		DB:       0,                           // Use default DB
 	})
 
 	// Test the connection
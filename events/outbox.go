@@ -0,0 +1,32 @@
+package events
+
+import (
+	"encoding/json"
+
+	"github.com/fokosun/go-rest-api/models"
+	"gorm.io/gorm"
+)
+
+// The aggregates events are recorded for. Events for the same aggregate are published in order.
+const (
+	AggregateUser   = "user"
+	AggregateAuthor = "author"
+	AggregateBook   = "book"
+	AggregateRating = "rating"
+)
+
+// Record writes a domain event to the outbox with tx, so that it is only ever published
+// when the change it describes is committed.
+func Record(tx *gorm.DB, aggregateType string, aggregateID uint, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return tx.Create(&models.OutboxEvent{
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		EventType:     eventType,
+		Payload:       string(payload),
+	}).Error
+}
@@ -0,0 +1,218 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/models"
+	"gorm.io/gorm"
+)
+
+// relayLockKey is the Postgres advisory lock that makes sure only one relay claims events at a
+// time. It is only held while a batch is claimed, not while the batch is published.
+const relayLockKey = 7240001
+
+// Relay publishes outbox events to its sinks. An event is only marked as published once every
+// sink has accepted it, and when one fails the aggregate's later events wait until it succeeds,
+// or until the relay gives up on it after MaxAttempts.
+type Relay struct {
+	Sinks        []Sink
+	PollInterval time.Duration
+	BatchSize    int
+	// Retention is how long published events are kept before they are deleted, zero keeps them forever
+	Retention time.Duration
+	// RetryBackoff is how long a failed event waits before it is tried again, doubled on every
+	// attempt up to MaxBackoff
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+	MaxAttempts  int
+	// ClaimTimeout is how long a claimed batch is left to the relay publishing it before
+	// another relay may take it over
+	ClaimTimeout time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewRelay creates a relay for the given sinks, configured from the environment:
+//
+//	OUTBOX_POLL_INTERVAL  how often the outbox is checked for new events, defaults to 1s
+//	OUTBOX_BATCH_SIZE     events published per check, defaults to 100
+//	OUTBOX_RETENTION      how long published events are kept, defaults to 168h
+//	OUTBOX_RETRY_BACKOFF  how long a failed event first waits, defaults to 1s
+//	OUTBOX_MAX_BACKOFF    the longest a failed event waits, defaults to 1h
+//	OUTBOX_MAX_ATTEMPTS   attempts before an event is dead-lettered, defaults to 10
+//	OUTBOX_CLAIM_TIMEOUT  how long a relay may take to publish a batch, defaults to 1m
+func NewRelay(sinks ...Sink) *Relay {
+	return &Relay{
+		Sinks:        sinks,
+		PollInterval: config.EnvDuration("OUTBOX_POLL_INTERVAL", time.Second),
+		BatchSize:    config.EnvInt("OUTBOX_BATCH_SIZE", 100),
+		Retention:    config.EnvDuration("OUTBOX_RETENTION", 7*24*time.Hour),
+		RetryBackoff: config.EnvDuration("OUTBOX_RETRY_BACKOFF", time.Second),
+		MaxBackoff:   config.EnvDuration("OUTBOX_MAX_BACKOFF", time.Hour),
+		MaxAttempts:  config.EnvInt("OUTBOX_MAX_ATTEMPTS", 10),
+		ClaimTimeout: config.EnvDuration("OUTBOX_CLAIM_TIMEOUT", time.Minute),
+	}
+}
+
+// Start publishes events in the background until Stop is called.
+func (r *Relay) Start() {
+	if r.PollInterval <= 0 {
+		r.PollInterval = time.Second
+	}
+
+	r.stop = make(chan struct{})
+	r.wg.Add(1)
+
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := r.RelayOnce(context.Background()); err != nil {
+				log.Printf("outbox: %v", err)
+			}
+
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop waits for the batch being published to finish and stops the relay.
+func (r *Relay) Stop() {
+	close(r.stop)
+	r.wg.Wait()
+}
+
+// RelayOnce publishes a batch of unpublished events in order and reports how many were published.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	batch, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	blocked := map[string]bool{}
+	for _, event := range batch {
+		aggregate := fmt.Sprintf("%s:%d", event.AggregateType, event.AggregateID)
+		if blocked[aggregate] {
+			// Claimed behind an event that failed, which now holds it back instead
+			if err := r.update(ctx, event, map[string]interface{}{"next_attempt_at": nil}); err != nil {
+				return published, err
+			}
+			continue
+		}
+
+		if err := r.publish(ctx, event); err != nil {
+			log.Printf("outbox: event %d (%s): %v", event.ID, event.EventType, err)
+
+			updates := map[string]interface{}{
+				"attempts":        gorm.Expr("attempts + 1"),
+				"last_error":      err.Error(),
+				"next_attempt_at": time.Now().Add(r.backoff(event.Attempts + 1)),
+			}
+			if r.MaxAttempts > 0 && event.Attempts+1 >= r.MaxAttempts {
+				// Dead-lettered, so that the aggregate's later events are not held back forever
+				log.Printf("outbox: event %d (%s) gave up after %d attempts", event.ID, event.EventType, event.Attempts+1)
+				updates["dead_at"] = time.Now()
+				updates["next_attempt_at"] = nil
+			} else {
+				blocked[aggregate] = true
+			}
+
+			if err := r.update(ctx, event, updates); err != nil {
+				return published, err
+			}
+			continue
+		}
+
+		if err := r.update(ctx, event, map[string]interface{}{
+			"attempts":        gorm.Expr("attempts + 1"),
+			"published_at":    time.Now(),
+			"next_attempt_at": nil,
+		}); err != nil {
+			return published, err
+		}
+		published++
+	}
+
+	if r.Retention > 0 {
+		err = config.DB.WithContext(ctx).Where("published_at < ?", time.Now().Add(-r.Retention)).Delete(&models.OutboxEvent{}).Error
+	}
+	return published, err
+}
+
+// claim picks the next batch of events and holds them back from other relays for ClaimTimeout.
+// Events behind an earlier event of their aggregate that is waiting for a retry, or that
+// another relay has claimed, are left out, so a blocked aggregate never takes up the batch.
+func (r *Relay) claim(ctx context.Context) ([]models.OutboxEvent, error) {
+	batch := []models.OutboxEvent{}
+
+	err := config.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", relayLockKey).Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			// Another instance is claiming
+			return nil
+		}
+
+		now := time.Now()
+		err := tx.Where("published_at IS NULL AND dead_at IS NULL AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", now).
+			Where(`NOT EXISTS (
+				SELECT 1 FROM outbox earlier
+				WHERE earlier.aggregate_type = outbox.aggregate_type AND earlier.aggregate_id = outbox.aggregate_id
+					AND earlier.id < outbox.id AND earlier.published_at IS NULL AND earlier.dead_at IS NULL
+					AND earlier.next_attempt_at > ?
+			)`, now).
+			Order("id").Limit(r.BatchSize).Find(&batch).Error
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+
+		ids := make([]uint, len(batch))
+		for i, event := range batch {
+			ids[i] = event.ID
+		}
+		return tx.Model(&models.OutboxEvent{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(r.ClaimTimeout)).Error
+	})
+
+	return batch, err
+}
+
+func (r *Relay) update(ctx context.Context, event models.OutboxEvent, updates map[string]interface{}) error {
+	return config.DB.WithContext(ctx).Model(&event).UpdateColumns(updates).Error
+}
+
+// backoff is how long an event waits after its attempts-th failure.
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := r.RetryBackoff
+	for i := 1; i < attempts && delay < r.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if r.MaxBackoff > 0 && delay > r.MaxBackoff {
+		return r.MaxBackoff
+	}
+	return delay
+}
+
+func (r *Relay) publish(ctx context.Context, event models.OutboxEvent) error {
+	for _, sink := range r.Sinks {
+		if err := sink.Publish(ctx, event); err != nil {
+			return fmt.Errorf("%s: %w", sink.Name(), err)
+		}
+	}
+	return nil
+}
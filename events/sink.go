@@ -0,0 +1,89 @@
+package events
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/go-redis/redis/v8"
+)
+
+// Sink is somewhere the relay publishes outbox events to. Publishing is at least once,
+// so sinks may see the same event again and should use its ID to tell.
+type Sink interface {
+	Name() string
+	Publish(ctx context.Context, event models.OutboxEvent) error
+}
+
+// Handler is an in-process consumer of events published to a Bus.
+type Handler func(ctx context.Context, event models.OutboxEvent) error
+
+// Bus publishes events to handlers in the same process.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+// AllEvents subscribes a handler to every event type.
+const AllEvents = "*"
+
+// DefaultBus is the bus in-process consumers subscribe to when the relay publishes to "bus".
+var DefaultBus = NewBus()
+
+func NewBus() *Bus {
+	return &Bus{handlers: map[string][]Handler{}}
+}
+
+// Subscribe registers a handler for an event type, or for every event with AllEvents.
+func (b *Bus) Subscribe(eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+func (b *Bus) Name() string {
+	return "bus"
+}
+
+// Publish runs the handlers for the event in turn and stops at the first that fails.
+func (b *Bus) Publish(ctx context.Context, event models.OutboxEvent) error {
+	b.mu.RLock()
+	handlers := append(append([]Handler{}, b.handlers[event.EventType]...), b.handlers[AllEvents]...)
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		if err := handler(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RedisStreamSink appends events to a Redis stream, trimmed to roughly MaxLen entries.
+type RedisStreamSink struct {
+	Stream string
+	MaxLen int64
+}
+
+func (s RedisStreamSink) Name() string {
+	return "redis"
+}
+
+func (s RedisStreamSink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	return config.Client.XAdd(ctx, &redis.XAddArgs{
+		Stream: s.Stream,
+		MaxLen: s.MaxLen,
+		Approx: s.MaxLen > 0,
+		Values: map[string]interface{}{
+			"id":             event.ID,
+			"type":           event.EventType,
+			"aggregate_type": event.AggregateType,
+			"aggregate_id":   strconv.FormatUint(uint64(event.AggregateID), 10),
+			"payload":        event.Payload,
+			"created_at":     event.CreatedAt.UTC().Format(time.RFC3339Nano),
+		},
+	}).Err()
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/gin-gonic/gin"
+)
+
+func CreateAPIKey(c *gin.Context) {
+	var keyDetails struct {
+		Name      string     `json:"name" binding:"required"`
+		Scopes    []string   `json:"scopes" binding:"required,min=1"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	if err := c.ShouldBindJSON(&keyDetails); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	user := authenticatedUser(c)
+
+	// A key can never do more than its owner
+	for _, scope := range keyDetails.Scopes {
+		if !auth.IsPermission(scope) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Message: "Unknown scope: " + scope})
+			return
+		}
+		if !auth.HasPermission(user.Role, auth.Permission(scope)) {
+			c.JSON(http.StatusForbidden, ErrorResponse{Message: "You cannot grant a scope you do not have: " + scope})
+			return
+		}
+	}
+
+	if keyDetails.ExpiresAt != nil && !keyDetails.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: "expires_at must be in the future"})
+		return
+	}
+
+	key := auth.NewAPIKey()
+	apiKey := models.APIKey{
+		UserID:    user.ID,
+		Name:      keyDetails.Name,
+		Prefix:    key[:len(auth.APIKeyPrefix)+8],
+		KeyHash:   auth.HashToken(key),
+		ExpiresAt: keyDetails.ExpiresAt,
+	}
+	apiKey.SetScopes(keyDetails.Scopes)
+
+	if err := config.DB.Create(&apiKey).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	// The key itself is only ever shown once
+	c.JSON(http.StatusCreated, CreatedAPIKey{APIKeyResponse: NewAPIKeyResponse(apiKey), Key: key})
+}
+
+func GetAPIKeys(c *gin.Context) {
+	apiKeys := []models.APIKey{}
+	config.DB.Where("user_id = ?", authenticatedUser(c).ID).Order("created_at desc").Find(&apiKeys)
+
+	response := make([]APIKeyResponse, len(apiKeys))
+	for i, apiKey := range apiKeys {
+		response[i] = NewAPIKeyResponse(apiKey)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func RevokeAPIKey(c *gin.Context) {
+	var apiKey models.APIKey
+
+	// Users can only see and revoke their own keys
+	if err := config.DB.Where("id = ? AND user_id = ?", c.Param("id"), authenticatedUser(c).ID).First(&apiKey).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: "API key not found"})
+		return
+	}
+
+	if apiKey.RevokedAt == nil {
+		now := time.Now()
+		if err := config.DB.Model(&apiKey).Update("revoked_at", &now).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "API key revoked"})
+}
@@ -1,11 +1,17 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 
+	"github.com/fokosun/go-rest-api/auth"
 	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/events"
+	"github.com/fokosun/go-rest-api/listing"
 	"github.com/fokosun/go-rest-api/models"
+	"github.com/fokosun/go-rest-api/webhooks"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func CreateAuthor(c *gin.Context) {
@@ -36,19 +42,41 @@ func CreateAuthor(c *gin.Context) {
 		return
 	}
 
-	// Save the author to the database
-	result := config.DB.Create(&author)
-	if result.Error != nil {
-		c.JSON(http.StatusNotFound, ErrorResponse{Message: result.Error.Error()})
+	// Save the author to the database, together with the event announcing it
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&author).Error; err != nil {
+			return err
+		}
+		return events.Record(tx, events.AggregateAuthor, author.ID, webhooks.EventAuthorCreated, author)
+	})
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: err.Error()})
 		return
 	}
-
 	c.JSON(http.StatusCreated, author)
 }
 
+var authorListing = listing.Spec{
+	Sorts: map[string]listing.Field{
+		"id":         {Column: "id", Type: listing.Int},
+		"firstname":  {Column: "firstname", Type: listing.String},
+		"lastname":   {Column: "lastname", Type: listing.String},
+		"created_at": {Column: "created_at", Type: listing.Time},
+		"updated_at": {Column: "updated_at", Type: listing.Time},
+	},
+	Filters: createdFilters(map[string]listing.Filter{
+		"created_by": {Column: "created_by", Op: "=", Type: listing.Int},
+		"firstname":  {Column: "firstname", Op: "=", Type: listing.String},
+		"lastname":   {Column: "lastname", Op: "=", Type: listing.String},
+	}),
+	DefaultSort: "id",
+}
+
 func GetAuthors(c *gin.Context) {
 	authors := []models.Author{}
-	config.DB.Find(&authors)
+	if !listPage(c, authorListing, config.DB, &authors) {
+		return
+	}
 	c.JSON(http.StatusOK, authors)
 }
 
@@ -61,6 +89,87 @@ func GetAuthor(c *gin.Context) {
 	c.JSON(http.StatusOK, author)
 }
 
+// authorBookListing is a list spec for the books of an author, which can also be filtered by the
+// role the author has on them.
+func authorBookListing(authorID uint) listing.Spec {
+	books := listing.Spec{Sorts: bookListing.Sorts, Filters: map[string]listing.Filter{}, DefaultSort: bookListing.DefaultSort}
+	for name, filter := range bookListing.Filters {
+		books.Filters[name] = filter
+	}
+	books.Filters["role"] = listing.Filter{
+		Where: fmt.Sprintf("books.id IN (SELECT book_id FROM book_authors WHERE author_id = %d AND role = ?)", authorID),
+		Type:  listing.String,
+	}
+	return books
+}
+
+// GetAuthorBooks lists the books an author has any role on, as author, editor, translator or
+// illustrator.
+func GetAuthorBooks(c *gin.Context) {
+	var author models.Author
+	if err := config.DB.First(&author, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: "Author not found"})
+		return
+	}
+
+	books := []models.Book{}
+	query := preloadContributors(config.DB.Preload("Author", func(db *gorm.DB) *gorm.DB {
+		return db.Select("ID", "Firstname", "Lastname", "Gravatar", "CreatedBy", "UpdatedBy", "CreatedAt", "UpdatedAt")
+	})).Preload("Ratings").Joins("LEFT JOIN book_rating_stats ON book_rating_stats.book_id = books.id").
+		Where("books.id IN (?)", models.BooksBy(config.DB, author.ID))
+	if !listPage(c, authorBookListing(author.ID), query, &books) {
+		return
+	}
+
+	for i := range books {
+		stats := bookRatings(books[i])
+		books[i].Ratings = &stats
+	}
+
+	c.JSON(http.StatusOK, books)
+}
+
+func DeleteAuthor(c *gin.Context) {
+	var author models.Author
+	if err := config.DB.First(&author, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: "Author not found"})
+		return
+	}
+
+	// Only the user who created the author, or a librarian, can delete it
+	if !canModify(authenticatedUser(c), author.CreatedBy, auth.PermAuthorsManage) {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: NotAuthorizedMessage})
+		return
+	}
+
+	var books int64
+	config.DB.Model(&models.Book{}).Where("author_id = ? OR id IN (?)", author.ID, models.BooksBy(config.DB, author.ID)).Count(&books)
+	if books > 0 {
+		c.JSON(http.StatusConflict, ErrorResponse{Message: "The author still has books. Delete them first."})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&author).Error; err != nil {
+			return err
+		}
+		return events.Record(tx, events.AggregateAuthor, author.ID, webhooks.EventAuthorDeleted, gin.H{"id": author.ID})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Author deleted"})
+}
+
+// AuthorInput is the part of an author that can be edited.
+type AuthorInput struct {
+	Firstname string `json:"firstname"`
+	Lastname  string `json:"lastname"`
+	Gravatar  string `json:"gravatar"`
+}
+
 func EditAuthor(c *gin.Context) {
 	var author models.Author
 	var user models.User
@@ -68,10 +177,6 @@ func EditAuthor(c *gin.Context) {
 		c.JSON(http.StatusNotFound, ErrorResponse{Message: "User not found"})
 		return
 	}
-	if err := c.ShouldBindJSON(&author); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
-		return
-	}
 
 	userEmail := c.MustGet("email").(string)
 	if err := config.DB.Where("email = ?", userEmail).First(&user).Error; err != nil {
@@ -79,11 +184,42 @@ func EditAuthor(c *gin.Context) {
 		return
 	}
 
+	// Only the user who created the author, or a librarian, can edit it
+	if !canModify(user, author.CreatedBy, auth.PermAuthorsManage) {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: NotAuthorizedMessage})
+		return
+	}
+
+	// Fields left out of the request keep their value
+	input := AuthorInput{Firstname: author.Firstname, Lastname: author.Lastname, Gravatar: author.Gravatar}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		return
+	}
+	author.Firstname = input.Firstname
+	author.Lastname = input.Lastname
+	author.Gravatar = input.Gravatar
+
+	if err := author.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ValidationErrorResponse{ValidationErrorMessage: err.Error()})
+		return
+	}
+
 	if err := author.SetUpdatedBy(user.ID); err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	config.DB.Save(&author)
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&author).Select("firstname", "lastname", "gravatar", "updated_by", "updated_at").Updates(&author).Error; err != nil {
+			return err
+		}
+		return events.Record(tx, events.AggregateAuthor, author.ID, webhooks.EventAuthorUpdated, author)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
 	c.JSON(http.StatusOK, author)
 }
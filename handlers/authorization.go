@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/gin-gonic/gin"
+)
+
+const NotAuthorizedMessage = "You are not authorized to perform this action."
+
+// authenticatedUser returns the user that AuthMiddleware stored on the context.
+func authenticatedUser(c *gin.Context) models.User {
+	return c.MustGet("user").(models.User)
+}
+
+// canModify reports whether the user owns a resource or holds the permission to manage it for others.
+func canModify(user models.User, ownerID uint, manage auth.Permission) bool {
+	return user.ID == ownerID || auth.HasPermission(user.Role, manage)
+}
@@ -1,21 +1,61 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 
+	"github.com/fokosun/go-rest-api/auth"
 	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/events"
+	"github.com/fokosun/go-rest-api/isbn"
+	"github.com/fokosun/go-rest-api/listing"
 	"github.com/fokosun/go-rest-api/models"
+	"github.com/fokosun/go-rest-api/webhooks"
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
+var bookListing = listing.Spec{
+	Sorts: map[string]listing.Field{
+		"id":         {Column: "id", Type: listing.Int},
+		"title":      {Column: "title", Type: listing.String},
+		"isbn":       {Column: "isbn", Type: listing.String},
+		"created_at": {Column: "created_at", Type: listing.Time},
+		"updated_at": {Column: "updated_at", Type: listing.Time},
+		// The stats of the ratings are joined by GetBooks. A book nobody has rated may have none, and
+		// sorts as it is shown, see models.NoRatingStats.
+		"rating": {Column: "book_rating_stats.score", Type: listing.Float, Default: func() interface{} {
+			return models.NoRatingStats(0).Score
+		}},
+		"rating_count": {Column: "book_rating_stats.count", Type: listing.Int, Default: func() interface{} {
+			return int64(0)
+		}},
+	},
+	Filters: createdFilters(map[string]listing.Filter{
+		"author_id": {Column: "author_id", Op: "=", Type: listing.Int},
+		"user_id":   {Column: "user_id", Op: "=", Type: listing.Int},
+		"isbn":      {Column: "isbn", Op: "=", Type: listing.String},
+	}),
+	DefaultSort: "id",
+}
+
 func GetBooks(c *gin.Context) {
 	books := []models.Book{}
-	config.DB.Find(&books)
 
-	config.DB.Preload("Author", func(db *gorm.DB) *gorm.DB {
+	query := preloadContributors(config.DB.Preload("Author", func(db *gorm.DB) *gorm.DB {
 		return db.Select("ID", "Firstname", "Lastname", "Gravatar", "CreatedBy", "UpdatedBy", "CreatedAt", "UpdatedAt")
-	}).Find(&books)
+	})).Preload("Ratings").Joins("LEFT JOIN book_rating_stats ON book_rating_stats.book_id = books.id")
+	if !listPage(c, bookListing, query, &books) {
+		return
+	}
+
+	for i := range books {
+		stats := bookRatings(books[i])
+		books[i].Ratings = &stats
+	}
 
 	c.JSON(http.StatusOK, books)
 }
@@ -29,11 +69,33 @@ func GetBookByID(c *gin.Context) {
 
 	var qb models.Book
 
-	config.DB.Preload("Author", func(db *gorm.DB) *gorm.DB {
+	preloadContributors(config.DB.Preload("Author", func(db *gorm.DB) *gorm.DB {
+		return db.Select("ID", "Firstname", "Lastname", "Gravatar", "CreatedBy", "UpdatedBy", "CreatedAt", "UpdatedAt")
+	})).Preload("Ratings").First(&qb, book.ID)
+
+	c.Header("ETag", bookETag(qb))
+	c.JSON(http.StatusOK, NewBookResponse(qb))
+}
+
+// GetBookByISBN finds a book by its ISBN-10 or ISBN-13, with or without hyphens.
+func GetBookByISBN(c *gin.Context) {
+	canonical, err := isbn.Parse(c.Param("isbn"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, FieldErrorResponse{Message: "isbn is invalid", Errors: map[string]string{"isbn": err.Error()}})
+		return
+	}
+
+	var book models.Book
+	err = preloadContributors(config.DB.Preload("Author", func(db *gorm.DB) *gorm.DB {
 		return db.Select("ID", "Firstname", "Lastname", "Gravatar", "CreatedBy", "UpdatedBy", "CreatedAt", "UpdatedAt")
-	}).First(&qb, book.ID)
+	})).Preload("Ratings").Where("isbn = ?", canonical).First(&book).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: "Book not found"})
+		return
+	}
 
-	c.JSON(http.StatusOK, NewBook{ID: int(qb.ID), Title: qb.Title, Isbn: qb.Isbn, Author: qb.Author, CreatedAt: qb.CreatedAt, UpdatedAt: qb.UpdatedAt})
+	c.Header("ETag", bookETag(book))
+	c.JSON(http.StatusOK, NewBookResponse(book))
 }
 
 func CreateBook(c *gin.Context) {
@@ -48,32 +110,363 @@ func CreateBook(c *gin.Context) {
 		return
 	}
 
-	if book.UserID == 0 {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Message: "user_id is required"})
+	// The book belongs to whoever creates it, whatever the request said
+	book.UserID = authenticatedUser(c).ID
+
+	if book.AuthorID == 0 && len(book.Contributors) == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: "author_id is required"})
 		return
 	}
 
-	if book.AuthorID == 0 {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Message: "author_id is required"})
+	canonical, ok := validISBN(c, book.Isbn, 0)
+	if !ok {
 		return
 	}
+	book.Isbn = canonical
 
-	// also check if the author exist
-	var bookAuthor models.Author
-	if err := config.DB.First(&bookAuthor, book.AuthorID).Error; err != nil {
-		c.JSON(http.StatusNotFound, ErrorResponse{Message: "Author not found"})
+	// also check if the authors exist
+	contributors, ok := bookContributors(c, book.AuthorID, book.Contributors)
+	if !ok {
 		return
 	}
+	book.AuthorID = models.LeadAuthorID(contributors)
+	book.Contributors = nil
+
+	// Every book starts at the first version and without ratings, whatever the request said
+	book.Version = 1
+	book.Ratings = nil
 
-	config.DB.Create(&book)
+	// The event is only published if the book is saved
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&book).Error; err != nil {
+			return err
+		}
+
+		stats := models.NoRatingStats(book.ID)
+		if err := tx.Create(&stats).Error; err != nil {
+			return err
+		}
+		book.Ratings = &stats
+
+		if err := creditContributors(tx, &book, contributors); err != nil {
+			return err
+		}
+		return events.Record(tx, events.AggregateBook, book.ID, webhooks.EventBookCreated, NewBookResponse(book))
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
 
 	var qb models.Book
 
-	config.DB.Preload("Author", func(db *gorm.DB) *gorm.DB {
+	preloadContributors(config.DB.Preload("Author", func(db *gorm.DB) *gorm.DB {
 		return db.Select("ID", "Firstname", "Lastname", "Gravatar", "CreatedBy", "UpdatedBy", "CreatedAt", "UpdatedAt")
-	}).First(&qb, book.ID)
+	})).Preload("Ratings").First(&qb, book.ID)
 
-	c.JSON(http.StatusCreated, NewBook{ID: int(qb.ID), Title: qb.Title, Isbn: qb.Isbn, Author: qb.Author, CreatedAt: qb.CreatedAt, UpdatedAt: qb.UpdatedAt})
+	c.Header("ETag", bookETag(qb))
+	c.JSON(http.StatusCreated, NewBookResponse(qb))
+}
+
+const BookChangedMessage = "The book has been changed since you fetched it. Fetch it again and retry."
+
+// BookInput is the part of a book that its owner can change. Contributors replace the credits of
+// the book; without them, the book is credited to the author AuthorID alone.
+type BookInput struct {
+	Title        string              `json:"title"`
+	Isbn         string              `json:"isbn"`
+	AuthorID     uint                `json:"author_id,omitempty"`
+	Contributors []models.BookAuthor `json:"contributors,omitempty"`
+}
+
+// UpdateBook replaces a book's title, ISBN and contributors.
+func UpdateBook(c *gin.Context) {
+	updateBook(c, func(book models.Book) (BookInput, error) {
+		var input BookInput
+		err := decodeStrict(c.Request.Body, &input)
+		return input, err
+	})
+}
+
+// PatchBook changes the fields of a book that are present in a JSON Merge Patch (RFC 7396).
+func PatchBook(c *gin.Context) {
+	if contentType := c.ContentType(); contentType != "application/merge-patch+json" && contentType != "application/json" {
+		c.JSON(http.StatusUnsupportedMediaType, ErrorResponse{Message: "Send the changes as application/merge-patch+json"})
+		return
+	}
+
+	updateBook(c, func(book models.Book) (BookInput, error) {
+		var patch interface{}
+		if err := json.NewDecoder(c.Request.Body).Decode(&patch); err != nil {
+			return BookInput{}, err
+		}
+
+		current, err := json.Marshal(BookInput{Title: book.Title, Isbn: book.Isbn, AuthorID: book.AuthorID, Contributors: contributorInputs(book.Contributors)})
+		if err != nil {
+			return BookInput{}, err
+		}
+
+		var document map[string]interface{}
+		if err := json.Unmarshal(current, &document); err != nil {
+			return BookInput{}, err
+		}
+
+		// Changing the author or the contributors replaces the credits, so the other is left out
+		if changes, ok := patch.(map[string]interface{}); ok {
+			if _, ok := changes["contributors"]; ok {
+				delete(document, "author_id")
+			} else if _, ok := changes["author_id"]; ok {
+				delete(document, "contributors")
+			}
+		}
+
+		patched, err := json.Marshal(applyMergePatch(document, patch))
+		if err != nil {
+			return BookInput{}, err
+		}
+
+		var input BookInput
+		err = decodeStrict(bytes.NewReader(patched), &input)
+		return input, err
+	})
+}
+
+// errBookChanged means the book was updated between being read and being written.
+var errBookChanged = errors.New("book changed")
+
+// updateBook saves the change made by the request to a book, refusing it when the request's
+// If-Match header does not name the current version of the book.
+func updateBook(c *gin.Context, change func(book models.Book) (BookInput, error)) {
+	var book models.Book
+	var user models.User
+
+	if err := preloadContributors(config.DB).First(&book, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: "Book not found"})
+		return
+	}
+
+	if err := config.DB.Where("email = ?", c.MustGet("email")).First(&user).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	// Only the original creator of the book, or a librarian or admin, can update it, the same as deleting it
+	if !canModify(user, book.UserID, auth.PermBooksManage) {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: NotAuthorizedMessage})
+		return
+	}
+
+	if !matchesETag(c.GetHeader("If-Match"), bookETag(book)) {
+		c.JSON(http.StatusPreconditionFailed, ErrorResponse{Message: BookChangedMessage})
+		return
+	}
+
+	input, err := change(book)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	if input.Title == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: "title is required"})
+		return
+	}
+
+	if input.AuthorID == 0 && len(input.Contributors) == 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: "author_id is required"})
+		return
+	}
+
+	canonical, ok := validISBN(c, input.Isbn, book.ID)
+	if !ok {
+		return
+	}
+	input.Isbn = canonical
+
+	contributors, ok := bookContributors(c, input.AuthorID, input.Contributors)
+	if !ok {
+		return
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		// The version in the condition makes sure nobody saved the book since it was read
+		result := tx.Model(&models.Book{}).Where("id = ? AND version = ?", book.ID, book.Version).Updates(map[string]interface{}{
+			"title":     input.Title,
+			"isbn":      input.Isbn,
+			"author_id": models.LeadAuthorID(contributors),
+			"version":   gorm.Expr("version + 1"),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errBookChanged
+		}
+
+		if err := tx.Where("book_id = ?", book.ID).Delete(&models.BookAuthor{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Preload("Ratings").First(&book, book.ID).Error; err != nil {
+			return err
+		}
+		if err := creditContributors(tx, &book, contributors); err != nil {
+			return err
+		}
+
+		return events.Record(tx, events.AggregateBook, book.ID, webhooks.EventBookUpdated, NewBookResponse(book))
+	})
+	if errors.Is(err, errBookChanged) {
+		c.JSON(http.StatusPreconditionFailed, ErrorResponse{Message: BookChangedMessage})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.Header("ETag", bookETag(book))
+	c.JSON(http.StatusOK, NewBookResponse(book))
+}
+
+// bookContributors returns the credits sent for a book, in order and with their authors. A book
+// sent with only an author_id is credited to that author. It responds with what is wrong instead
+// when a credit is invalid or names an author that does not exist.
+func bookContributors(c *gin.Context, authorID uint, sent []models.BookAuthor) ([]models.BookAuthor, bool) {
+	if len(sent) == 0 {
+		sent = []models.BookAuthor{{AuthorID: authorID, Role: models.ContributorAuthor}}
+	}
+
+	contributors := make([]models.BookAuthor, len(sent))
+	type credit struct {
+		authorID uint
+		role     string
+	}
+	credited := map[credit]bool{}
+	ids := []uint{}
+	for i, contributor := range sent {
+		if contributor.Role == "" {
+			contributor.Role = models.ContributorAuthor
+		}
+		contributor = models.BookAuthor{AuthorID: contributor.AuthorID, Role: contributor.Role, Position: i}
+		if err := contributor.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, ValidationErrorResponse{ValidationErrorMessage: err.Error()})
+			return nil, false
+		}
+
+		if credited[credit{contributor.AuthorID, contributor.Role}] {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Message: "contributors names an author twice with the same role"})
+			return nil, false
+		}
+		credited[credit{contributor.AuthorID, contributor.Role}] = true
+
+		contributors[i] = contributor
+		ids = append(ids, contributor.AuthorID)
+	}
+
+	if authorID != 0 && authorID != models.LeadAuthorID(contributors) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: "author_id must be the first author of the contributors"})
+		return nil, false
+	}
+
+	authors := []models.Author{}
+	if err := config.DB.Select("ID", "Firstname", "Lastname", "Gravatar", "CreatedBy", "UpdatedBy", "CreatedAt", "UpdatedAt").Find(&authors, ids).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return nil, false
+	}
+
+	byID := map[uint]models.Author{}
+	for _, author := range authors {
+		byID[author.ID] = author
+	}
+	for i := range contributors {
+		author, ok := byID[contributors[i].AuthorID]
+		if !ok {
+			c.JSON(http.StatusNotFound, ErrorResponse{Message: "Author not found"})
+			return nil, false
+		}
+		contributors[i].Author = author
+	}
+
+	return contributors, true
+}
+
+// creditContributors saves the credits of a book, which must have none, and sets them and its
+// lead author on the book.
+func creditContributors(tx *gorm.DB, book *models.Book, contributors []models.BookAuthor) error {
+	for i := range contributors {
+		contributors[i].BookID = book.ID
+	}
+	if err := tx.Omit("Author").Create(&contributors).Error; err != nil {
+		return err
+	}
+
+	book.Contributors = contributors
+	for _, contributor := range contributors {
+		if contributor.AuthorID == book.AuthorID {
+			book.Author = contributor.Author
+			break
+		}
+	}
+	return nil
+}
+
+// contributorInputs are the credits of a book as they are sent to change it.
+func contributorInputs(contributors []models.BookAuthor) []models.BookAuthor {
+	inputs := make([]models.BookAuthor, len(contributors))
+	for i, contributor := range contributors {
+		inputs[i] = models.BookAuthor{AuthorID: contributor.AuthorID, Role: contributor.Role}
+	}
+	return inputs
+}
+
+// preloadContributors loads the credits of books in order, with their authors.
+func preloadContributors(db *gorm.DB) *gorm.DB {
+	return db.Preload("Contributors", func(db *gorm.DB) *gorm.DB {
+		return db.Order("position")
+	}).Preload("Contributors.Author", func(db *gorm.DB) *gorm.DB {
+		return db.Select("ID", "Firstname", "Lastname", "Gravatar", "CreatedBy", "UpdatedBy", "CreatedAt", "UpdatedAt")
+	})
+}
+
+const ISBNTakenMessage = "Another book already has this ISBN"
+
+// validISBN returns the ISBN-13 of the ISBN sent for a book. It responds with what is wrong
+// instead when the ISBN is invalid, or belongs to another book than bookID.
+// A book does not need an ISBN, so an empty one is valid.
+func validISBN(c *gin.Context, value string, bookID uint) (string, bool) {
+	if isbn.Normalize(value) == "" {
+		return "", true
+	}
+
+	canonical, err := isbn.Parse(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, FieldErrorResponse{Message: "isbn is invalid", Errors: map[string]string{"isbn": err.Error()}})
+		return "", false
+	}
+
+	var count int64
+	if err := config.DB.Model(&models.Book{}).Where("isbn = ? AND id <> ?", canonical, bookID).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return "", false
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, ErrorResponse{Message: ISBNTakenMessage})
+		return "", false
+	}
+
+	return canonical, true
+}
+
+// bookRatings returns the stats of a book's ratings that was loaded with them.
+func bookRatings(book models.Book) models.BookRatingStats {
+	if book.Ratings != nil {
+		return *book.Ratings
+	}
+	return models.NoRatingStats(book.ID)
+}
+
+func bookETag(book models.Book) string {
+	return fmt.Sprintf(`"%d"`, book.Version)
 }
 
 func DeleteBook(c *gin.Context) {
@@ -90,11 +483,22 @@ func DeleteBook(c *gin.Context) {
 		return
 	}
 
-	if user.ID != book.UserID {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: "You are not authorized to perform this action."})
+	// Only the original creator of the book, or a librarian, can delete it
+	if !canModify(user, book.UserID, auth.PermBooksManage) {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: NotAuthorizedMessage})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&book).Error; err != nil {
+			return err
+		}
+		return events.Record(tx, events.AggregateBook, book.ID, webhooks.EventBookDeleted, gin.H{"id": book.ID})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
 		return
 	}
 
-	config.DB.Delete(&book)
 	c.JSON(http.StatusOK, SuccessResponse{Message: "Book deleted"})
 }
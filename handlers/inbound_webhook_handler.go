@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/events"
+	"github.com/fokosun/go-rest-api/isbn"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/fokosun/go-rest-api/webhooks"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ReceiveWebhook hands a webhook verified by WebhookMiddleware to the handler registered for its source.
+func ReceiveWebhook(c *gin.Context) {
+	source, _ := webhooks.GetSource(c.Param("source"))
+
+	handler, ok := webhooks.HandlerFor(source.Name)
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: "No handler is registered for this webhook source"})
+		return
+	}
+
+	if err := handler(c.Request.Context(), c.MustGet("webhook_payload").([]byte)); err != nil {
+		// Let the source retry the same delivery once the problem is fixed
+		for _, nonce := range c.GetStringSlice("webhook_nonces") {
+			webhooks.ForgetNonce(source, nonce)
+		}
+
+		var invalid *webhooks.PayloadError
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusUnprocessableEntity, ErrorResponse{Message: invalid.Message})
+			return
+		}
+
+		log.Printf("webhooks: %s handler failed: %v", source.Name, err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Webhook processed"})
+}
+
+// CatalogueUpdate is sent by the upstream catalogue when the metadata of books changes.
+type CatalogueUpdate struct {
+	Books []struct {
+		Isbn  string `json:"isbn"`
+		Title string `json:"title"`
+	} `json:"books"`
+}
+
+// SyncCatalogue updates the titles of the books listed in a catalogue webhook, matched by ISBN.
+// Books we do not have are skipped, and nothing is changed unless the whole update applies.
+// A changed book gets a new version and a book.updated event, like any other update.
+func SyncCatalogue(ctx context.Context, payload []byte) error {
+	var update CatalogueUpdate
+	if err := json.Unmarshal(payload, &update); err != nil {
+		return webhooks.InvalidPayload("invalid catalogue update: %v", err)
+	}
+
+	return config.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, book := range update.Books {
+			if strings.TrimSpace(book.Isbn) == "" || strings.TrimSpace(book.Title) == "" {
+				return webhooks.InvalidPayload("every book in a catalogue update needs an isbn and a title")
+			}
+
+			canonical, err := isbn.Parse(book.Isbn)
+			if err != nil {
+				return webhooks.InvalidPayload("invalid isbn %q in catalogue update: %v", book.Isbn, err)
+			}
+
+			var changed []models.Book
+			if err := tx.Where("isbn = ? AND title <> ?", canonical, book.Title).Find(&changed).Error; err != nil {
+				return err
+			}
+
+			for _, existing := range changed {
+				err := tx.Model(&models.Book{}).Where("id = ?", existing.ID).Updates(map[string]interface{}{
+					"title":   book.Title,
+					"version": gorm.Expr("version + 1"),
+				}).Error
+				if err != nil {
+					return err
+				}
+
+				var updated models.Book
+				err = preloadContributors(tx.Preload("Author", func(db *gorm.DB) *gorm.DB {
+					return db.Select("ID", "Firstname", "Lastname", "Gravatar", "CreatedBy", "UpdatedBy", "CreatedAt", "UpdatedAt")
+				})).Preload("Ratings").First(&updated, existing.ID).Error
+				if err != nil {
+					return err
+				}
+
+				if err := events.Record(tx, events.AggregateBook, updated.ID, webhooks.EventBookUpdated, NewBookResponse(updated)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,14 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public keys tokens are signed with so that other services can verify them.
+func JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, auth.Keys().JWKS())
+}
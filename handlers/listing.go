@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/listing"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// createdFilters filter a list by when its items were created or last updated.
+func createdFilters(filters map[string]listing.Filter) map[string]listing.Filter {
+	filters["created_after"] = listing.Filter{Column: "created_at", Op: ">", Type: listing.Time}
+	filters["created_before"] = listing.Filter{Column: "created_at", Op: "<", Type: listing.Time}
+	filters["updated_after"] = listing.Filter{Column: "updated_at", Op: ">", Type: listing.Time}
+	filters["updated_before"] = listing.Filter{Column: "updated_at", Op: "<", Type: listing.Time}
+	return filters
+}
+
+// listPage loads the page of a list asked for by the query string into dest and links to the
+// pages around it in the Link header. It responds itself and reports false when it fails.
+func listPage(c *gin.Context, spec listing.Spec, query *gorm.DB, dest interface{}) bool {
+	params, err := spec.Parse(c.Request.URL.Query())
+	if err != nil {
+		var invalid *listing.Error
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Message: invalid.Message})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return false
+	}
+
+	page, err := listing.Find(query, params, dest)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return false
+	}
+
+	links := []string{pageLink(c, params.FirstQuery(), "first")}
+	if prev, ok := params.PrevQuery(); ok {
+		links = append(links, pageLink(c, prev, "prev"))
+	}
+	if page.HasMore {
+		links = append(links, pageLink(c, params.NextQuery(page), "next"))
+	}
+	c.Header("Link", strings.Join(links, ", "))
+
+	return true
+}
+
+func pageLink(c *gin.Context, query url.Values, rel string) string {
+	target := config.AppURL() + c.Request.URL.Path
+	if encoded := query.Encode(); encoded != "" {
+		target += "?" + encoded
+	}
+	return "<" + target + `>; rel="` + rel + `"`
+}
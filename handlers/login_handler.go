@@ -1,7 +1,12 @@
 package handlers
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/fokosun/go-rest-api/auth"
 	"github.com/fokosun/go-rest-api/config"
@@ -9,6 +14,13 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	EmailNotVerifiedMessage    = "Please verify your email address before logging in."
+	InvalidMFAChallengeMessage = "Invalid or expired MFA challenge. Please login and try again"
+	InvalidMFACodeMessage      = "Invalid authentication code"
+	TooManyLoginsMessage       = "Too many failed login attempts. Please try again later."
+)
+
 func Login(c *gin.Context) {
 	var loginDetails struct {
 		Email    string `json:"email" validate:"required,email"`
@@ -21,23 +33,251 @@ func Login(c *gin.Context) {
 	}
 
 	reqPassword := loginDetails.Password
+	clientIP := c.ClientIP()
+
+	retryAfter, err := auth.LoginRetryAfter(loginDetails.Email, clientIP)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+		return
+	}
+	if retryAfter > 0 {
+		tooManyLoginAttempts(c, retryAfter)
+		return
+	}
 
 	var user models.User
-	if err := config.DB.Where("email = ?", loginDetails.Email).First(&user).Error; err != nil {
+	if err := config.DB.Where("email = ?", loginDetails.Email).First(&user).Error; err != nil || !user.CheckPassword(reqPassword) {
+		// Unknown emails count too, so that they cannot be told apart from wrong passwords
+		if _, err := auth.RecordLoginFailure(loginDetails.Email, clientIP); err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+			return
+		}
+
 		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: "invalid email or password"})
 		return
 	}
 
-	if !user.CheckPassword(reqPassword) {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: "invalid email or password"})
+	// With MFA enabled the failures are only forgotten once the second factor is right too
+	if !user.IsMFAEnabled() {
+		if err := auth.ResetLoginFailures(user.Email); err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+			return
+		}
+	}
+
+	if config.RequireEmailVerification() && !user.IsEmailVerified() {
+		c.JSON(http.StatusForbidden, ErrorResponse{Message: EmailNotVerifiedMessage})
+		return
+	}
+
+	completeLogin(c, user)
+}
+
+func LoginMFA(c *gin.Context) {
+	var mfaDetails struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&mfaDetails); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	claims, err := auth.ParseMFAChallengeToken(mfaDetails.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: InvalidMFAChallengeMessage})
 		return
 	}
 
-	token, err := auth.GenerateJWT(user.Email)
+	revoked, err := auth.IsRevoked(claims)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
 		return
 	}
+	if revoked {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: InvalidMFAChallengeMessage})
+		return
+	}
+
+	var user models.User
+	if err := config.DB.Where("email = ?", claims.Email).First(&user).Error; err != nil || !user.IsMFAEnabled() {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: InvalidMFAChallengeMessage})
+		return
+	}
+
+	// A challenge issued before the lockout gets no more guesses, nor a right code, during it
+	retryAfter, err := auth.LoginRetryAfter(user.Email, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+		return
+	}
+	if retryAfter > 0 {
+		tooManyLoginAttempts(c, retryAfter)
+		return
+	}
+
+	verified, err := verifySecondFactor(user, mfaDetails.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	if !verified {
+		if err := auth.RecordMFAFailure(claims); err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+			return
+		}
+		// Wrong codes count towards the account lockout like wrong passwords, otherwise a
+		// new challenge could be asked for after every few guesses
+		if _, err := auth.RecordLoginFailure(user.Email, c.ClientIP()); err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: InvalidMFACodeMessage})
+		return
+	}
+
+	if err := auth.ResetLoginFailures(user.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	if err := auth.CompleteMFAChallenge(claims); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	issueTokens(c, user)
+}
+
+func RefreshToken(c *gin.Context) {
+	var refreshDetails struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&refreshDetails); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	claims, err := auth.ParseToken(refreshDetails.RefreshToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: "Invalid refresh token"})
+		return
+	}
+
+	// Ensure the user still exists before handing out new tokens
+	var user models.User
+	if err := config.DB.Where("email = ?", claims.Email).First(&user).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: "Session expired. Please login and try again"})
+		return
+	}
+
+	// The session may have been revoked from another device
+	var session models.Session
+	if err := config.DB.Where("id = ? AND user_id = ?", claims.Family, user.ID).First(&session).Error; err != nil || !session.IsActive() {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: "Session expired. Please login and try again"})
+		return
+	}
+
+	pair, err := auth.RotateRefreshToken(claims, user.Role)
+	switch {
+	case errors.Is(err, auth.ErrInvalidToken):
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: "Invalid refresh token"})
+		return
+	case errors.Is(err, auth.ErrTokenReused):
+		// The whole family has been revoked, make sure the session shows it
+		if err := revokeSession(claims.Family); err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+			return
+		}
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: "Session expired. Please login and try again"})
+		return
+	case errors.Is(err, auth.ErrTokenRevoked):
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: "Session expired. Please login and try again"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	if err := touchSession(c, pair); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, NewLoginToken(pair))
+}
+
+func Logout(c *gin.Context) {
+	tokenString := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if tokenString == "" {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: "Authorization header required"})
+		return
+	}
+
+	claims, err := auth.ParseToken(tokenString)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: "Invalid token"})
+		return
+	}
+
+	if err := auth.RevokeToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	// Revoking the session also invalidates the refresh token issued alongside this access token
+	if err := revokeSession(claims.Family); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Logged out"})
+}
+
+// completeLogin finishes a login once the user has proven who they are with a first factor.
+func completeLogin(c *gin.Context, user models.User) {
+	// With MFA enabled the first factor only earns a challenge, tokens are issued by LoginMFA
+	if user.IsMFAEnabled() {
+		challenge, err := auth.GenerateMFAChallengeToken(user.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, MFAChallenge{MFARequired: true, MFAToken: challenge})
+		return
+	}
+
+	issueTokens(c, user)
+}
+
+// issueTokens completes a login by handing the user a new access and refresh token pair.
+func issueTokens(c *gin.Context, user models.User) {
+	pair, err := auth.GenerateTokenPair(user.Email, user.Role, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	if err := auth.TrackRefreshToken(pair); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	if err := createSession(c, user, pair); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, NewLoginToken(pair))
+}
 
-	c.JSON(http.StatusOK, LoginToken{Token: token})
+// tooManyLoginAttempts rejects a throttled login, telling the client when to retry.
+func tooManyLoginAttempts(c *gin.Context, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, ErrorResponse{Message: TooManyLoginsMessage})
 }
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func EnrollMFA(c *gin.Context) {
+	user := authenticatedUser(c)
+
+	if user.IsMFAEnabled() {
+		c.JSON(http.StatusConflict, ErrorResponse{Message: "Two-factor authentication is already enabled."})
+		return
+	}
+
+	// Enrolling again before confirming simply replaces the pending secret
+	secret := auth.GenerateTOTPSecret()
+	if err := config.DB.Model(&user).Updates(map[string]interface{}{"mfa_secret": secret, "mfa_last_used_step": 0}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusOK, MFAEnrollment{Secret: secret, OtpauthURI: auth.TOTPURI(auth.MFAIssuer(), user.Email, secret)})
+}
+
+func ConfirmMFA(c *gin.Context) {
+	var confirmDetails struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&confirmDetails); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	user := authenticatedUser(c)
+
+	if user.IsMFAEnabled() {
+		c.JSON(http.StatusConflict, ErrorResponse{Message: "Two-factor authentication is already enabled."})
+		return
+	}
+
+	if user.MFASecret == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: "Two-factor authentication enrolment has not been started."})
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(user.MFASecret, confirmDetails.Code, time.Now(), user.MFALastUsedStep)
+	if !ok {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: InvalidMFACodeMessage})
+		return
+	}
+
+	codes := auth.GenerateRecoveryCodes(auth.RecoveryCodeCount)
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		for _, code := range codes {
+			if err := tx.Create(&models.RecoveryCode{UserID: user.ID, CodeHash: auth.HashRecoveryCode(code)}).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&user).Updates(map[string]interface{}{"mfa_enabled_at": time.Now(), "mfa_last_used_step": step}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	// The recovery codes are only ever shown once
+	c.JSON(http.StatusOK, MFARecoveryCodes{RecoveryCodes: codes})
+}
+
+func ResetMFA(c *gin.Context) {
+	var user models.User
+	if err := config.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: "User not found."})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&user).Updates(map[string]interface{}{"mfa_secret": "", "mfa_enabled_at": nil, "mfa_last_used_step": 0}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Two-factor authentication has been reset."})
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery code.
+// Both are consumed with conditional updates so that concurrent requests cannot reuse them.
+func verifySecondFactor(user models.User, code string) (bool, error) {
+	if step, ok := auth.ValidateTOTP(user.MFASecret, code, time.Now(), user.MFALastUsedStep); ok {
+		result := config.DB.Model(&models.User{}).
+			Where("id = ? AND mfa_last_used_step < ?", user.ID, step).
+			UpdateColumn("mfa_last_used_step", step)
+		return result.RowsAffected == 1, result.Error
+	}
+
+	result := config.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, auth.HashRecoveryCode(code)).
+		UpdateColumn("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	UnknownProviderMessage    = "Unknown identity provider"
+	InvalidOIDCLoginMessage   = "Could not sign in with the identity provider. Please try again"
+	UnverifiedProviderMessage = "The identity provider has not verified your email address"
+)
+
+// OIDCLogin sends the user to the identity provider to sign in.
+func OIDCLogin(c *gin.Context) {
+	provider, err := auth.GetOIDCProvider(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: UnknownProviderMessage})
+		return
+	}
+
+	redirectURL, err := auth.BeginOIDCLogin(c.Request.Context(), provider)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, ErrorResponse{Message: InvalidOIDCLoginMessage})
+		return
+	}
+
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// OIDCCallback completes a sign in at the identity provider and logs the user in.
+func OIDCCallback(c *gin.Context) {
+	provider, err := auth.GetOIDCProvider(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: UnknownProviderMessage})
+		return
+	}
+
+	// The provider redirects back with an error when the user cancels or is refused
+	if c.Query("error") != "" {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: InvalidOIDCLoginMessage})
+		return
+	}
+
+	state, err := auth.ConsumeOIDCState(c.Query("state"))
+	if err != nil || state.Provider != provider.Name {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: InvalidOIDCLoginMessage})
+		return
+	}
+
+	idToken, err := provider.Exchange(c.Request.Context(), c.Query("code"), state.Verifier)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: InvalidOIDCLoginMessage})
+		return
+	}
+
+	claims, err := provider.VerifyIDToken(c.Request.Context(), idToken, state.Nonce)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: InvalidOIDCLoginMessage})
+		return
+	}
+
+	user, err := userForIdentity(provider.Name, claims)
+	if errors.Is(err, errUnverifiedIdentity) {
+		c.JSON(http.StatusForbidden, ErrorResponse{Message: UnverifiedProviderMessage})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	completeLogin(c, user)
+}
+
+var errUnverifiedIdentity = errors.New("identity provider has not verified the email address")
+
+// userForIdentity finds the user an external identity belongs to. Unknown identities are
+// linked to the user with the same email, or to a new user, but only when the provider
+// vouches for the email address, otherwise anyone could claim an existing account.
+// An account whose email was never verified may have been registered by someone else ahead
+// of its owner, so it is taken back from them before it is linked, see reclaimAccount.
+func userForIdentity(provider string, claims *auth.IDTokenClaims) (models.User, error) {
+	var user models.User
+	var identity models.Identity
+
+	err := config.DB.Where("provider = ? AND subject = ?", provider, claims.Subject).First(&identity).Error
+	if err == nil {
+		return user, config.DB.First(&user, identity.UserID).Error
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return user, errUnverifiedIdentity
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		err := tx.Where("email = ?", claims.Email).First(&user).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			user = newUserFromIdentity(claims)
+			user.EmailVerifiedAt = &now
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !user.IsEmailVerified():
+			// The provider has proven the address, so there is no need to send our own link
+			user.EmailVerifiedAt = &now
+			if err := reclaimAccount(tx, &user); err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&models.Identity{
+			UserID:   user.ID,
+			Provider: provider,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		}).Error
+	})
+
+	return user, err
+}
+
+// reclaimAccount hands an unverified account to the owner of its email address. Whoever
+// registered it may have chosen its password, second factor and API keys, so none of them
+// work any more and every session is signed out. The owner signs in through the provider,
+// or resets the password.
+func reclaimAccount(tx *gorm.DB, user *models.User) error {
+	if err := user.SetPassword(auth.NewSecret()); err != nil {
+		return err
+	}
+
+	err := tx.Model(user).UpdateColumns(map[string]interface{}{
+		"email_verified_at":  user.EmailVerifiedAt,
+		"password_hash":      user.PasswordHash,
+		"mfa_secret":         "",
+		"mfa_enabled_at":     nil,
+		"mfa_last_used_step": 0,
+	}).Error
+	if err != nil {
+		return err
+	}
+	user.MFASecret, user.MFAEnabledAt, user.MFALastUsedStep = "", nil, 0
+
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	err = tx.Model(&models.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return err
+	}
+
+	// Refresh tokens live in Redis, outside the transaction, which is rolled back if they cannot be revoked
+	return auth.RevokeAllForUser(user.Email)
+}
+
+func newUserFromIdentity(claims *auth.IDTokenClaims) models.User {
+	firstname, lastname := claims.GivenName, claims.FamilyName
+	if firstname == "" && lastname == "" {
+		firstname, lastname, _ = strings.Cut(claims.Name, " ")
+	}
+	if firstname == "" {
+		firstname, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	user := models.User{
+		Firstname: firstname,
+		Lastname:  lastname,
+		Email:     claims.Email,
+		Role:      models.RoleReader,
+	}
+
+	// Users created from an identity sign in through the provider, until they reset their password
+	user.SetPassword(auth.NewSecret())
+
+	return user
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/jobs"
+	"github.com/fokosun/go-rest-api/mail"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	InvalidResetTokenMessage     = "Invalid or expired password reset token"
+	TooManyPasswordResetsMessage = "Too many password reset requests. Please try again later."
+)
+
+// SendPasswordResetJob is the job type that emails a password reset link.
+const SendPasswordResetJob = "password.reset"
+
+var errResetTokenUsed = errors.New("password reset token already used")
+
+// ForgotPassword queues a password reset link for the email. The response never reveals whether
+// the email is registered: every request does the same work and gets the same answer, and the
+// user is only looked up by the job.
+func ForgotPassword(c *gin.Context) {
+	var forgotDetails struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&forgotDetails); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	allowed, retryAfter, err := auth.RecordPasswordResetRequest(forgotDetails.Email, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+	if retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, ErrorResponse{Message: TooManyPasswordResetsMessage})
+		return
+	}
+
+	// Too many links for one address are dropped quietly, so that it cannot be flooded with them
+	if allowed {
+		if _, err := jobs.Enqueue(c.Request.Context(), SendPasswordResetJob, forgotDetails.Email); err != nil {
+			log.Printf("Could not queue a password reset link: %v", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "If the email is registered, a password reset link has been sent."})
+}
+
+// SendPasswordReset emails a password reset link to the user with the email in the job, if any.
+func SendPasswordReset(ctx context.Context, job jobs.Job) error {
+	var email string
+	if err := job.Decode(&email); err != nil {
+		return err
+	}
+
+	var user models.User
+	err := config.DB.WithContext(ctx).Where("email = ?", email).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	token := auth.NewSecret()
+	resetToken := models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(config.PasswordResetTTL()),
+	}
+
+	err = config.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Only the most recently requested link can be used
+		if err := tx.Where("user_id = ? AND used_at IS NULL", user.ID).Delete(&models.PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&resetToken).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", config.AppURL(), token)
+	return mail.Default().Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nUse the link below to choose a new password. It expires in %s.\n\n%s\n\nIf you did not ask to reset your password you can ignore this email.\n",
+			user.Firstname, config.PasswordResetTTL(), link,
+		),
+	})
+}
+
+func ResetPassword(c *gin.Context) {
+	var resetDetails struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&resetDetails); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	var resetToken models.PasswordResetToken
+	if err := config.DB.Where("token_hash = ?", auth.HashToken(resetDetails.Token)).First(&resetToken).Error; err != nil || !resetToken.IsUsable() {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: InvalidResetTokenMessage})
+		return
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, resetToken.UserID).Error; err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: InvalidResetTokenMessage})
+		return
+	}
+
+	if err := user.SetPassword(resetDetails.Password); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		// Claim the token before using it so that concurrent requests cannot both succeed
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", resetToken.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errResetTokenUsed
+		}
+
+		return tx.Model(&user).Update("password_hash", user.PasswordHash).Error
+	})
+	if errors.Is(err, errResetTokenUsed) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: InvalidResetTokenMessage})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	// Sign the user out everywhere, including whoever may have known the old password
+	if err := revokeAllSessions(user); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Password has been reset"})
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// applyMergePatch applies a JSON Merge Patch (RFC 7396) to a decoded JSON document: members of
+// the patch replace those of the document, objects are merged and null removes a member.
+func applyMergePatch(document interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	documentObject, ok := document.(map[string]interface{})
+	if !ok {
+		documentObject = map[string]interface{}{}
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(documentObject, name)
+			continue
+		}
+		documentObject[name] = applyMergePatch(documentObject[name], value)
+	}
+	return documentObject
+}
+
+// decodeStrict decodes a JSON body, refusing fields the destination does not have.
+func decodeStrict(body io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("the request body is empty")
+		}
+		return err
+	}
+	return nil
+}
+
+// matchesETag reports whether an If-Match header allows a change to a resource with the given
+// ETag. A request without the header is always allowed.
+func matchesETag(ifMatch string, etag string) bool {
+	if ifMatch == "" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
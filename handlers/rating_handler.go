@@ -1,86 +1,186 @@
 package handlers
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/events"
+	"github.com/fokosun/go-rest-api/listing"
 	"github.com/fokosun/go-rest-api/models"
+	"github.com/fokosun/go-rest-api/webhooks"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var ratingListing = listing.Spec{
+	Sorts: map[string]listing.Field{
+		"id":         {Column: "id", Type: listing.Int},
+		"rating":     {Column: "rating", Type: listing.Int},
+		"created_at": {Column: "created_at", Type: listing.Time},
+		"updated_at": {Column: "updated_at", Type: listing.Time},
+		"helpful":    {Column: "helpful_count", Type: listing.Int},
+	},
+	Filters: createdFilters(map[string]listing.Filter{
+		"book_id":    {Column: "book_id", Op: "=", Type: listing.Int},
+		"user_id":    {Column: "user_id", Op: "=", Type: listing.Int},
+		"rating":     {Column: "rating", Op: "=", Type: listing.Int},
+		"rating_gte": {Column: "rating", Op: ">=", Type: listing.Int},
+		"rating_lte": {Column: "rating", Op: "<=", Type: listing.Int},
+	}),
+	DefaultSort: "-helpful",
+}
+
+// GetRatings lists the ratings whose review is approved, the most helpful first.
 func GetRatings(c *gin.Context) {
 	ratings := []models.Rating{}
-	config.DB.Find(&ratings)
+	if !listPage(c, ratingListing, config.DB.Where("review_status = ?", models.ReviewApproved), &ratings) {
+		return
+	}
 	c.JSON(http.StatusOK, ratings)
 }
 
 func GetRatingsByBookID(c *gin.Context) {
 	ratings := []models.Rating{}
-	if err := config.DB.Where("book_id = ?", c.Param("id")).Find(&ratings).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: "Book does not exist"})
+	query := config.DB.Where("book_id = ? AND review_status = ?", c.Param("id"), models.ReviewApproved)
+	if !listPage(c, ratingListing, query, &ratings) {
 		return
 	}
 
 	c.JSON(http.StatusOK, ratings)
 }
 
-func CreateOrUpdateRating(c *gin.Context) {
-	var rating models.Rating
+// GetRatingSummary returns how many times a book was rated, its mean rating, how many ratings
+// gave it each number of stars and its score.
+func GetRatingSummary(c *gin.Context) {
 	var book models.Book
-	var user models.User
+	if err := config.DB.Preload("Ratings").First(&book, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: "Book not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, bookRatings(book))
+}
+
+// errRatingRaced means another request created the same user's rating of the book first.
+var errRatingRaced = errors.New("rating created concurrently")
 
+// CreateOrUpdateRating saves the authenticated user's rating of a book, creating it the first
+// time they rate the book and replacing it afterwards. Sending the same rating again changes nothing.
+func CreateOrUpdateRating(c *gin.Context) {
 	bookID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Message: "An unknown error occured. please try again."})
 		return
 	}
 
-	// If Rating dont exists create new
-	if err := config.DB.Where(&models.Rating{BookID: bookID, UserID: user.ID}).First(&rating).Error; err != nil {
-		fmt.Println("Creating new Rating")
+	var input models.Rating
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		return
+	}
 
-		// Bind the JSON input to the struct
-		if err := c.ShouldBindJSON(&rating); err != nil {
-			c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
-			return
-		}
+	if err := input.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, ValidationErrorResponse{ValidationErrorMessage: err.Error()})
+		return
+	}
+
+	// ensure the given book id exists
+	var book models.Book
+	if err := config.DB.First(&book, bookID).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: "Book not found"})
+		return
+	}
+
+	user := authenticatedUser(c)
 
-		// ensure the given book id exists
-		if err := config.DB.First(&book, bookID).Error; err != nil {
-			c.JSON(http.StatusNotFound, ErrorResponse{Message: "Book not found"})
-			return
+	var rating models.Rating
+	created := false
+	save := func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("book_id = ? AND user_id = ?", bookID, user.ID).First(&rating).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			rating = models.Rating{UserID: user.ID, BookID: bookID, Rating: input.Rating, Comment: input.Comment}
+			moderateReview(&rating)
+
+			// A rating created at the same moment makes this request an update
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rating)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errRatingRaced
+			}
+
+			created = true
+			if err := models.AddRating(tx, uint(bookID), rating.Rating); err != nil {
+				return err
+			}
+			return events.Record(tx, events.AggregateRating, rating.ID, webhooks.EventRatingCreated, rating)
+		}
+		if err != nil {
+			return err
 		}
 
-		// set the book id from gin context
-		if err := rating.SetBookID(bookID); err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
-			return
+		if rating.Rating == input.Rating && rating.Comment == input.Comment {
+			return nil
 		}
 
-		// set the user id from gin context
-		if err := rating.SetUserID(user.ID); err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
-			return
+		previous := rating.Rating
+		rating.Rating = input.Rating
+		if rating.Comment != input.Comment {
+			rating.Comment = input.Comment
+			moderateReview(&rating)
+		}
+		if err := tx.Save(&rating).Error; err != nil {
+			return err
 		}
+		if err := models.ChangeRating(tx, uint(bookID), previous, rating.Rating); err != nil {
+			return err
+		}
+		return events.Record(tx, events.AggregateRating, rating.ID, webhooks.EventRatingUpdated, rating)
+	}
 
-		config.DB.Create(&rating)
+	err = config.DB.Transaction(save)
+	if errors.Is(err, errRatingRaced) {
+		err = config.DB.Transaction(save)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
 
+	if created {
 		c.JSON(http.StatusCreated, rating)
-
 		return
 	}
+	c.JSON(http.StatusOK, rating)
+}
 
-	// Bind the JSON input to the struct
-	if err := c.ShouldBindJSON(&rating); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+// DeleteRating removes the authenticated user's rating of a book.
+func DeleteRating(c *gin.Context) {
+	user := authenticatedUser(c)
+
+	var rating models.Rating
+	if err := config.DB.Where("book_id = ? AND user_id = ?", c.Param("id"), user.ID).First(&rating).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: "Rating not found"})
 		return
 	}
 
-	fmt.Println("Updating existing Rating")
-
-	config.DB.Save(&rating)
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&rating).Error; err != nil {
+			return err
+		}
+		if err := models.RemoveRating(tx, uint(rating.BookID), rating.Rating); err != nil {
+			return err
+		}
+		return events.Record(tx, events.AggregateRating, rating.ID, webhooks.EventRatingDeleted, gin.H{"id": rating.ID, "book_id": rating.BookID})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
 
-	c.JSON(http.StatusOK, rating)
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Rating deleted"})
 }
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/jobs"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/fokosun/go-rest-api/recommend"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// RebuildRecommendationsJob is the job type that works out again which books are similar from
+// their ratings.
+const RebuildRecommendationsJob = "recommendations.rebuild"
+
+// maxRecommendations bounds the limit of recommendations and similar books.
+const maxRecommendations = 50
+
+// GetRecommendations recommends books the authenticated user has not rated yet.
+func GetRecommendations(c *gin.Context) {
+	limit, ok := recommendationLimit(c)
+	if !ok {
+		return
+	}
+
+	recommendations, err := recommend.ForUser(config.DB, authenticatedUser(c).ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	respondWithRecommendations(c, recommendations)
+}
+
+// GetSimilarBooks lists the books readers rated most like a book.
+func GetSimilarBooks(c *gin.Context) {
+	limit, ok := recommendationLimit(c)
+	if !ok {
+		return
+	}
+
+	var book models.Book
+	if err := config.DB.First(&book, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: "Book not found"})
+		return
+	}
+
+	similar, err := recommend.Similar(config.DB, book.ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	respondWithRecommendations(c, similar)
+}
+
+func recommendationLimit(c *gin.Context) (int, bool) {
+	for name := range c.Request.URL.Query() {
+		if name != "limit" {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Message: "Unknown query parameter: " + name})
+			return 0, false
+		}
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > maxRecommendations {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: "limit must be between 1 and 50"})
+		return 0, false
+	}
+	return limit, true
+}
+
+// respondWithRecommendations responds with the recommended books, in the order recommended.
+func respondWithRecommendations(c *gin.Context, recommendations []recommend.Recommendation) {
+	results := []RecommendationResponse{}
+	if len(recommendations) == 0 {
+		c.JSON(http.StatusOK, results)
+		return
+	}
+
+	ids := make([]uint, len(recommendations))
+	for i, recommendation := range recommendations {
+		ids[i] = recommendation.BookID
+	}
+
+	books := []models.Book{}
+	if err := preloadContributors(config.DB.Preload("Author", func(db *gorm.DB) *gorm.DB {
+		return db.Select("ID", "Firstname", "Lastname", "Gravatar", "CreatedBy", "UpdatedBy", "CreatedAt", "UpdatedAt")
+	})).Preload("Ratings").Find(&books, ids).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	byID := map[uint]models.Book{}
+	for _, book := range books {
+		byID[book.ID] = book
+	}
+
+	for _, recommendation := range recommendations {
+		book, ok := byID[recommendation.BookID]
+		if !ok {
+			continue
+		}
+		results = append(results, RecommendationResponse{
+			Book:   NewBookResponse(book),
+			Score:  recommendation.Score,
+			Reason: recommendation.Reason,
+		})
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+func RebuildRecommendations(ctx context.Context, job jobs.Job) error {
+	settings := config.RecommendationSettings()
+	return recommend.Rebuild(config.DB.WithContext(ctx), settings.MinCoRatings, settings.Neighbours)
+}
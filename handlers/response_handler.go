@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"encoding/json"
 	"time"
 
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/isbn"
 	"github.com/fokosun/go-rest-api/models"
 )
 
@@ -18,24 +21,228 @@ type ValidationErrorResponse struct {
 	ValidationErrorMessage string `json:"message"`
 }
 
+// FieldErrorResponse says what is wrong with each invalid field of a request.
+type FieldErrorResponse struct {
+	Message string            `json:"message"`
+	Errors  map[string]string `json:"errors"`
+}
+
 type NewUser struct {
-	ID        int       `json:"id"`
-	Firstname string    `json:"firstname"`
-	Lastname  string    `json:"lastname"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID              int        `json:"id"`
+	Firstname       string     `json:"firstname"`
+	Lastname        string     `json:"lastname"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
 
 type NewBook struct {
-	ID        int           `json:"id"`
-	Title     string        `json:"title"`
-	Isbn      string        `json:"isbn"`
-	Author    models.Author `json:"author"`
-	CreatedAt time.Time     `json:"created_at"`
-	UpdatedAt time.Time     `json:"updated_at"`
+	ID           int                    `json:"id"`
+	Title        string                 `json:"title"`
+	Isbn         string                 `json:"isbn"`
+	Isbn10       string                 `json:"isbn_10,omitempty"`
+	Author       models.Author          `json:"author"`
+	Contributors []models.BookAuthor    `json:"contributors"`
+	Version      uint                   `json:"version"`
+	Ratings      models.BookRatingStats `json:"ratings"`
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+}
+
+func NewBookResponse(book models.Book) NewBook {
+	isbn10, _ := isbn.To10(book.Isbn)
+	contributors := book.Contributors
+	if contributors == nil {
+		contributors = []models.BookAuthor{}
+	}
+	return NewBook{ID: int(book.ID), Title: book.Title, Isbn: book.Isbn, Isbn10: isbn10, Author: book.Author, Contributors: contributors, Version: book.Version, Ratings: bookRatings(book), CreatedAt: book.CreatedAt, UpdatedAt: book.UpdatedAt}
+}
+
+// SearchResult is a book or an author found by a search, depending on Type.
+type SearchResult struct {
+	Type    string         `json:"type"`
+	ID      uint           `json:"id"`
+	Score   float64        `json:"score"`
+	Snippet string         `json:"snippet"`
+	Book    *NewBook       `json:"book,omitempty"`
+	Author  *models.Author `json:"author,omitempty"`
+}
+
+// RecommendationResponse is a recommended book. Reason is "similar" for books like those the
+// reader rated well and "popular" for the best rated books.
+type RecommendationResponse struct {
+	Book   NewBook `json:"book"`
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason"`
+}
+
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Results []SearchResult `json:"results"`
 }
 
 type LoginToken struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type MFAEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+type MFARecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type APIKeyResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type CreatedAPIKey struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+func NewAPIKeyResponse(apiKey models.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         apiKey.ID,
+		Name:       apiKey.Name,
+		Prefix:     apiKey.Prefix,
+		Scopes:     apiKey.ScopeList(),
+		ExpiresAt:  apiKey.ExpiresAt,
+		LastUsedAt: apiKey.LastUsedAt,
+		RevokedAt:  apiKey.RevokedAt,
+		CreatedAt:  apiKey.CreatedAt,
+	}
+}
+
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Current    bool      `json:"current"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+func NewSessionResponse(session models.Session, current bool) SessionResponse {
+	return SessionResponse{
+		ID:         session.ID,
+		UserAgent:  session.UserAgent,
+		IP:         session.IP,
+		Current:    current,
+		CreatedAt:  session.CreatedAt,
+		LastSeenAt: session.LastSeenAt,
+		ExpiresAt:  session.ExpiresAt,
+	}
+}
+
+type WebhookSubscriptionResponse struct {
+	ID                  uint       `json:"id"`
+	URL                 string     `json:"url"`
+	Events              []string   `json:"events"`
+	Active              bool       `json:"active"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at"`
+	DisabledReason      string     `json:"disabled_reason,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+}
+
+type CreatedWebhookSubscription struct {
+	WebhookSubscriptionResponse
+	Secret string `json:"secret"`
+}
+
+func NewWebhookSubscriptionResponse(subscription models.WebhookSubscription) WebhookSubscriptionResponse {
+	return WebhookSubscriptionResponse{
+		ID:                  subscription.ID,
+		URL:                 subscription.URL,
+		Events:              subscription.EventList(),
+		Active:              subscription.Active,
+		ConsecutiveFailures: subscription.ConsecutiveFailures,
+		DisabledAt:          subscription.DisabledAt,
+		DisabledReason:      subscription.DisabledReason,
+		CreatedAt:           subscription.CreatedAt,
+		UpdatedAt:           subscription.UpdatedAt,
+	}
+}
+
+type WebhookDeliveryAttemptResponse struct {
+	StatusCode      int       `json:"status_code"`
+	LatencyMs       int64     `json:"latency_ms"`
+	ResponseSnippet string    `json:"response_snippet"`
+	Error           string    `json:"error,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type WebhookDeliveryResponse struct {
+	ID             uint                             `json:"id"`
+	SubscriptionID uint                             `json:"subscription_id"`
+	EventID        string                           `json:"event_id"`
+	EventType      string                           `json:"event_type"`
+	Status         string                           `json:"status"`
+	Attempts       int                              `json:"attempts"`
+	NextAttemptAt  *time.Time                       `json:"next_attempt_at"`
+	DeliveredAt    *time.Time                       `json:"delivered_at"`
+	ReplayOf       *uint                            `json:"replay_of"`
+	CreatedAt      time.Time                        `json:"created_at"`
+	Payload        json.RawMessage                  `json:"payload,omitempty"`
+	AttemptLog     []WebhookDeliveryAttemptResponse `json:"attempt_log,omitempty"`
+}
+
+// NewWebhookDeliveryResponse describes a delivery. The payload and attempts are only included when they were loaded.
+func NewWebhookDeliveryResponse(delivery models.WebhookDelivery, withPayload bool) WebhookDeliveryResponse {
+	response := WebhookDeliveryResponse{
+		ID:             delivery.ID,
+		SubscriptionID: delivery.SubscriptionID,
+		EventID:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		NextAttemptAt:  delivery.NextAttemptAt,
+		DeliveredAt:    delivery.DeliveredAt,
+		ReplayOf:       delivery.ReplayOf,
+		CreatedAt:      delivery.CreatedAt,
+	}
+
+	if withPayload {
+		response.Payload = json.RawMessage(delivery.Payload)
+	}
+
+	for _, attempt := range delivery.AttemptLog {
+		response.AttemptLog = append(response.AttemptLog, WebhookDeliveryAttemptResponse{
+			StatusCode:      attempt.StatusCode,
+			LatencyMs:       attempt.LatencyMs,
+			ResponseSnippet: attempt.ResponseSnippet,
+			Error:           attempt.Error,
+			CreatedAt:       attempt.CreatedAt,
+		})
+	}
+
+	return response
+}
+
+func NewLoginToken(pair auth.TokenPair) LoginToken {
+	return LoginToken{
+		Token:        pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		ExpiresIn:    int64(auth.AccessTokenTTL.Seconds()),
+	}
 }
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/listing"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/fokosun/go-rest-api/moderation"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const ReviewNotFoundMessage = "Review not found"
+
+var reviewQueueListing = listing.Spec{
+	Sorts: map[string]listing.Field{
+		"id":         {Column: "id", Type: listing.Int},
+		"flagged":    {Column: "flagged", Type: listing.Bool},
+		"created_at": {Column: "created_at", Type: listing.Time},
+		"updated_at": {Column: "updated_at", Type: listing.Time},
+	},
+	Filters: createdFilters(map[string]listing.Filter{
+		"status":  {Column: "review_status", Op: "=", Type: listing.String},
+		"flagged": {Column: "flagged", Op: "=", Type: listing.Bool},
+		"book_id": {Column: "book_id", Op: "=", Type: listing.Int},
+		"user_id": {Column: "user_id", Op: "=", Type: listing.Int},
+	}),
+	// Flagged reviews first, then the ones that have waited longest
+	DefaultSort: "-flagged,updated_at",
+}
+
+// moderateReview runs the comment of a rating through the moderation filter. A review that is
+// flagged waits for a moderator, and so does every review when they are not approved automatically.
+func moderateReview(rating *models.Rating) {
+	flags := moderation.Default().Check(rating.Comment)
+	rating.Flagged = len(flags) > 0
+	rating.FlaggedFor = strings.Join(flags, ", ")
+	rating.ModeratedBy = 0
+	rating.ModeratedAt = nil
+
+	switch {
+	case rating.Flagged:
+		rating.ReviewStatus = models.ReviewPending
+	case strings.TrimSpace(rating.Comment) == "" || config.ReviewAutoApprove():
+		rating.ReviewStatus = models.ReviewApproved
+	default:
+		rating.ReviewStatus = models.ReviewPending
+	}
+}
+
+// GetReviewQueue lists the reviews waiting for a moderator, or those in the state named by status.
+func GetReviewQueue(c *gin.Context) {
+	query := config.DB
+	if c.Query("status") == "" {
+		query = query.Where("review_status = ?", models.ReviewPending)
+	}
+
+	ratings := []models.Rating{}
+	if !listPage(c, reviewQueueListing, query, &ratings) {
+		return
+	}
+
+	c.JSON(http.StatusOK, ratings)
+}
+
+// ModerateReview returns a handler that approves or rejects the review of a rating.
+func ModerateReview(status string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var rating models.Rating
+		if err := config.DB.First(&rating, c.Param("id")).Error; err != nil {
+			c.JSON(http.StatusNotFound, ErrorResponse{Message: ReviewNotFoundMessage})
+			return
+		}
+
+		now := time.Now()
+		rating.ReviewStatus = status
+		rating.ModeratedBy = authenticatedUser(c).ID
+		rating.ModeratedAt = &now
+
+		err := config.DB.Model(&rating).Select("ReviewStatus", "ModeratedBy", "ModeratedAt").Updates(&rating).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+			return
+		}
+
+		c.JSON(http.StatusOK, rating)
+	}
+}
+
+// VoteHelpful marks an approved review as helpful to the authenticated user. Voting twice counts once.
+func VoteHelpful(c *gin.Context) {
+	user := authenticatedUser(c)
+
+	var rating models.Rating
+	if err := config.DB.Where("review_status = ?", models.ReviewApproved).First(&rating, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: ReviewNotFoundMessage})
+		return
+	}
+
+	if rating.UserID == user.ID {
+		c.JSON(http.StatusForbidden, ErrorResponse{Message: "You cannot vote for your own review"})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		vote := models.ReviewVote{RatingID: rating.ID, UserID: user.ID}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&vote)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return changeHelpfulCount(tx, &rating, 1)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusOK, rating)
+}
+
+// RemoveHelpfulVote takes back the authenticated user's vote for a review.
+func RemoveHelpfulVote(c *gin.Context) {
+	user := authenticatedUser(c)
+
+	var rating models.Rating
+	if err := config.DB.First(&rating, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: ReviewNotFoundMessage})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("rating_id = ? AND user_id = ?", rating.ID, user.ID).Delete(&models.ReviewVote{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return changeHelpfulCount(tx, &rating, -1)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusOK, rating)
+}
+
+// changeHelpfulCount counts a vote for a rating, without changing when the rating was updated.
+func changeHelpfulCount(tx *gorm.DB, rating *models.Rating, delta int64) error {
+	err := tx.Model(rating).UpdateColumn("helpful_count", gorm.Expr("helpful_count + ?", delta)).Error
+	if err != nil {
+		return err
+	}
+	return tx.Select("helpful_count").First(rating, rating.ID).Error
+}
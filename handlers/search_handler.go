@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/fokosun/go-rest-api/search"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	SearchResultBook   = "book"
+	SearchResultAuthor = "author"
+
+	// maxSearchResults bounds the limit of a search.
+	maxSearchResults = 50
+)
+
+func Search(c *gin.Context) {
+	for name := range c.Request.URL.Query() {
+		if name != "q" && name != "type" && name != "limit" {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Message: "Unknown query parameter: " + name})
+			return
+		}
+	}
+
+	text := c.Query("q")
+	if search.TSQuery(text) == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: "q is required"})
+		return
+	}
+
+	resultType := c.Query("type")
+	if resultType != "" && resultType != SearchResultBook && resultType != SearchResultAuthor {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: "type must be book or author"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > maxSearchResults {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: "limit must be between 1 and 50"})
+		return
+	}
+
+	results := []SearchResult{}
+
+	if resultType != SearchResultAuthor {
+		books, err := searchBooks(text, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+			return
+		}
+		results = append(results, books...)
+	}
+
+	if resultType != SearchResultBook {
+		authors, err := searchAuthors(text, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+			return
+		}
+		results = append(results, authors...)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	c.JSON(http.StatusOK, SearchResponse{Query: text, Results: results})
+}
+
+func searchBooks(text string, limit int) ([]SearchResult, error) {
+	hits, err := search.Books(config.DB, text, limit)
+	if err != nil || len(hits) == 0 {
+		return nil, err
+	}
+
+	ids := make([]uint, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+
+	books := []models.Book{}
+	if err := preloadContributors(config.DB.Preload("Author", func(db *gorm.DB) *gorm.DB {
+		return db.Select("ID", "Firstname", "Lastname", "Gravatar", "CreatedBy", "UpdatedBy", "CreatedAt", "UpdatedAt")
+	})).Find(&books, ids).Error; err != nil {
+		return nil, err
+	}
+
+	byID := map[uint]models.Book{}
+	for _, book := range books {
+		byID[book.ID] = book
+	}
+
+	results := []SearchResult{}
+	for _, hit := range hits {
+		book, ok := byID[hit.ID]
+		if !ok {
+			continue
+		}
+		bookResponse := NewBookResponse(book)
+		results = append(results, SearchResult{
+			Type:    SearchResultBook,
+			ID:      hit.ID,
+			Score:   hit.Score,
+			Snippet: hit.Snippet,
+			Book:    &bookResponse,
+		})
+	}
+	return results, nil
+}
+
+func searchAuthors(text string, limit int) ([]SearchResult, error) {
+	hits, err := search.Authors(config.DB, text, limit)
+	if err != nil || len(hits) == 0 {
+		return nil, err
+	}
+
+	ids := make([]uint, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+
+	authors := []models.Author{}
+	if err := config.DB.Find(&authors, ids).Error; err != nil {
+		return nil, err
+	}
+
+	byID := map[uint]models.Author{}
+	for _, author := range authors {
+		byID[author.ID] = author
+	}
+
+	results := []SearchResult{}
+	for _, hit := range hits {
+		author, ok := byID[hit.ID]
+		if !ok {
+			continue
+		}
+		results = append(results, SearchResult{
+			Type:    SearchResultAuthor,
+			ID:      hit.ID,
+			Score:   hit.Score,
+			Snippet: hit.Snippet,
+			Author:  &author,
+		})
+	}
+	return results, nil
+}
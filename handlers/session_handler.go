@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/gin-gonic/gin"
+)
+
+func GetSessions(c *gin.Context) {
+	sessions := []models.Session{}
+	config.DB.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", authenticatedUser(c).ID, time.Now()).
+		Order("last_seen_at desc").
+		Find(&sessions)
+
+	currentSession := c.GetString("session_id")
+
+	response := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = NewSessionResponse(session, session.ID == currentSession)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func RevokeSession(c *gin.Context) {
+	var session models.Session
+
+	// Users can only see and revoke their own sessions
+	if err := config.DB.Where("id = ? AND user_id = ?", c.Param("id"), authenticatedUser(c).ID).First(&session).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: "Session not found"})
+		return
+	}
+
+	if err := revokeSession(session.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Session revoked"})
+}
+
+// createSession records the device a new token family was issued to.
+func createSession(c *gin.Context, user models.User, pair auth.TokenPair) error {
+	now := time.Now()
+	return config.DB.Create(&models.Session{
+		ID:         pair.Family,
+		UserID:     user.ID,
+		UserAgent:  c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+		LastSeenAt: now,
+		ExpiresAt:  pair.RefreshExpiresAt,
+	}).Error
+}
+
+// touchSession extends a session after its refresh token has been rotated.
+func touchSession(c *gin.Context, pair auth.TokenPair) error {
+	return config.DB.Model(&models.Session{}).Where("id = ?", pair.Family).Updates(map[string]interface{}{
+		"user_agent":   c.Request.UserAgent(),
+		"ip":           c.ClientIP(),
+		"last_seen_at": time.Now(),
+		"expires_at":   pair.RefreshExpiresAt,
+	}).Error
+}
+
+// revokeSession signs a single device out.
+func revokeSession(id string) error {
+	if id == "" {
+		return nil
+	}
+
+	if err := auth.RevokeFamily(id); err != nil {
+		return err
+	}
+
+	return config.DB.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+}
+
+// revokeAllSessions signs a user out on every device.
+func revokeAllSessions(user models.User) error {
+	if err := auth.RevokeAllForUser(user.Email); err != nil {
+		return err
+	}
+
+	return config.DB.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", user.ID).
+		Update("revoked_at", time.Now()).Error
+}
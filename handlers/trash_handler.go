@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/events"
+	"github.com/fokosun/go-rest-api/jobs"
+	"github.com/fokosun/go-rest-api/listing"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/fokosun/go-rest-api/webhooks"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// PurgeTrashJob is the job type that permanently deletes what has been in the trash for longer than
+// the retention period.
+const PurgeTrashJob = "trash.purge"
+
+const (
+	BookNotInTrashMessage   = "Book not found in the trash"
+	AuthorNotInTrashMessage = "Author not found in the trash"
+	UserNotInTrashMessage   = "User not found in the trash"
+)
+
+// trashListing is a list spec for deleted items, which can also be sorted and filtered by when
+// they were deleted. The most recently deleted come first.
+func trashListing(spec listing.Spec) listing.Spec {
+	trash := listing.Spec{
+		Sorts:       map[string]listing.Field{"deleted_at": {Column: "deleted_at", Type: listing.Time}},
+		Filters:     map[string]listing.Filter{},
+		DefaultSort: "-deleted_at",
+	}
+	for name, field := range spec.Sorts {
+		trash.Sorts[name] = field
+	}
+	for name, filter := range spec.Filters {
+		trash.Filters[name] = filter
+	}
+	trash.Filters["deleted_after"] = listing.Filter{Column: "deleted_at", Op: ">", Type: listing.Time}
+	trash.Filters["deleted_before"] = listing.Filter{Column: "deleted_at", Op: "<", Type: listing.Time}
+
+	return trash
+}
+
+// trash queries the deleted rows of a table.
+func trash() *gorm.DB {
+	return config.DB.Unscoped().Where("deleted_at IS NOT NULL")
+}
+
+// GetDeletedBooks lists the books in the trash. Librarians see every deleted book, other users
+// the ones they created.
+func GetDeletedBooks(c *gin.Context) {
+	user := authenticatedUser(c)
+
+	query := preloadContributors(trash())
+	if !auth.HasPermission(user.Role, auth.PermBooksManage) {
+		query = query.Where("user_id = ?", user.ID)
+	}
+
+	books := []models.Book{}
+	if !listPage(c, trashListing(bookListing), query, &books) {
+		return
+	}
+
+	c.JSON(http.StatusOK, books)
+}
+
+func RestoreBook(c *gin.Context) {
+	var book models.Book
+	if err := trash().First(&book, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: BookNotInTrashMessage})
+		return
+	}
+
+	// Only the original creator of the book, or a librarian, can restore it
+	if !canModify(authenticatedUser(c), book.UserID, auth.PermBooksManage) {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: NotAuthorizedMessage})
+		return
+	}
+
+	// The ISBN may have been given to another book while this one was in the trash
+	if book.Isbn != "" {
+		var taken int64
+		config.DB.Model(&models.Book{}).Where("isbn = ?", book.Isbn).Count(&taken)
+		if taken > 0 {
+			c.JSON(http.StatusConflict, ErrorResponse{Message: ISBNTakenMessage})
+			return
+		}
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&book).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		if err := preloadContributors(tx.Preload("Author")).Preload("Ratings").First(&book, book.ID).Error; err != nil {
+			return err
+		}
+		return events.Record(tx, events.AggregateBook, book.ID, webhooks.EventBookRestored, NewBookResponse(book))
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusOK, NewBookResponse(book))
+}
+
+func PurgeBook(c *gin.Context) {
+	var book models.Book
+	if err := trash().First(&book, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: BookNotInTrashMessage})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return models.PurgeBook(tx, book.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// GetDeletedAuthors lists the authors in the trash. Librarians see every deleted author, other
+// users the ones they created.
+func GetDeletedAuthors(c *gin.Context) {
+	user := authenticatedUser(c)
+
+	query := trash()
+	if !auth.HasPermission(user.Role, auth.PermAuthorsManage) {
+		query = query.Where("created_by = ?", user.ID)
+	}
+
+	authors := []models.Author{}
+	if !listPage(c, trashListing(authorListing), query, &authors) {
+		return
+	}
+
+	c.JSON(http.StatusOK, authors)
+}
+
+func RestoreAuthor(c *gin.Context) {
+	var author models.Author
+	if err := trash().First(&author, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: AuthorNotInTrashMessage})
+		return
+	}
+
+	// Only the user who created the author, or a librarian, can restore it
+	if !canModify(authenticatedUser(c), author.CreatedBy, auth.PermAuthorsManage) {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: NotAuthorizedMessage})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&author).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&author, author.ID).Error; err != nil {
+			return err
+		}
+		return events.Record(tx, events.AggregateAuthor, author.ID, webhooks.EventAuthorRestored, author)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusOK, author)
+}
+
+func PurgeAuthor(c *gin.Context) {
+	var author models.Author
+	if err := trash().First(&author, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: AuthorNotInTrashMessage})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return models.PurgeAuthor(tx, author.ID)
+	})
+	if errors.Is(err, models.ErrAuthorHasBooks) {
+		c.JSON(http.StatusConflict, ErrorResponse{Message: "The author still has books. Purge them first."})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+func GetDeletedUsers(c *gin.Context) {
+	users := []models.User{}
+	if !listPage(c, trashListing(userListing), trash(), &users) {
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+func RestoreUser(c *gin.Context) {
+	var user models.User
+	if err := trash().First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: UserNotInTrashMessage})
+		return
+	}
+
+	// The address may have been used to register again since the account was deleted
+	var taken int64
+	config.DB.Model(&models.User{}).Where("email = ?", user.Email).Count(&taken)
+	if taken > 0 {
+		c.JSON(http.StatusConflict, ErrorResponse{Message: "Another account now uses this email address"})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&user).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return events.Record(tx, events.AggregateUser, user.ID, webhooks.EventUserRestored, gin.H{"id": user.ID})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusOK, NewUser{ID: int(user.ID), Firstname: user.Firstname, Lastname: user.Lastname, Email: user.Email, EmailVerifiedAt: user.EmailVerifiedAt, CreatedAt: user.CreatedAt, UpdatedAt: user.UpdatedAt})
+}
+
+func PurgeUser(c *gin.Context) {
+	var user models.User
+	if err := trash().First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: UserNotInTrashMessage})
+		return
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		return models.PurgeUser(tx, user.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// PurgeTrash permanently deletes what has been in the trash for longer than config.TrashRetention.
+func PurgeTrash(ctx context.Context, job jobs.Job) error {
+	purged, err := models.PurgeTrash(config.DB.WithContext(ctx), time.Now().Add(-config.TrashRetention()))
+	if purged > 0 {
+		log.Printf("trash: purged %d items", purged)
+	}
+	return err
+}
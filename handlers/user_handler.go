@@ -1,16 +1,40 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
 
+	"github.com/fokosun/go-rest-api/auth"
 	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/events"
+	"github.com/fokosun/go-rest-api/listing"
 	"github.com/fokosun/go-rest-api/models"
+	"github.com/fokosun/go-rest-api/webhooks"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
+var userListing = listing.Spec{
+	Sorts: map[string]listing.Field{
+		"id":         {Column: "id", Type: listing.Int},
+		"firstname":  {Column: "firstname", Type: listing.String},
+		"lastname":   {Column: "lastname", Type: listing.String},
+		"email":      {Column: "email", Type: listing.String},
+		"created_at": {Column: "created_at", Type: listing.Time},
+		"updated_at": {Column: "updated_at", Type: listing.Time},
+	},
+	Filters: createdFilters(map[string]listing.Filter{
+		"role":  {Column: "role", Op: "=", Type: listing.String},
+		"email": {Column: "email", Op: "=", Type: listing.String},
+	}),
+	DefaultSort: "id",
+}
+
 func GetUsers(c *gin.Context) {
 	users := []models.User{}
-	config.DB.Find(&users)
+	if !listPage(c, userListing, config.DB, &users) {
+		return
+	}
 
 	c.JSON(http.StatusOK, users)
 }
@@ -54,6 +78,11 @@ func RegisterUser(c *gin.Context) {
 		return
 	}
 
+	// Everyone starts as an unverified reader, only an admin can grant another role
+	user.Role = models.RoleReader
+	user.EmailVerifiedAt = nil
+	user.MFAEnabledAt = nil
+
 	// Save the user to the database
 	result := config.DB.Create(&user)
 	if result.Error != nil {
@@ -61,8 +90,22 @@ func RegisterUser(c *gin.Context) {
 		return
 	}
 
+	// The account is created regardless, a new link can be requested if sending fails
+	if err := sendVerificationEmail(user); err != nil {
+		log.Printf("Could not send verification email to user %d: %v", user.ID, err)
+	}
+
 	user.Password = ""
-	c.JSON(http.StatusOK, NewUser{ID: int(user.ID), Firstname: user.Firstname, Lastname: user.Lastname, Email: user.Email, CreatedAt: user.CreatedAt, UpdatedAt: user.UpdatedAt})
+	c.JSON(http.StatusOK, NewUser{ID: int(user.ID), Firstname: user.Firstname, Lastname: user.Lastname, Email: user.Email, EmailVerifiedAt: user.EmailVerifiedAt, CreatedAt: user.CreatedAt, UpdatedAt: user.UpdatedAt})
+}
+
+// UserInput is the part of an account that its owner can change. The email address can only be
+// sent unchanged, since a new one would not be verified.
+type UserInput struct {
+	Firstname string `json:"firstname"`
+	Lastname  string `json:"lastname"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
 }
 
 func UpdateUser(c *gin.Context) {
@@ -72,29 +115,92 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
-	if err := c.ShouldBindJSON(&user); err != nil {
+	// Only the account owner or an admin can update an account
+	if !canModify(authenticatedUser(c), user.ID, auth.PermUsersManage) {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: NotAuthorizedMessage})
+		return
+	}
+
+	// Roles can only be changed through UpdateUserRole, verification through VerifyEmail
+	// and two-factor authentication through its own endpoints
+	var input UserInput
+	if err := c.ShouldBindJSON(&input); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	if len(user.Password) > 0 {
-		if err := user.SetPassword(user.Password); err != nil {
+	if input.Email != "" && input.Email != user.Email {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: "email field cannot be updated"})
+		return
+	}
+
+	columns := []string{}
+	if input.Firstname != "" {
+		user.Firstname = input.Firstname
+		columns = append(columns, "firstname")
+	}
+	if input.Lastname != "" {
+		user.Lastname = input.Lastname
+		columns = append(columns, "lastname")
+	}
+	if len(input.Password) > 0 {
+		if err := user.SetPassword(input.Password); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+			return
+		}
+		columns = append(columns, "password_hash")
+	}
+
+	// Update only the fields that were sent, on the account named in the URL
+	if len(columns) > 0 {
+		if err := config.DB.Model(&user).Select(append(columns, "updated_at")).Updates(user).Error; err != nil {
 			c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
 			return
 		}
 	}
 
-	// Update the user in the database
-	if err := config.DB.Model(&user).Updates(user).Error; err != nil {
+	c.JSON(http.StatusOK, user)
+}
+
+func UpdateUserRole(c *gin.Context) {
+	var roleDetails struct {
+		Role string `json:"role" binding:"required,oneof=admin librarian reader"`
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: "User not found."})
+		return
+	}
+
+	if err := c.ShouldBindJSON(&roleDetails); err != nil {
 		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
 		return
 	}
 
-	user.Password = ""
+	if err := config.DB.Model(&user).Update("role", roleDetails.Role).Error; err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusOK, user)
 }
 
+func UnlockUser(c *gin.Context) {
+	var user models.User
+	if err := config.DB.First(&user, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: "User not found."})
+		return
+	}
+
+	if err := auth.UnlockAccount(user.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "User unlocked."})
+}
+
 func DeleteUser(c *gin.Context) {
 	var user models.User
 	if err := config.DB.First(&user, c.Param("id")).Error; err != nil {
@@ -102,6 +208,27 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 
-	config.DB.Delete(&user)
+	// Only the account owner or an admin can delete an account
+	if !canModify(authenticatedUser(c), user.ID, auth.PermUsersManage) {
+		c.JSON(http.StatusUnauthorized, ErrorResponse{Message: NotAuthorizedMessage})
+		return
+	}
+
+	// The account is moved to the trash, from where an admin can restore it
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&user).Error; err != nil {
+			return err
+		}
+		return events.Record(tx, events.AggregateUser, user.ID, webhooks.EventUserDeleted, gin.H{"id": user.ID})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	if err := revokeAllSessions(user); err != nil {
+		log.Printf("failed to revoke the sessions of deleted user %d: %v", user.ID, err)
+	}
+
 	c.JSON(http.StatusNoContent, nil)
 }
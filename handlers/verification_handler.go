@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/mail"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/gin-gonic/gin"
+)
+
+const InvalidVerificationTokenMessage = "Invalid or expired verification link"
+
+func VerifyEmail(c *gin.Context) {
+	email, err := auth.ParseEmailVerificationToken(c.Query("token"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: InvalidVerificationTokenMessage})
+		return
+	}
+
+	var user models.User
+	if err := config.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: InvalidVerificationTokenMessage})
+		return
+	}
+
+	// Following the link again is harmless
+	if user.IsEmailVerified() {
+		c.JSON(http.StatusOK, SuccessResponse{Message: "Email address verified"})
+		return
+	}
+
+	if err := config.DB.Model(&user).Update("email_verified_at", time.Now()).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{Message: "Email address verified"})
+}
+
+func ResendVerificationEmail(c *gin.Context) {
+	var resendDetails struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&resendDetails); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	// The response never reveals whether the email is registered
+	response := SuccessResponse{Message: "If the email is registered and not yet verified, a verification link has been sent."}
+
+	var user models.User
+	if err := config.DB.Where("email = ?", resendDetails.Email).First(&user).Error; err != nil || user.IsEmailVerified() {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	if err := sendVerificationEmail(user); err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func sendVerificationEmail(user models.User) error {
+	token, err := auth.GenerateEmailVerificationToken(user.Email, config.EmailVerificationTTL())
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/auth/verify?token=%s", config.AppURL(), url.QueryEscape(token))
+	return mail.Default().Send(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nPlease confirm your email address by following the link below. It expires in %s.\n\n%s\n",
+			user.Firstname, config.EmailVerificationTTL(), link,
+		),
+	})
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/listing"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/fokosun/go-rest-api/webhooks"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const WebhookSubscriptionNotFoundMessage = "Webhook subscription not found"
+
+func CreateWebhookSubscription(c *gin.Context) {
+	var subscriptionDetails struct {
+		URL    string   `json:"url" binding:"required"`
+		Events []string `json:"events" binding:"required,min=1"`
+		Secret string   `json:"secret"`
+	}
+
+	if err := c.ShouldBindJSON(&subscriptionDetails); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	if message := validateWebhookSubscription(subscriptionDetails.URL, subscriptionDetails.Events); message != "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: message})
+		return
+	}
+
+	// Receivers need the secret to verify deliveries, generate one unless they brought their own
+	secret := subscriptionDetails.Secret
+	if secret == "" {
+		secret = "whsec_" + auth.NewSecret()
+	}
+
+	subscription := models.WebhookSubscription{
+		URL:       subscriptionDetails.URL,
+		Secret:    secret,
+		Active:    true,
+		CreatedBy: authenticatedUser(c).ID,
+	}
+	subscription.SetEvents(subscriptionDetails.Events)
+
+	if err := config.DB.Create(&subscription).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	// The secret is only ever shown once
+	c.JSON(http.StatusCreated, CreatedWebhookSubscription{
+		WebhookSubscriptionResponse: NewWebhookSubscriptionResponse(subscription),
+		Secret:                      secret,
+	})
+}
+
+func GetWebhookSubscriptions(c *gin.Context) {
+	subscriptions := []models.WebhookSubscription{}
+	if err := config.DB.Order("id").Find(&subscriptions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	response := make([]WebhookSubscriptionResponse, len(subscriptions))
+	for i, subscription := range subscriptions {
+		response[i] = NewWebhookSubscriptionResponse(subscription)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func GetWebhookSubscription(c *gin.Context) {
+	var subscription models.WebhookSubscription
+	if err := config.DB.First(&subscription, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: WebhookSubscriptionNotFoundMessage})
+		return
+	}
+
+	c.JSON(http.StatusOK, NewWebhookSubscriptionResponse(subscription))
+}
+
+func UpdateWebhookSubscription(c *gin.Context) {
+	var subscription models.WebhookSubscription
+	if err := config.DB.First(&subscription, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: WebhookSubscriptionNotFoundMessage})
+		return
+	}
+
+	// Fields that are left out keep their current value
+	var subscriptionDetails struct {
+		URL    *string  `json:"url"`
+		Events []string `json:"events"`
+		Secret *string  `json:"secret"`
+		Active *bool    `json:"active"`
+	}
+
+	if err := c.ShouldBindJSON(&subscriptionDetails); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	if subscriptionDetails.URL != nil {
+		subscription.URL = *subscriptionDetails.URL
+	}
+	if subscriptionDetails.Events != nil {
+		subscription.SetEvents(subscriptionDetails.Events)
+	}
+	if subscriptionDetails.Secret != nil && *subscriptionDetails.Secret != "" {
+		subscription.Secret = *subscriptionDetails.Secret
+	}
+	if subscriptionDetails.Active != nil {
+		// Re-enabling an endpoint that was disabled for failing gives it a clean slate
+		if *subscriptionDetails.Active && !subscription.Active {
+			subscription.ConsecutiveFailures = 0
+			subscription.DisabledAt = nil
+			subscription.DisabledReason = ""
+		}
+		subscription.Active = *subscriptionDetails.Active
+	}
+
+	if message := validateWebhookSubscription(subscription.URL, subscription.EventList()); message != "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: message})
+		return
+	}
+
+	if err := config.DB.Save(&subscription).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusOK, NewWebhookSubscriptionResponse(subscription))
+}
+
+func DeleteWebhookSubscription(c *gin.Context) {
+	var subscription models.WebhookSubscription
+	if err := config.DB.First(&subscription, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: WebhookSubscriptionNotFoundMessage})
+		return
+	}
+
+	config.DB.Delete(&subscription)
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// validateWebhookSubscription returns a message describing what is wrong with a subscription, if anything.
+func validateWebhookSubscription(endpoint string, events []string) string {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "url must be an absolute http or https URL"
+	}
+
+	if len(events) == 0 {
+		return "events must not be empty"
+	}
+
+	for _, event := range events {
+		if !webhooks.IsEvent(event) {
+			return "Unknown event: " + event
+		}
+	}
+
+	return ""
+}
+
+const (
+	WebhookDeliveryNotFoundMessage = "Webhook delivery not found"
+
+	// maxReplayedDeliveries bounds how many deliveries a single range replay can send again.
+	maxReplayedDeliveries = 1000
+)
+
+var webhookDeliveryListing = listing.Spec{
+	Sorts: map[string]listing.Field{
+		"id":         {Column: "id", Type: listing.Int},
+		"created_at": {Column: "created_at", Type: listing.Time},
+		"updated_at": {Column: "updated_at", Type: listing.Time},
+	},
+	Filters: createdFilters(map[string]listing.Filter{
+		"subscription_id": {Column: "subscription_id", Op: "=", Type: listing.Int},
+		"status":          {Column: "status", Op: "=", Type: listing.String},
+		"event_type":      {Column: "event_type", Op: "=", Type: listing.String},
+	}),
+	// Most recent deliveries first
+	DefaultSort: "-id",
+}
+
+func GetWebhookDeliveries(c *gin.Context) {
+	deliveries := []models.WebhookDelivery{}
+	if !listPage(c, webhookDeliveryListing, config.DB, &deliveries) {
+		return
+	}
+
+	response := make([]WebhookDeliveryResponse, len(deliveries))
+	for i, delivery := range deliveries {
+		response[i] = NewWebhookDeliveryResponse(delivery, false)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func GetWebhookDelivery(c *gin.Context) {
+	var delivery models.WebhookDelivery
+	if err := config.DB.Preload("AttemptLog", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).First(&delivery, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: WebhookDeliveryNotFoundMessage})
+		return
+	}
+
+	c.JSON(http.StatusOK, NewWebhookDeliveryResponse(delivery, true))
+}
+
+func ReplayWebhookDelivery(c *gin.Context) {
+	var original models.WebhookDelivery
+	if err := config.DB.First(&original, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{Message: WebhookDeliveryNotFoundMessage})
+		return
+	}
+
+	delivery, err := webhooks.Default().Replay(original)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, NewWebhookDeliveryResponse(delivery, false))
+}
+
+// ReplayWebhookDeliveries sends every delivery created in a time range again,
+// by default only those that were dead-lettered.
+func ReplayWebhookDeliveries(c *gin.Context) {
+	var replayDetails struct {
+		From           time.Time `json:"from" binding:"required"`
+		To             time.Time `json:"to" binding:"required"`
+		SubscriptionID uint      `json:"subscription_id"`
+		Status         string    `json:"status"`
+	}
+
+	if err := c.ShouldBindJSON(&replayDetails); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+		return
+	}
+
+	if !replayDetails.To.After(replayDetails.From) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: "to must be after from"})
+		return
+	}
+
+	status := replayDetails.Status
+	if status == "" {
+		status = models.WebhookDeliveryDead
+	}
+
+	query := config.DB.
+		Where("created_at >= ? AND created_at < ? AND status = ?", replayDetails.From, replayDetails.To, status).
+		Order("id")
+	if replayDetails.SubscriptionID != 0 {
+		query = query.Where("subscription_id = ?", replayDetails.SubscriptionID)
+	}
+
+	originals := []models.WebhookDelivery{}
+	query.Limit(maxReplayedDeliveries + 1).Find(&originals)
+
+	if len(originals) > maxReplayedDeliveries {
+		c.JSON(http.StatusBadRequest, ErrorResponse{Message: fmt.Sprintf("More than %d deliveries match, please narrow the range", maxReplayedDeliveries)})
+		return
+	}
+
+	replayed := make([]WebhookDeliveryResponse, 0, len(originals))
+	for _, original := range originals {
+		delivery, err := webhooks.Default().Replay(original)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, ErrorResponse{Message: "There was a problem processing this request. Please try again."})
+			return
+		}
+		replayed = append(replayed, NewWebhookDeliveryResponse(delivery, false))
+	}
+
+	c.JSON(http.StatusAccepted, replayed)
+}
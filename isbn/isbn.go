@@ -0,0 +1,118 @@
+// Package isbn validates ISBN-10 and ISBN-13 numbers and converts between them.
+// Books are stored under their ISBN-13, written without hyphens.
+package isbn
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidLength    = errors.New("an ISBN has 10 or 13 digits")
+	ErrInvalidCharacter = errors.New("an ISBN can only contain digits, hyphens and spaces, with an X as the check digit of an ISBN-10")
+	ErrInvalidPrefix    = errors.New("an ISBN-13 starts with 978 or 979")
+	ErrInvalidChecksum  = errors.New("the check digit of the ISBN is wrong")
+)
+
+// Normalize removes the hyphens and spaces an ISBN is written with.
+func Normalize(s string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(s)))
+}
+
+// Parse validates an ISBN-10 or ISBN-13, written with or without hyphens, and returns its ISBN-13.
+func Parse(s string) (string, error) {
+	s = Normalize(s)
+
+	switch len(s) {
+	case 10:
+		if err := validate10(s); err != nil {
+			return "", err
+		}
+		return To13(s), nil
+	case 13:
+		if err := validate13(s); err != nil {
+			return "", err
+		}
+		return s, nil
+	default:
+		return "", ErrInvalidLength
+	}
+}
+
+// To13 converts a valid ISBN-10 to its ISBN-13.
+func To13(isbn10 string) string {
+	body := "978" + isbn10[:9]
+	return body + string(checkDigit13(body))
+}
+
+// To10 converts a valid ISBN-13 to its ISBN-10. Only ISBN-13s starting with 978 have one.
+func To10(isbn13 string) (string, bool) {
+	if len(isbn13) != 13 || !strings.HasPrefix(isbn13, "978") {
+		return "", false
+	}
+
+	body := isbn13[3:12]
+	return body + string(checkDigit10(body)), true
+}
+
+func validate10(s string) error {
+	for i, r := range s {
+		if !isDigit(r) && !(i == 9 && r == 'X') {
+			return ErrInvalidCharacter
+		}
+	}
+
+	if checkDigit10(s[:9]) != rune(s[9]) {
+		return ErrInvalidChecksum
+	}
+	return nil
+}
+
+func validate13(s string) error {
+	for _, r := range s {
+		if !isDigit(r) {
+			return ErrInvalidCharacter
+		}
+	}
+
+	if !strings.HasPrefix(s, "978") && !strings.HasPrefix(s, "979") {
+		return ErrInvalidPrefix
+	}
+
+	if checkDigit13(s[:12]) != rune(s[12]) {
+		return ErrInvalidChecksum
+	}
+	return nil
+}
+
+// checkDigit10 weighs the nine digits from 10 down to 2; the check digit makes the sum a multiple of 11.
+func checkDigit10(body string) rune {
+	sum := 0
+	for i, r := range body {
+		sum += (10 - i) * int(r-'0')
+	}
+
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return rune('0' + check)
+}
+
+// checkDigit13 weighs the twelve digits alternately by 1 and 3; the check digit makes the sum a multiple of 10.
+func checkDigit13(body string) rune {
+	sum := 0
+	for i, r := range body {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(r-'0')
+	}
+
+	return rune('0' + (10-sum%10)%10)
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
@@ -0,0 +1,247 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	ErrUnknownJobType    = errors.New("no handler for job type")
+	ErrVisibilityTimeout = errors.New("job was not finished within the visibility timeout")
+	errUnreadableMessage = errors.New("message does not contain a job")
+	deadLetterMaxLen     = int64(10000)
+)
+
+// Job is a unit of background work. Its payload is JSON, decoded by the handler registered for its type.
+type Job struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	// Attempts is how many times the job has already been tried and failed
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"last_error,omitempty"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+}
+
+// Decode unmarshals the job's payload into v.
+func (j Job) Decode(v interface{}) error {
+	return json.Unmarshal(j.Payload, v)
+}
+
+// Handler runs a job. Returning an error retries the job until it runs out of attempts.
+type Handler func(ctx context.Context, job Job) error
+
+// Queue is a job queue on a Redis stream read by a consumer group, so that any number of
+// instances can share the work. Jobs are delivered at least once: a job whose worker dies is
+// claimed back once it has been pending for longer than the visibility timeout.
+// Delayed jobs and retries wait in a sorted set until they are due.
+type Queue struct {
+	Stream      string
+	Group       string
+	Concurrency int
+	// VisibilityTimeout is how long a job may run before it is considered stuck and retried
+	VisibilityTimeout time.Duration
+	MaxAttempts       int
+	RetryBaseDelay    time.Duration
+	RetryMaxDelay     time.Duration
+	// PollInterval is how often due jobs are moved to the stream and stuck jobs are claimed back
+	PollInterval time.Duration
+
+	consumer  string
+	handlers  map[string]Handler
+	recurring map[string]time.Duration
+	mu        sync.RWMutex
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+var (
+	queue     *Queue
+	queueOnce sync.Once
+)
+
+// SetDefault replaces the queue used by the handlers.
+func SetDefault(q *Queue) {
+	queueOnce.Do(func() {})
+	queue = q
+}
+
+// Default returns the queue in use, configuring it from the environment on first use.
+// It only runs jobs once it has been started.
+func Default() *Queue {
+	queueOnce.Do(func() {
+		queue = NewQueue(config.Env("JOBS_STREAM", "jobs"))
+	})
+	return queue
+}
+
+// Register sets the handler for a job type on the default queue.
+func Register(jobType string, handler Handler) {
+	Default().Register(jobType, handler)
+}
+
+// Enqueue adds a job to the default queue.
+func Enqueue(ctx context.Context, jobType string, payload interface{}) (Job, error) {
+	return Default().Enqueue(ctx, jobType, payload)
+}
+
+// NewQueue creates a queue on a stream, configured from the environment:
+//
+//	JOBS_GROUP              consumer group shared by the instances, defaults to workers
+//	JOBS_CONCURRENCY        jobs run at the same time by this instance, defaults to 4
+//	JOBS_VISIBILITY_TIMEOUT how long a job may run before it is retried, defaults to 5m
+//	JOBS_MAX_ATTEMPTS       attempts before a job is moved to the dead letter stream, defaults to 5
+//	JOBS_RETRY_BASE_DELAY   delay before the first retry, doubled for each one after, defaults to 10s
+//	JOBS_RETRY_MAX_DELAY    longest delay between retries, defaults to 1h
+//	JOBS_POLL_INTERVAL      how often delayed and stuck jobs are checked, defaults to 1s
+func NewQueue(stream string) *Queue {
+	return &Queue{
+		Stream:            stream,
+		Group:             config.Env("JOBS_GROUP", "workers"),
+		Concurrency:       config.EnvInt("JOBS_CONCURRENCY", 4),
+		VisibilityTimeout: config.EnvDuration("JOBS_VISIBILITY_TIMEOUT", 5*time.Minute),
+		MaxAttempts:       config.EnvInt("JOBS_MAX_ATTEMPTS", 5),
+		RetryBaseDelay:    config.EnvDuration("JOBS_RETRY_BASE_DELAY", 10*time.Second),
+		RetryMaxDelay:     config.EnvDuration("JOBS_RETRY_MAX_DELAY", time.Hour),
+		PollInterval:      config.EnvDuration("JOBS_POLL_INTERVAL", time.Second),
+	}
+}
+
+// Register sets the handler for a job type, replacing any registered before.
+func (q *Queue) Register(jobType string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.handlers == nil {
+		q.handlers = map[string]Handler{}
+	}
+	q.handlers[jobType] = handler
+}
+
+// Every enqueues a job of the given type once per interval, however many instances run the queue.
+func (q *Queue) Every(jobType string, interval time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.recurring == nil {
+		q.recurring = map[string]time.Duration{}
+	}
+	q.recurring[jobType] = interval
+}
+
+func (q *Queue) handler(jobType string) (Handler, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	handler, ok := q.handlers[jobType]
+	return handler, ok
+}
+
+// Enqueue adds a job to be run as soon as a worker is free.
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload interface{}) (Job, error) {
+	return q.EnqueueAt(ctx, jobType, payload, time.Time{})
+}
+
+// EnqueueIn adds a job to be run once delay has passed.
+func (q *Queue) EnqueueIn(ctx context.Context, jobType string, payload interface{}, delay time.Duration) (Job, error) {
+	return q.EnqueueAt(ctx, jobType, payload, time.Now().Add(delay))
+}
+
+// EnqueueAt adds a job to be run at the given time, or right away when it has passed.
+func (q *Queue) EnqueueAt(ctx context.Context, jobType string, payload interface{}, at time.Time) (Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Job{}, err
+	}
+
+	job := Job{
+		ID:         newJobID(),
+		Type:       jobType,
+		Payload:    data,
+		EnqueuedAt: time.Now(),
+	}
+
+	return job, q.push(ctx, job, at)
+}
+
+// DeadJobs lists the most recent jobs that ran out of attempts, newest first.
+func (q *Queue) DeadJobs(ctx context.Context, count int64) ([]Job, error) {
+	messages, err := config.Client.XRevRangeN(ctx, q.deadKey(), "+", "-", count).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	dead := make([]Job, 0, len(messages))
+	for _, message := range messages {
+		job, err := decodeMessage(message)
+		if err != nil {
+			return nil, err
+		}
+		dead = append(dead, job)
+	}
+	return dead, nil
+}
+
+func (q *Queue) push(ctx context.Context, job Job, at time.Time) error {
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	if at.After(time.Now()) {
+		return config.Client.ZAdd(ctx, q.delayedKey(), &redis.Z{Score: float64(at.UnixMilli()), Member: encoded}).Err()
+	}
+	return config.Client.XAdd(ctx, &redis.XAddArgs{Stream: q.Stream, Values: map[string]interface{}{"job": encoded}}).Err()
+}
+
+func (q *Queue) delayedKey() string {
+	return q.Stream + ":delayed"
+}
+
+func (q *Queue) recurringKey(jobType string) string {
+	return q.Stream + ":recurring:" + jobType
+}
+
+func (q *Queue) deadKey() string {
+	return q.Stream + ":dead"
+}
+
+func decodeMessage(message redis.XMessage) (Job, error) {
+	encoded, ok := message.Values["job"].(string)
+	if !ok {
+		return Job{}, errUnreadableMessage
+	}
+
+	var job Job
+	if err := json.Unmarshal([]byte(encoded), &job); err != nil {
+		return Job{}, fmt.Errorf("%w: %v", errUnreadableMessage, err)
+	}
+	return job, nil
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "job_" + hex.EncodeToString(b)
+}
+
+// consumerName identifies this instance in the consumer group.
+func consumerName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
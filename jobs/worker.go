@@ -0,0 +1,339 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"strings"
+	"time"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/go-redis/redis/v8"
+)
+
+// promoteDueJobs moves the delayed jobs that are due onto the stream in one step, so that two
+// instances promoting at the same time cannot both add the same job.
+var promoteDueJobs = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, job in ipairs(due) do
+	redis.call('XADD', KEYS[2], '*', 'job', job)
+	redis.call('ZREM', KEYS[1], job)
+end
+return #due
+`)
+
+// scheduleBatchSize is how many delayed or stuck jobs are handled per poll.
+const scheduleBatchSize = 100
+
+// Start creates the consumer group when needed and starts the workers and the scheduler.
+func (q *Queue) Start() error {
+	err := config.Client.XGroupCreateMkStream(config.Ctx, q.Stream, q.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	if q.Concurrency <= 0 {
+		q.Concurrency = 1
+	}
+	if q.MaxAttempts <= 0 {
+		q.MaxAttempts = 1
+	}
+	if q.PollInterval <= 0 {
+		q.PollInterval = time.Second
+	}
+	if q.VisibilityTimeout <= 0 {
+		q.VisibilityTimeout = 5 * time.Minute
+	}
+
+	q.consumer = consumerName()
+	q.stop = make(chan struct{})
+
+	for i := 0; i < q.Concurrency; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+
+	q.wg.Add(1)
+	go q.schedule()
+
+	return nil
+}
+
+// Stop stops taking new jobs and waits for the running ones to finish, or for ctx to be done.
+// Jobs that are still running when ctx is done are claimed back by another instance later.
+func (q *Queue) Stop(ctx context.Context) error {
+	close(q.stop)
+
+	drained := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+
+		streams, err := config.Client.XReadGroup(config.Ctx, &redis.XReadGroupArgs{
+			Group:    q.Group,
+			Consumer: q.consumer,
+			Streams:  []string{q.Stream, ">"},
+			Count:    1,
+			Block:    q.readBlock(),
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			log.Printf("jobs: reading %s: %v", q.Stream, err)
+			q.wait()
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, message := range stream.Messages {
+				q.handle(message)
+			}
+		}
+	}
+}
+
+// schedule moves delayed jobs onto the stream once they are due and retries jobs that were
+// left pending for longer than the visibility timeout, usually by an instance that died.
+func (q *Queue) schedule() {
+	defer q.wg.Done()
+
+	for {
+		if err := q.promote(); err != nil {
+			log.Printf("jobs: promoting delayed jobs: %v", err)
+		}
+		if err := q.reclaim(); err != nil {
+			log.Printf("jobs: reclaiming stuck jobs: %v", err)
+		}
+		if err := q.enqueueRecurring(); err != nil {
+			log.Printf("jobs: enqueueing recurring jobs: %v", err)
+		}
+
+		if !q.wait() {
+			return
+		}
+	}
+}
+
+func (q *Queue) promote() error {
+	now := time.Now().UnixMilli()
+	return promoteDueJobs.Run(config.Ctx, config.Client, []string{q.delayedKey(), q.Stream}, now, scheduleBatchSize).Err()
+}
+
+// enqueueRecurring enqueues the recurring jobs that are due. The key that is set for an interval
+// makes sure that only one instance enqueues each run.
+func (q *Queue) enqueueRecurring() error {
+	q.mu.RLock()
+	recurring := make(map[string]time.Duration, len(q.recurring))
+	for jobType, interval := range q.recurring {
+		recurring[jobType] = interval
+	}
+	q.mu.RUnlock()
+
+	for jobType, interval := range recurring {
+		due, err := config.Client.SetNX(config.Ctx, q.recurringKey(jobType), time.Now().Unix(), interval).Result()
+		if err != nil {
+			return err
+		}
+		if !due {
+			continue
+		}
+
+		if _, err := q.Enqueue(config.Ctx, jobType, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *Queue) reclaim() error {
+	messages, err := q.autoClaim()
+	if err != nil {
+		return err
+	}
+
+	for _, message := range messages {
+		job, err := decodeMessage(message)
+		if err != nil {
+			log.Printf("jobs: dropping message %s: %v", message.ID, err)
+			q.ack(message.ID)
+			continue
+		}
+		q.fail(message.ID, job, ErrVisibilityTimeout)
+	}
+	return nil
+}
+
+// autoClaim takes over the jobs that have been pending for longer than the visibility timeout and a
+// poll interval more, which leaves a worker whose job just timed out the time to fail it itself.
+// It sends XAUTOCLAIM itself because the client cannot read the three element reply of Redis 7.
+func (q *Queue) autoClaim() ([]redis.XMessage, error) {
+	reply, err := config.Client.Do(config.Ctx, "XAUTOCLAIM", q.Stream, q.Group, q.consumer,
+		(q.VisibilityTimeout + q.PollInterval).Milliseconds(), "0-0", "COUNT", scheduleBatchSize).Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) < 2 {
+		return nil, fmt.Errorf("unexpected XAUTOCLAIM reply %v", reply)
+	}
+
+	entries, _ := reply[1].([]interface{})
+	messages := make([]redis.XMessage, 0, len(entries))
+
+	for _, entry := range entries {
+		// Redis 6.2 replies with nil for entries that were deleted from the stream
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) != 2 {
+			continue
+		}
+
+		id, _ := fields[0].(string)
+		values, _ := fields[1].([]interface{})
+
+		message := redis.XMessage{ID: id, Values: map[string]interface{}{}}
+		for i := 0; i+1 < len(values); i += 2 {
+			key, _ := values[i].(string)
+			message.Values[key] = values[i+1]
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+func (q *Queue) handle(message redis.XMessage) {
+	job, err := decodeMessage(message)
+	if err != nil {
+		log.Printf("jobs: dropping message %s: %v", message.ID, err)
+		q.ack(message.ID)
+		return
+	}
+
+	if err := q.run(job); err != nil {
+		q.fail(message.ID, job, err)
+		return
+	}
+	q.ack(message.ID)
+}
+
+func (q *Queue) run(job Job) (err error) {
+	handler, ok := q.handler(job.Type)
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownJobType, job.Type)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), q.VisibilityTimeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return handler(ctx, job)
+}
+
+// fail schedules a retry of a failed job, or moves it to the dead letter stream once it has run
+// out of attempts, and removes the failed message from the stream.
+func (q *Queue) fail(messageID string, job Job, cause error) {
+	job.Attempts++
+	job.LastError = cause.Error()
+
+	encoded, err := json.Marshal(job)
+	if err != nil {
+		log.Printf("jobs: %s %s: %v", job.Type, job.ID, err)
+		return
+	}
+
+	pipe := config.Client.TxPipeline()
+	if job.Attempts >= q.MaxAttempts {
+		log.Printf("jobs: %s %s failed after %d attempts: %v", job.Type, job.ID, job.Attempts, cause)
+		pipe.XAdd(config.Ctx, &redis.XAddArgs{
+			Stream: q.deadKey(),
+			MaxLen: deadLetterMaxLen,
+			Approx: true,
+			Values: map[string]interface{}{"job": encoded},
+		})
+	} else {
+		retryAt := time.Now().Add(q.backoff(job.Attempts))
+		pipe.ZAdd(config.Ctx, q.delayedKey(), &redis.Z{Score: float64(retryAt.UnixMilli()), Member: encoded})
+	}
+	pipe.XAck(config.Ctx, q.Stream, q.Group, messageID)
+	pipe.XDel(config.Ctx, q.Stream, messageID)
+
+	if _, err := pipe.Exec(config.Ctx); err != nil {
+		log.Printf("jobs: %s %s: %v", job.Type, job.ID, err)
+	}
+}
+
+func (q *Queue) ack(messageID string) {
+	pipe := config.Client.TxPipeline()
+	pipe.XAck(config.Ctx, q.Stream, q.Group, messageID)
+	pipe.XDel(config.Ctx, q.Stream, messageID)
+
+	if _, err := pipe.Exec(config.Ctx); err != nil {
+		log.Printf("jobs: acknowledging %s: %v", messageID, err)
+	}
+}
+
+// backoff doubles the delay for every attempt up to RetryMaxDelay, with jitter so that jobs
+// that failed together are not all retried at the same moment.
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := q.RetryBaseDelay
+	for i := 1; i < attempts && delay < q.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if q.RetryMaxDelay > 0 && delay > q.RetryMaxDelay {
+		delay = q.RetryMaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + time.Duration(mathrand.Int63n(int64(half)+1))
+}
+
+// readBlock is how long a worker waits for a job before checking whether it should stop.
+func (q *Queue) readBlock() time.Duration {
+	switch {
+	case q.PollInterval < time.Millisecond:
+		// A block of zero would wait forever
+		return time.Millisecond
+	case q.PollInterval < time.Second:
+		return q.PollInterval
+	}
+	return time.Second
+}
+
+// wait sleeps for the poll interval, reporting false when the queue is stopped meanwhile.
+func (q *Queue) wait() bool {
+	select {
+	case <-q.stop:
+		return false
+	case <-time.After(q.PollInterval):
+		return true
+	}
+}
@@ -0,0 +1,90 @@
+package listing
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var errInvalidCursorValue = errors.New("invalid cursor value")
+
+// cursor is encoded as base64 JSON so that clients treat it as opaque.
+type cursor struct {
+	Sort   string        `json:"s"`
+	Values []interface{} `json:"v"`
+}
+
+func encodeCursor(sort []Sort, values []interface{}) (string, error) {
+	data, err := json.Marshal(cursor{Sort: sortKey(sort), Values: values})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeCursor(encoded string, sort []Sort) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, invalid("Invalid cursor")
+	}
+
+	var c cursor
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&c); err != nil {
+		return nil, invalid("Invalid cursor")
+	}
+	if c.Sort != sortKey(sort) {
+		return nil, invalid("The cursor was made for a different sort")
+	}
+	if len(c.Values) != len(sort) {
+		return nil, invalid("Invalid cursor")
+	}
+
+	values := make([]interface{}, len(sort))
+	for i, field := range sort {
+		value, err := cursorValue(field.Type, c.Values[i])
+		if err != nil {
+			return nil, invalid("Invalid cursor")
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func cursorValue(kind Type, value interface{}) (interface{}, error) {
+	switch kind {
+	case Int:
+		number, ok := value.(json.Number)
+		if !ok {
+			return nil, errInvalidCursorValue
+		}
+		return number.Int64()
+	case Float:
+		number, ok := value.(json.Number)
+		if !ok {
+			return nil, errInvalidCursorValue
+		}
+		return number.Float64()
+	case Time:
+		text, ok := value.(string)
+		if !ok {
+			return nil, errInvalidCursorValue
+		}
+		return time.Parse(time.RFC3339Nano, text)
+	case Bool:
+		flag, ok := value.(bool)
+		if !ok {
+			return nil, errInvalidCursorValue
+		}
+		return flag, nil
+	default:
+		text, ok := value.(string)
+		if !ok {
+			return nil, errInvalidCursorValue
+		}
+		return text, nil
+	}
+}
@@ -0,0 +1,226 @@
+// Package listing parses the pagination, sorting and filtering parameters of list endpoints
+// and applies them to a query.
+package listing
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+// Type is how a parameter value is parsed before it is compared with a column.
+type Type int
+
+const (
+	Int Type = iota
+	String
+	Time
+	Bool
+	Float
+)
+
+// Field is a column a list can be sorted by.
+type Field struct {
+	Column string
+	Type   Type
+	// Default is what a NULL sorts as, e.g. for a column of a joined table that an item has no
+	// row in. A column that can be NULL needs one, otherwise its items are skipped by cursors.
+	Default func() interface{}
+}
+
+// Filter compares a column with the value of a query parameter, e.g. created_after is created_at > value.
+type Filter struct {
+	Column string
+	Op     string
+	Type   Type
+	// Where is a condition used instead of Column and Op, with ? standing for the value
+	Where string
+}
+
+// Spec describes what a list endpoint can be sorted and filtered by. Any other query
+// parameter is refused, so that a typo is not silently ignored.
+type Spec struct {
+	Sorts   map[string]Field
+	Filters map[string]Filter
+	// DefaultSort is used when the request has no sort, e.g. "-created_at"
+	DefaultSort string
+}
+
+// Sort orders a list by a field, descending when Desc is set.
+type Sort struct {
+	Name string
+	Field
+	Desc bool
+}
+
+// Condition is a parsed filter and its value.
+type Condition struct {
+	Filter
+	Value interface{}
+}
+
+// Params are the parsed parameters of a list request.
+type Params struct {
+	Limit  int
+	Offset int
+	// Cursor holds the sort values of the last item of the previous page
+	Cursor     []interface{}
+	Sort       []Sort
+	Conditions []Condition
+
+	query url.Values
+}
+
+// Error is a parameter that cannot be used, reported to the client as a bad request.
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &Error{Message: fmt.Sprintf(format, args...)}
+}
+
+// Parse reads the list parameters from a query string:
+//
+//	limit    items per page, defaults to 50 and at most 200
+//	offset   items to skip, for numbered pages
+//	cursor   the next cursor of the previous page, which is faster than an offset for large lists
+//	sort     comma separated fields, prefixed with - for descending, e.g. -created_at,title
+//
+// Every other parameter must be one of the spec's filters.
+func (s Spec) Parse(query url.Values) (Params, error) {
+	params := Params{Limit: DefaultLimit, query: query}
+
+	for name, values := range query {
+		if len(values) == 0 {
+			continue
+		}
+		value := values[0]
+
+		switch name {
+		case "limit":
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > MaxLimit {
+				return Params{}, invalid("limit must be between 1 and %d", MaxLimit)
+			}
+			params.Limit = limit
+		case "offset":
+			offset, err := strconv.Atoi(value)
+			if err != nil || offset < 0 {
+				return Params{}, invalid("offset must be a positive number")
+			}
+			params.Offset = offset
+		case "cursor", "sort":
+			// Read once the sort is known
+		default:
+			filter, ok := s.Filters[name]
+			if !ok {
+				return Params{}, invalid("Unknown query parameter: %s", name)
+			}
+
+			parsed, err := parseValue(filter.Type, value)
+			if err != nil {
+				return Params{}, invalid("Invalid value for %s: %s", name, value)
+			}
+			params.Conditions = append(params.Conditions, Condition{Filter: filter, Value: parsed})
+		}
+	}
+
+	sort, err := s.parseSort(query.Get("sort"))
+	if err != nil {
+		return Params{}, err
+	}
+	params.Sort = sort
+
+	if encoded := query.Get("cursor"); encoded != "" {
+		if query.Has("offset") {
+			return Params{}, invalid("cursor and offset cannot be used together")
+		}
+
+		cursor, err := decodeCursor(encoded, params.Sort)
+		if err != nil {
+			return Params{}, err
+		}
+		params.Cursor = cursor
+	}
+
+	return params, nil
+}
+
+func (s Spec) parseSort(value string) ([]Sort, error) {
+	if value == "" {
+		value = s.DefaultSort
+	}
+
+	sort := []Sort{}
+	seen := map[string]bool{}
+
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		desc := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+
+		field, ok := s.Sorts[name]
+		if !ok {
+			return nil, invalid("Unknown sort field: %s", name)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		sort = append(sort, Sort{Name: name, Field: field, Desc: desc})
+	}
+
+	// Break ties on the primary key so that every item has a single place in the list
+	if !seen["id"] {
+		sort = append(sort, Sort{Name: "id", Field: Field{Column: "id", Type: Int}})
+	}
+
+	return sort, nil
+}
+
+// sortKey identifies the order a cursor was made for.
+func sortKey(sort []Sort) string {
+	names := make([]string, len(sort))
+	for i, field := range sort {
+		names[i] = field.Name
+		if field.Desc {
+			names[i] = "-" + field.Name
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+func parseValue(kind Type, value string) (interface{}, error) {
+	switch kind {
+	case Int:
+		return strconv.ParseInt(value, 10, 64)
+	case Float:
+		return strconv.ParseFloat(value, 64)
+	case Bool:
+		return strconv.ParseBool(value)
+	case Time:
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return t, nil
+		}
+		return time.Parse("2006-01-02", value)
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,208 @@
+package listing
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Page tells a client whether there are more items after the page it was sent.
+type Page struct {
+	HasMore    bool
+	NextCursor string
+}
+
+// Find loads one page of a list into dest, a pointer to a slice of models.
+func Find(query *gorm.DB, params Params, dest interface{}) (Page, error) {
+	for _, condition := range params.Conditions {
+		where := condition.Where
+		if where == "" {
+			where = fmt.Sprintf("%s %s ?", condition.Column, condition.Op)
+		}
+		query = query.Where(where, condition.Value)
+	}
+	if params.Cursor != nil {
+		where, args := keyset(params.Sort, params.Cursor)
+		query = query.Where(where, args...)
+	}
+
+	var order []string
+	var args []interface{}
+	for _, sort := range params.Sort {
+		column, columnArgs := sort.expression()
+		if sort.Desc {
+			column += " DESC"
+		}
+		order = append(order, column)
+		args = append(args, columnArgs...)
+	}
+	query = query.Order(clause.OrderBy{Expression: clause.Expr{SQL: strings.Join(order, ", "), Vars: args}})
+
+	// One more item than asked for tells whether there is a next page
+	tx := query.Offset(params.Offset).Limit(params.Limit + 1).Find(dest)
+	if tx.Error != nil {
+		return Page{}, tx.Error
+	}
+
+	rows := reflect.ValueOf(dest).Elem()
+	if rows.Len() <= params.Limit {
+		return Page{}, nil
+	}
+	rows.Set(rows.Slice(0, params.Limit))
+
+	values, err := sortValues(tx, rows.Index(params.Limit-1), params.Sort)
+	if err != nil {
+		return Page{}, err
+	}
+
+	next, err := encodeCursor(params.Sort, values)
+	if err != nil {
+		return Page{}, err
+	}
+
+	return Page{HasMore: true, NextCursor: next}, nil
+}
+
+// keyset selects the items that come after the given sort values.
+func keyset(sort []Sort, values []interface{}) (string, []interface{}) {
+	var alternatives []string
+	var args []interface{}
+
+	for i, field := range sort {
+		var conditions []string
+		for j := 0; j < i; j++ {
+			column, columnArgs := sort[j].expression()
+			conditions = append(conditions, column+" = ?")
+			args = append(append(args, columnArgs...), values[j])
+		}
+
+		op := ">"
+		if field.Desc {
+			op = "<"
+		}
+		column, columnArgs := field.expression()
+		conditions = append(conditions, column+" "+op+" ?")
+		args = append(append(args, columnArgs...), values[i])
+
+		alternatives = append(alternatives, "("+strings.Join(conditions, " AND ")+")")
+	}
+
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
+}
+
+// expression is what the field is sorted and compared by, with its arguments.
+func (f Field) expression() (string, []interface{}) {
+	if f.Default == nil {
+		return f.Column, nil
+	}
+	return "COALESCE(" + f.Column + ", ?)", []interface{}{f.Default()}
+}
+
+// sortValues reads the values of the sort columns from a loaded model.
+func sortValues(tx *gorm.DB, row reflect.Value, sort []Sort) ([]interface{}, error) {
+	if tx.Statement.Schema == nil {
+		return nil, fmt.Errorf("listing: %s is not a model", row.Type())
+	}
+
+	values := make([]interface{}, len(sort))
+	for i, field := range sort {
+		value, err := columnValue(tx, reflect.Indirect(row), field.Column)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil && field.Default != nil {
+			value = field.Default()
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// columnValue reads a column from a loaded model. A column of another table, such as
+// "book_rating_stats.score", is read from the relation of the model that is preloaded from it.
+func columnValue(tx *gorm.DB, row reflect.Value, column string) (interface{}, error) {
+	model := tx.Statement.Schema
+	table := ""
+	if dot := strings.LastIndex(column, "."); dot >= 0 {
+		table, column = column[:dot], column[dot+1:]
+	}
+
+	if table != "" && table != model.Table {
+		for _, relation := range model.Relationships.Relations {
+			if relation.FieldSchema.Table != table || (relation.Type != schema.HasOne && relation.Type != schema.BelongsTo) {
+				continue
+			}
+
+			field := relation.FieldSchema.LookUpField(column)
+			if field == nil {
+				break
+			}
+
+			related, _ := relation.Field.ValueOf(tx.Statement.Context, row)
+			value := reflect.Indirect(reflect.ValueOf(related))
+			if !value.IsValid() {
+				return nil, nil
+			}
+			result, _ := field.ValueOf(tx.Statement.Context, value)
+			return result, nil
+		}
+		return nil, fmt.Errorf("listing: %s is not loaded with %s.%s", model.Name, table, column)
+	}
+
+	field := model.LookUpField(column)
+	if field == nil {
+		return nil, fmt.Errorf("listing: %s has no column %s", model.Name, column)
+	}
+	value, _ := field.ValueOf(tx.Statement.Context, row)
+	return value, nil
+}
+
+// NextQuery is the query string of the page after this one.
+func (p Params) NextQuery(page Page) url.Values {
+	next := p.copyQuery()
+
+	// Numbered pages stay numbered, otherwise the cursor is used
+	if p.query.Has("offset") {
+		next.Set("offset", strconv.Itoa(p.Offset+p.Limit))
+	} else {
+		next.Set("cursor", page.NextCursor)
+	}
+	return next
+}
+
+// PrevQuery is the query string of the page before this one, when it is a numbered page.
+func (p Params) PrevQuery() (url.Values, bool) {
+	if p.Offset == 0 {
+		return nil, false
+	}
+
+	prev := p.copyQuery()
+	offset := p.Offset - p.Limit
+	if offset < 0 {
+		offset = 0
+	}
+	prev.Set("offset", strconv.Itoa(offset))
+	return prev, true
+}
+
+// FirstQuery is the query string of the first page.
+func (p Params) FirstQuery() url.Values {
+	first := p.copyQuery()
+	first.Del("cursor")
+	first.Del("offset")
+	return first
+}
+
+func (p Params) copyQuery() url.Values {
+	copied := url.Values{}
+	for name, values := range p.query {
+		copied[name] = append([]string(nil), values...)
+	}
+	return copied
+}
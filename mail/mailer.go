@@ -0,0 +1,55 @@
+package mail
+
+import (
+	"log"
+	"sync"
+
+	"github.com/fokosun/go-rest-api/config"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends a message to a single recipient.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+var (
+	mailer     Mailer
+	mailerOnce sync.Once
+)
+
+// SetDefault replaces the mailer used by the handlers.
+func SetDefault(m Mailer) {
+	mailerOnce.Do(func() {})
+	mailer = m
+}
+
+// Default returns the mailer in use, configuring it from the environment on first use.
+func Default() Mailer {
+	mailerOnce.Do(func() {
+		mailer = FromEnv()
+	})
+	return mailer
+}
+
+// FromEnv returns an SMTP mailer when SMTP_HOST is set. Otherwise messages are kept in memory,
+// which is only suitable for development and tests.
+func FromEnv() Mailer {
+	if config.Env("SMTP_HOST", "") == "" {
+		log.Println("SMTP_HOST is not set, emails will be kept in memory and not delivered")
+		return NewMemoryMailer()
+	}
+
+	return &SMTPMailer{
+		Host:     config.Env("SMTP_HOST", ""),
+		Port:     config.Env("SMTP_PORT", "587"),
+		Username: config.Env("SMTP_USERNAME", ""),
+		Password: config.Env("SMTP_PASSWORD", ""),
+		From:     config.Env("MAIL_FROM", "no-reply@localhost"),
+	}
+}
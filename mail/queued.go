@@ -0,0 +1,33 @@
+package mail
+
+import (
+	"context"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/jobs"
+)
+
+// SendJob is the job type that sends a queued message.
+const SendJob = "mail.send"
+
+// QueuedMailer hands messages to the job queue, so that requests do not wait on the mail server
+// and messages it refuses are retried.
+type QueuedMailer struct {
+	Queue *jobs.Queue
+}
+
+func (m QueuedMailer) Send(msg Message) error {
+	_, err := m.Queue.Enqueue(config.Ctx, SendJob, msg)
+	return err
+}
+
+// HandleJobs registers the job that sends queued messages with mailer.
+func HandleJobs(queue *jobs.Queue, mailer Mailer) {
+	queue.Register(SendJob, func(ctx context.Context, job jobs.Job) error {
+		var msg Message
+		if err := job.Decode(&msg); err != nil {
+			return err
+		}
+		return mailer.Send(msg)
+	})
+}
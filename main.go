@@ -1,31 +1,74 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fokosun/go-rest-api/auth"
 	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/events"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/jobs"
+	"github.com/fokosun/go-rest-api/mail"
 	"github.com/fokosun/go-rest-api/models"
 	"github.com/fokosun/go-rest-api/routes"
+	"github.com/fokosun/go-rest-api/webhooks"
 )
 
 // var ctx = context.Background()
 
+var relay *events.Relay
+
 func main() {
 	Init()
 
-	router := routes.SetupRouter()
-	router.Run(":8080")
+	server := &http.Server{
+		Addr:    ":8080",
+		Handler: routes.SetupRouter(),
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("server: %v", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	// Stop taking requests, then let the running requests, events and jobs finish
+	ctx, cancel := context.WithTimeout(context.Background(), config.EnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second))
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	relay.Stop()
+	if err := jobs.Default().Stop(ctx); err != nil {
+		log.Printf("shutdown: jobs still running: %v", err)
+	}
 }
 
 func Init() {
 	config.ConnectDatabase()
+	config.ConnectToRedisServer()
+	auth.InitKeys()
 	db := config.DB
 
-	db.AutoMigrate(&models.User{})
-	db.AutoMigrate(&models.Author{})
-	db.AutoMigrate(&models.Book{})
-	db.AutoMigrate(&models.Rating{})
+	if err := models.Migrate(db); err != nil {
+		panic("failed to migrate the database")
+	}
 
-	// Add check constraint for Rating field
-	// db.Exec("ALTER TABLE ratings ADD CONSTRAINT check_rating CHECK (rating IN (1, 2, 3, 4, 5))")
+	startOutboxRelay()
+	startJobs()
 
 	// Set a key-value pair
 	// err := config.Client.Set(ctx, "key", "value", 0).Err()
@@ -33,3 +76,53 @@ func Init() {
 	// 	log.Fatalf("Error setting key: %v", err)
 	// }
 }
+
+// startOutboxRelay publishes domain events to the sinks named in OUTBOX_SINKS (comma separated):
+// "webhooks" (the default), "redis" for the stream in OUTBOX_REDIS_STREAM and "bus" for in-process consumers.
+func startOutboxRelay() {
+	var sinks []events.Sink
+
+	for _, name := range strings.Split(config.Env("OUTBOX_SINKS", "webhooks"), ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "webhooks":
+			sinks = append(sinks, webhooks.OutboxSink{})
+		case "redis":
+			sinks = append(sinks, events.RedisStreamSink{
+				Stream: config.Env("OUTBOX_REDIS_STREAM", "events"),
+				MaxLen: int64(config.EnvInt("OUTBOX_REDIS_STREAM_MAXLEN", 100000)),
+			})
+		case "bus":
+			sinks = append(sinks, events.DefaultBus)
+		default:
+			log.Fatalf("unknown outbox sink %q", name)
+		}
+	}
+
+	relay = events.NewRelay(sinks...)
+	relay.Start()
+}
+
+// startJobs registers the background jobs and starts the workers. Emails are sent from the queue
+// unless MAIL_QUEUE is false, password reset links and webhook deliveries are always sent from it, the trash is purged every TRASH_PURGE_INTERVAL and the similar books
+// behind recommendations are worked out every RECOMMENDATIONS_REBUILD_INTERVAL.
+func startJobs() {
+	queue := jobs.Default()
+
+	if config.EnvBool("MAIL_QUEUE", true) {
+		mail.HandleJobs(queue, mail.Default())
+		mail.SetDefault(mail.QueuedMailer{Queue: queue})
+	}
+
+	queue.Register(handlers.SendPasswordResetJob, handlers.SendPasswordReset)
+	webhooks.Default().HandleJobs(queue)
+
+	queue.Register(handlers.PurgeTrashJob, handlers.PurgeTrash)
+	queue.Every(handlers.PurgeTrashJob, config.EnvDuration("TRASH_PURGE_INTERVAL", time.Hour))
+
+	queue.Register(handlers.RebuildRecommendationsJob, handlers.RebuildRecommendations)
+	queue.Every(handlers.RebuildRecommendationsJob, config.RecommendationSettings().RebuildInterval)
+
+	if err := queue.Start(); err != nil {
+		log.Fatalf("could not start the job queue: %v", err)
+	}
+}
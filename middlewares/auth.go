@@ -2,30 +2,31 @@ package middlewares
 
 import (
 	"net/http"
-	"os"
 	"strings"
+	"time"
 
+	"github.com/fokosun/go-rest-api/auth"
 	"github.com/fokosun/go-rest-api/config"
 	"github.com/fokosun/go-rest-api/models"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 )
 
-var jwtKey = []byte(os.Getenv("jwt-secret"))
-
-type Claims struct {
-	Email string `json:"email"`
-	jwt.RegisteredClaims
-}
+// APIKeyHeader carries a personal API key as an alternative to a bearer token.
+const APIKeyHeader = "X-API-Key"
 
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if gin.Mode() == gin.TestMode {
+		apiKey := c.GetHeader(APIKeyHeader)
+		authHeader := c.GetHeader("Authorization")
+
+		// Requests that present credentials are authenticated for real, even in test mode
+		if gin.Mode() == gin.TestMode && apiKey == "" && authHeader == "" {
 			var testUser models.User
 
 			testUser.Firstname = "test"
 			testUser.Lastname = "last"
 			testUser.Email = "test@example.com"
+			testUser.Role = models.RoleReader
 			testUser.Password = "validPass"
 			testUser.SetPassword("validPass")
 
@@ -36,11 +37,17 @@ func AuthMiddleware() gin.HandlerFunc {
 
 			// In test mode, bypass actual authentication
 			c.Set("email", "test@example.com")
+			c.Set("user", testUser)
+			c.Set("role", testUser.Role)
 			c.Next()
 			return
 		}
 
-		authHeader := c.GetHeader("Authorization")
+		if apiKey != "" {
+			authenticateAPIKey(c, apiKey)
+			return
+		}
+
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
 			c.Abort()
@@ -48,24 +55,33 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		claims := &Claims{}
-
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-
+		claims, err := auth.ParseToken(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		if !token.Valid {
+		// Refresh and verification tokens cannot be used to call the API
+		if !claims.IsAccessToken() {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
+		revoked, err := auth.IsRevoked(claims)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "There was a problem processing this request. Please try again."})
+			c.Abort()
+			return
+		}
+
+		if revoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired. Please login and try again"})
+			c.Abort()
+			return
+		}
+
 		// Ensure the requesting user exists
 		var user models.User
 		userEmail := claims.Email
@@ -75,8 +91,56 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// Token is valid, store user information in the context
+		// Tokens issued at login belong to a session, which may have been revoked from another device
+		if claims.Family != "" {
+			var session models.Session
+			if err := config.DB.Where("id = ? AND user_id = ?", claims.Family, user.ID).First(&session).Error; err != nil || !session.IsActive() {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired. Please login and try again"})
+				c.Abort()
+				return
+			}
+
+			// Only write last seen once a minute rather than on every request
+			now := time.Now()
+			config.DB.Model(&models.Session{}).
+				Where("id = ? AND last_seen_at < ?", session.ID, now.Add(-time.Minute)).
+				UpdateColumn("last_seen_at", now)
+
+			c.Set("session_id", session.ID)
+		}
+
+		// Token is valid, store user information in the context.
+		// The role is read from the database rather than the claims so that changes apply immediately.
 		c.Set("email", userEmail)
+		c.Set("user", user)
+		c.Set("role", user.Role)
 		c.Next()
 	}
 }
+
+func authenticateAPIKey(c *gin.Context, key string) {
+	var apiKey models.APIKey
+	if err := config.DB.Where("key_hash = ?", auth.HashToken(key)).First(&apiKey).Error; err != nil || !apiKey.IsActive() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, apiKey.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return
+	}
+
+	// Recording usage is best effort and must not fail the request
+	config.DB.Model(&apiKey).UpdateColumn("last_used_at", time.Now())
+
+	// The key acts as its user, limited to its scopes, see RequirePermission
+	c.Set("email", user.Email)
+	c.Set("user", user)
+	c.Set("role", user.Role)
+	c.Set("scopes", apiKey.ScopeList())
+	c.Set("api_key_id", apiKey.ID)
+	c.Next()
+}
@@ -1,11 +1,85 @@
 package middlewares
 
 import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fokosun/go-rest-api/webhooks"
 	"github.com/gin-gonic/gin"
 )
 
+// MaxWebhookBodySize is the largest inbound webhook body that is accepted.
+const MaxWebhookBodySize = 1 << 20
+
+// WebhookMiddleware only lets through webhooks that were signed by the source named in the
+// route, recently, and that have not been received before. The verified body is stored in
+// the context as "webhook_payload" and the nonces remembered for it as "webhook_nonces".
 func WebhookMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		source, err := webhooks.GetSource(c.Param("source"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown webhook source"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, MaxWebhookBodySize+1))
+		if err != nil || len(body) > MaxWebhookBodySize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Webhook body is too large"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		timestamp := c.GetHeader(source.TimestampHeader)
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid webhook timestamp"})
+			c.Abort()
+			return
+		}
+
+		// Old deliveries are refused so that nonces only have to be remembered for a while
+		age := time.Since(time.Unix(unix, 0))
+		if age > source.Tolerance || age < -source.Tolerance {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Webhook timestamp is outside the tolerance window"})
+			c.Abort()
+			return
+		}
+
+		if !webhooks.Verify(source.Secret, timestamp, body, c.GetHeader(source.SignatureHeader)) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook signature"})
+			c.Abort()
+			return
+		}
+
+		// The signature is unique to a timestamp and body, so remembering it refuses a captured
+		// request that is sent again. The id additionally refuses a delivery the source retried.
+		nonces := []string{webhooks.SignatureNonce(c.GetHeader(source.SignatureHeader))}
+		if id := c.GetHeader(source.NonceHeader); id != "" {
+			nonces = append(nonces, webhooks.DeliveryNonce(id))
+		}
+
+		for _, nonce := range nonces {
+			fresh, err := webhooks.UseNonce(source, nonce)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "There was a problem processing this request. Please try again."})
+				c.Abort()
+				return
+			}
+			if !fresh {
+				c.JSON(http.StatusConflict, gin.H{"error": "Webhook has already been received"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Set("webhook_source", source.Name)
+		c.Set("webhook_payload", body)
+		c.Set("webhook_nonces", nonces)
 		c.Next()
 	}
 }
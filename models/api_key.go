@@ -0,0 +1,38 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// APIKey lets a machine client act as a user, limited to a set of scopes.
+// Only the SHA-256 hash of the key is stored, the Prefix is kept to help users tell keys apart.
+type APIKey struct {
+	ID         uint   `gorm:"primarykey"`
+	UserID     uint   `gorm:"not null;index"`
+	Name       string `gorm:"not null"`
+	Prefix     string `gorm:"not null"`
+	KeyHash    string `gorm:"not null;uniqueIndex"`
+	Scopes     string `gorm:"not null"` // space separated
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+func (k *APIKey) SetScopes(scopes []string) {
+	k.Scopes = strings.Join(scopes, " ")
+}
+
+func (k *APIKey) ScopeList() []string {
+	return strings.Fields(k.Scopes)
+}
+
+// IsActive reports whether the key has neither been revoked nor expired.
+func (k *APIKey) IsActive() bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt)
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
 )
 
 type Author struct {
@@ -15,7 +16,8 @@ type Author struct {
 	UpdatedBy uint
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	Books     []Book `gorm:"foreignKey:AuthorID"`
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+	Books     []Book         `gorm:"foreignKey:AuthorID"`
 }
 
 func (a *Author) SetCreatedBy(userId uint) error {
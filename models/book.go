@@ -2,16 +2,24 @@ package models
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type Book struct {
 	ID        uint   `gorm:"primarykey"`
 	Title     string `json:"title"`
-	Isbn      string `json:"isbn"`
+	Isbn      string `json:"isbn"`     // ISBN-13 without hyphens, see package isbn
 	UserID    uint   `gorm:"not null"` // Foreign key
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	DeletedAt time.Time
-	AuthorID  uint   `gorm:"not null"` // Foreign key
-	Author    Author `gorm:"-,constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+	AuthorID  uint           `gorm:"not null"` // Foreign key to the lead author, see LeadAuthorID
+	Author    Author         `gorm:"-,constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
+	// Contributors credits every author of the book with their role, in order
+	Contributors []BookAuthor `json:"contributors" gorm:"foreignKey:BookID"`
+	// Version is incremented by every update and sent as the book's ETag
+	Version uint `json:"version" gorm:"not null;default:1"`
+	// Ratings is only loaded when it is preloaded
+	Ratings *BookRatingStats `json:"ratings,omitempty" gorm:"foreignKey:BookID"`
 }
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+// The roles an author can have on a book.
+const (
+	ContributorAuthor      = "author"
+	ContributorEditor      = "editor"
+	ContributorTranslator  = "translator"
+	ContributorIllustrator = "illustrator"
+)
+
+// BookAuthor credits an author with a role on a book. The same author can have several roles on
+// one book. Position orders the credits of a book, from 0.
+type BookAuthor struct {
+	BookID   uint   `json:"-" gorm:"primaryKey;autoIncrement:false"`
+	AuthorID uint   `json:"author_id" gorm:"primaryKey;autoIncrement:false;index" validate:"required"`
+	Role     string `json:"role" gorm:"primaryKey;default:author" validate:"required,oneof=author editor translator illustrator"`
+	Position int    `json:"position" gorm:"not null;default:0"`
+	Author   Author `json:"author" gorm:"constraint:OnDelete:CASCADE" validate:"-"`
+}
+
+// Validate validates the BookAuthor fields.
+func (ba *BookAuthor) Validate() error {
+	validate := validator.New()
+	return validate.Struct(ba)
+}
+
+// LeadAuthorID is the author a book is filed under: its first author, or its first contributor
+// when nobody is credited as its author.
+func LeadAuthorID(contributors []BookAuthor) uint {
+	for _, contributor := range contributors {
+		if contributor.Role == ContributorAuthor {
+			return contributor.AuthorID
+		}
+	}
+	if len(contributors) > 0 {
+		return contributors[0].AuthorID
+	}
+	return 0
+}
+
+// BooksBy selects the ids of the books an author has any role on.
+func BooksBy(db *gorm.DB, authorID uint) *gorm.DB {
+	return db.Model(&BookAuthor{}).Select("book_id").Where("author_id = ?", authorID)
+}
+
+// migrateBookAuthors credits the authors of the books saved before books could have several
+// contributors as their authors.
+func migrateBookAuthors(db *gorm.DB) error {
+	return db.Exec(`
+		INSERT INTO book_authors (book_id, author_id, role, position)
+		SELECT b.id, b.author_id, ?, 0 FROM books b
+		WHERE EXISTS (SELECT 1 FROM authors a WHERE a.id = b.author_id)
+			AND NOT EXISTS (SELECT 1 FROM book_authors ba WHERE ba.book_id = b.id)`, ContributorAuthor).Error
+}
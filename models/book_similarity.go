@@ -0,0 +1,10 @@
+package models
+
+// BookSimilarity is how alike the ratings of two books are, from 0 to 1, and how many readers
+// rated both. Only the books most similar to each book are kept, see package recommend.
+type BookSimilarity struct {
+	BookID        uint    `gorm:"primaryKey;autoIncrement:false"`
+	SimilarBookID uint    `gorm:"primaryKey;autoIncrement:false"`
+	Score         float64 `gorm:"not null"`
+	CoRatings     int64   `gorm:"not null"`
+}
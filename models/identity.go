@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Identity links a user to an account at an external OpenID Connect provider.
+type Identity struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"not null;index"`
+	Provider  string `gorm:"not null;uniqueIndex:idx_identities_provider_subject"`
+	Subject   string `gorm:"not null;uniqueIndex:idx_identities_provider_subject"`
+	Email     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
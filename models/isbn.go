@@ -0,0 +1,35 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/fokosun/go-rest-api/isbn"
+	"gorm.io/gorm"
+)
+
+// migrateISBN rewrites the ISBNs saved before they were validated as ISBN-13s and makes them
+// unique among the books that are not deleted. ISBNs that cannot be parsed are left as they are,
+// and are not covered by the index.
+func migrateISBN(db *gorm.DB) error {
+	var books []Book
+	if err := db.Unscoped().Select("id", "isbn").Where("isbn <> '' AND isbn !~ '^97[89][0-9]{10}$'").Find(&books).Error; err != nil {
+		return err
+	}
+
+	for _, book := range books {
+		canonical, err := isbn.Parse(book.Isbn)
+		if err != nil {
+			continue
+		}
+		if err := db.Unscoped().Model(&Book{}).Where("id = ?", book.ID).UpdateColumn("isbn", canonical).Error; err != nil {
+			return err
+		}
+	}
+
+	err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_books_isbn ON books (isbn)
+		WHERE deleted_at IS NULL AND isbn ~ '^97[89][0-9]{10}$'`).Error
+	if err != nil {
+		return fmt.Errorf("books that are not deleted share an ISBN, delete the duplicates first: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package models
+
+import "gorm.io/gorm"
+
+// Migrate creates or updates the tables for every model.
+func Migrate(db *gorm.DB) error {
+	if err := migrateBeforeSoftDelete(db); err != nil {
+		return err
+	}
+
+	if err := migrateBeforeRatingConstraints(db); err != nil {
+		return err
+	}
+
+	err := db.AutoMigrate(
+		&User{},
+		&Author{},
+		&Book{},
+		&BookAuthor{},
+		&Rating{},
+		&BookRatingStats{},
+		&ReviewVote{},
+		&BookSimilarity{},
+		&PasswordResetToken{},
+		&RecoveryCode{},
+		&APIKey{},
+		&Session{},
+		&Identity{},
+		&WebhookSubscription{},
+		&WebhookDelivery{},
+		&WebhookDeliveryAttempt{},
+		&OutboxEvent{},
+	)
+	if err != nil {
+		return err
+	}
+
+	if err := migrateSoftDelete(db); err != nil {
+		return err
+	}
+
+	if err := migrateBookAuthors(db); err != nil {
+		return err
+	}
+
+	if err := migrateISBN(db); err != nil {
+		return err
+	}
+
+	if err := migrateRatingStats(db); err != nil {
+		return err
+	}
+
+	return migrateSearch(db)
+}
+
+// migrateBeforeSoftDelete drops the unique constraint on user emails, which is replaced by an
+// index that leaves deleted accounts out so that their address can be used to register again.
+func migrateBeforeSoftDelete(db *gorm.DB) error {
+	for _, constraint := range []string{"uni_users_email", "users_email_key"} {
+		if err := db.Exec("ALTER TABLE IF EXISTS users DROP CONSTRAINT IF EXISTS " + constraint).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSoftDelete clears the zero times that books were saved with before deleted_at meant
+// that a book is deleted.
+func migrateSoftDelete(db *gorm.DB) error {
+	return db.Exec("UPDATE books SET deleted_at = NULL WHERE deleted_at < '0002-01-01'").Error
+}
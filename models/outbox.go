@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// OutboxEvent is a domain event written in the same transaction as the change it describes,
+// and published by the relay afterwards. Events are published in ID order per aggregate.
+// NextAttemptAt holds back an event that failed, or that a relay has claimed, until then,
+// and DeadAt is set when the relay gives up on it.
+type OutboxEvent struct {
+	ID            uint   `gorm:"primarykey"`
+	AggregateType string `gorm:"not null;index:idx_outbox_aggregate"`
+	AggregateID   uint   `gorm:"not null;index:idx_outbox_aggregate"`
+	EventType     string `gorm:"not null"`
+	Payload       string `gorm:"not null;type:text"`
+	CreatedAt     time.Time
+	PublishedAt   *time.Time `gorm:"index"`
+	Attempts      int        `gorm:"not null;default:0"`
+	LastError     string
+	NextAttemptAt *time.Time `gorm:"index"`
+	DeadAt        *time.Time
+}
+
+func (OutboxEvent) TableName() string {
+	return "outbox"
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// PasswordResetToken is a single-use token for resetting a forgotten password.
+// Only the SHA-256 hash of the token that was emailed to the user is stored.
+type PasswordResetToken struct {
+	ID        uint   `gorm:"primarykey"`
+	UserID    uint   `gorm:"not null;index"`
+	TokenHash string `gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// IsUsable reports whether the token has neither been used nor expired.
+func (t *PasswordResetToken) IsUsable() bool {
+	return t.UsedAt == nil && time.Now().Before(t.ExpiresAt)
+}
@@ -1,15 +1,35 @@
 package models
 
-import "time"
+import (
+	"time"
 
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// Rating is a user's rating of a book. A user rates a book once, and can rate it again after
+// deleting their rating. The comment is a review, which is only listed once it is approved.
 type Rating struct {
-	ID        uint `gorm:"primarykey"`
-	UserID    uint
-	BookID    int
-	Rating    int    `json:"rating" gorm:"default:1"`
-	Comment   string `json:"comment"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID           uint       `gorm:"primarykey"`
+	UserID       uint       `gorm:"uniqueIndex:idx_ratings_user_book,where:deleted_at IS NULL"`
+	BookID       int        `gorm:"uniqueIndex:idx_ratings_user_book"`
+	Rating       int        `json:"rating" gorm:"default:1;check:chk_ratings_rating,rating BETWEEN 1 AND 5" validate:"required,min=1,max=5"`
+	Comment      string     `json:"comment"`
+	ReviewStatus string     `json:"review_status" gorm:"not null;default:approved;index"`
+	Flagged      bool       `json:"flagged" gorm:"not null;default:false"`
+	FlaggedFor   string     `json:"flagged_for,omitempty"`
+	ModeratedBy  uint       `json:"moderated_by,omitempty"`
+	ModeratedAt  *time.Time `json:"moderated_at,omitempty"`
+	HelpfulCount int64      `json:"helpful_count" gorm:"not null;default:0"`
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	DeletedAt    gorm.DeletedAt `gorm:"index"`
 }
 
 func (r *Rating) SetBookID(bookID int) error {
@@ -21,3 +41,33 @@ func (r *Rating) SetUserID(userID uint) error {
 	r.UserID = userID
 	return nil
 }
+
+// Validate validates the Rating fields.
+func (r *Rating) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
+}
+
+// migrateBeforeRatingConstraints makes the ratings saved before they were checked fit the
+// constraints on them: ratings outside 1 to 5 are brought into the range, and only the latest
+// rating of a book by a user is kept.
+func migrateBeforeRatingConstraints(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&Rating{}) {
+		return nil
+	}
+
+	if err := db.Exec("UPDATE ratings SET rating = LEAST(GREATEST(rating, 1), 5) WHERE rating NOT BETWEEN 1 AND 5").Error; err != nil {
+		return err
+	}
+
+	// Ratings older than soft deletes have no deleted_at, so their duplicates are deleted for good
+	if !db.Migrator().HasColumn(&Rating{}, "deleted_at") {
+		return db.Exec(`DELETE FROM ratings WHERE id NOT IN (
+			SELECT DISTINCT ON (user_id, book_id) id FROM ratings ORDER BY user_id, book_id, updated_at DESC, id DESC)`).Error
+	}
+
+	return db.Exec(`UPDATE ratings SET deleted_at = now() WHERE deleted_at IS NULL AND id NOT IN (
+		SELECT DISTINCT ON (user_id, book_id) id FROM ratings
+		WHERE deleted_at IS NULL
+		ORDER BY user_id, book_id, updated_at DESC, id DESC)`).Error
+}
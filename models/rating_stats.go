@@ -0,0 +1,137 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/fokosun/go-rest-api/config"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BookRatingStats sums up the ratings of a book. It is changed in the same transaction as the
+// ratings, so that books can be shown and sorted with their ratings without counting them.
+type BookRatingStats struct {
+	BookID uint    `gorm:"primaryKey;autoIncrement:false"`
+	Count  int64   `gorm:"not null;default:0"`
+	Sum    int64   `gorm:"not null;default:0"`
+	Stars1 int64   `gorm:"column:stars_1;not null;default:0"`
+	Stars2 int64   `gorm:"column:stars_2;not null;default:0"`
+	Stars3 int64   `gorm:"column:stars_3;not null;default:0"`
+	Stars4 int64   `gorm:"column:stars_4;not null;default:0"`
+	Stars5 int64   `gorm:"column:stars_5;not null;default:0"`
+	Mean   float64 `gorm:"not null;default:0"`
+	// Score is the Bayesian average of the ratings, see config.RatingPrior
+	Score float64 `gorm:"not null;default:0;index"`
+}
+
+// NoRatingStats are the stats of a book that nobody has rated.
+func NoRatingStats(bookID uint) BookRatingStats {
+	mean, _ := config.RatingPrior()
+	return BookRatingStats{BookID: bookID, Score: mean}
+}
+
+// MarshalJSON shows the stars as a histogram rather than as one field each.
+func (s BookRatingStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Count     int64            `json:"count"`
+		Mean      float64          `json:"mean"`
+		Score     float64          `json:"score"`
+		Histogram map[string]int64 `json:"histogram"`
+	}{
+		Count: s.Count,
+		Mean:  s.Mean,
+		Score: s.Score,
+		Histogram: map[string]int64{
+			"1": s.Stars1,
+			"2": s.Stars2,
+			"3": s.Stars3,
+			"4": s.Stars4,
+			"5": s.Stars5,
+		},
+	})
+}
+
+// AddRating counts a new rating of a book.
+func AddRating(tx *gorm.DB, bookID uint, rating int) error {
+	return changeRatingStats(tx, bookID, rating, 1)
+}
+
+// RemoveRating stops counting a rating of a book.
+func RemoveRating(tx *gorm.DB, bookID uint, rating int) error {
+	return changeRatingStats(tx, bookID, rating, -1)
+}
+
+// ChangeRating counts a rating of a book that was changed from one value to another.
+func ChangeRating(tx *gorm.DB, bookID uint, from int, to int) error {
+	if from == to {
+		return nil
+	}
+	if err := RemoveRating(tx, bookID, from); err != nil {
+		return err
+	}
+	return AddRating(tx, bookID, to)
+}
+
+func changeRatingStats(tx *gorm.DB, bookID uint, rating int, delta int64) error {
+	stats := BookRatingStats{BookID: bookID, Count: delta, Sum: delta * int64(rating)}
+	updates := map[string]interface{}{
+		"count": gorm.Expr("book_rating_stats.count + ?", delta),
+		"sum":   gorm.Expr("book_rating_stats.sum + ?", stats.Sum),
+	}
+
+	// Ratings outside 1 to 5 are counted in the mean but have no bar in the histogram
+	stars := []*int64{&stats.Stars1, &stats.Stars2, &stats.Stars3, &stats.Stars4, &stats.Stars5}
+	if rating >= 1 && rating <= len(stars) {
+		*stars[rating-1] = delta
+		column := fmt.Sprintf("stars_%d", rating)
+		updates[column] = gorm.Expr("book_rating_stats."+column+" + ?", delta)
+	}
+
+	err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "book_id"}},
+		DoUpdates: clause.Assignments(updates),
+	}).Create(&stats).Error
+	if err != nil {
+		return err
+	}
+
+	return scoreRatings(tx.Where("book_id = ?", bookID))
+}
+
+// scoreRatings works out the mean and the score of the stats selected by query.
+func scoreRatings(query *gorm.DB) error {
+	mean, weight := config.RatingPrior()
+	return query.Model(&BookRatingStats{}).Updates(map[string]interface{}{
+		"mean": gorm.Expr("CASE WHEN count > 0 THEN sum::float8 / count ELSE 0 END"),
+		"score": gorm.Expr("CASE WHEN ?::float8 + count > 0 THEN (?::float8 + sum) / (?::float8 + count) ELSE ?::float8 END",
+			weight, weight*mean, weight, mean),
+	}).Error
+}
+
+// migrateRatingStats counts the ratings of every book again. It fills in the stats of books that
+// were rated before they were kept, and scores them again in case the prior has changed.
+func migrateRatingStats(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`
+			INSERT INTO book_rating_stats (book_id, count, sum, stars_1, stars_2, stars_3, stars_4, stars_5)
+			SELECT books.id, count(ratings.id), coalesce(sum(ratings.rating), 0),
+				count(ratings.id) FILTER (WHERE ratings.rating = 1),
+				count(ratings.id) FILTER (WHERE ratings.rating = 2),
+				count(ratings.id) FILTER (WHERE ratings.rating = 3),
+				count(ratings.id) FILTER (WHERE ratings.rating = 4),
+				count(ratings.id) FILTER (WHERE ratings.rating = 5)
+			FROM books
+			LEFT JOIN ratings ON ratings.book_id = books.id AND ratings.deleted_at IS NULL
+			GROUP BY books.id
+			ON CONFLICT (book_id) DO UPDATE SET
+				count = excluded.count, sum = excluded.sum,
+				stars_1 = excluded.stars_1, stars_2 = excluded.stars_2, stars_3 = excluded.stars_3,
+				stars_4 = excluded.stars_4, stars_5 = excluded.stars_5`).Error
+		if err != nil {
+			return err
+		}
+
+		return scoreRatings(tx.Where("1 = 1"))
+	})
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// ReviewVote is a user marking the review of a rating as helpful, which they can do once.
+type ReviewVote struct {
+	ID        uint `gorm:"primarykey"`
+	RatingID  uint `gorm:"not null;uniqueIndex:idx_review_votes_rating_user"`
+	UserID    uint `gorm:"not null;uniqueIndex:idx_review_votes_rating_user;index"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,33 @@
+package models
+
+import "gorm.io/gorm"
+
+// searchSchema adds the columns and indexes used by search. The tsvector columns are generated,
+// so Postgres keeps them current on every write, and the trigram indexes let misspelt searches
+// still find what was meant. A generated column cannot read other tables, so search.Books
+// matches books by the names of their contributors through the authors' vectors.
+var searchSchema = []string{
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', coalesce(title, '')), 'A') ||
+		setweight(to_tsvector('simple', regexp_replace(coalesce(isbn, ''), '[^0-9Xx]', '', 'g')), 'B')
+	) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_books_search_vector ON books USING gin (search_vector)`,
+	`CREATE INDEX IF NOT EXISTS idx_books_title_trgm ON books USING gin (title gin_trgm_ops)`,
+
+	`ALTER TABLE authors ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+		to_tsvector('simple', coalesce(firstname, '') || ' ' || coalesce(lastname, ''))
+	) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_authors_search_vector ON authors USING gin (search_vector)`,
+	`CREATE INDEX IF NOT EXISTS idx_authors_name_trgm ON authors USING gin ((firstname || ' ' || lastname) gin_trgm_ops)`,
+}
+
+func migrateSearch(db *gorm.DB) error {
+	for _, statement := range searchSchema {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
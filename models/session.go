@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Session is a single login on a device. Its ID is the token family shared by
+// the access and refresh tokens issued for that login.
+type Session struct {
+	ID         string `gorm:"primarykey"`
+	UserID     uint   `gorm:"not null;index"`
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+}
+
+// IsActive reports whether the session can still be used.
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrAuthorHasBooks = errors.New("author still has books")
+
+// PurgeBook permanently deletes a book together with its ratings and credits.
+func PurgeBook(tx *gorm.DB, id uint) error {
+	if err := deleteReviewVotes(tx, tx.Unscoped().Model(&Rating{}).Select("id").Where("book_id = ?", id)); err != nil {
+		return err
+	}
+	if err := tx.Unscoped().Where("book_id = ?", id).Delete(&Rating{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("book_id = ?", id).Delete(&BookRatingStats{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("book_id = ? OR similar_book_id = ?", id, id).Delete(&BookSimilarity{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("book_id = ?", id).Delete(&BookAuthor{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(&Book{}, id).Error
+}
+
+// PurgeAuthor permanently deletes an author. It is refused while they have any role on a book,
+// even one in the trash, since books cannot be without their credits.
+func PurgeAuthor(tx *gorm.DB, id uint) error {
+	var books int64
+	if err := tx.Unscoped().Model(&Book{}).Where("author_id = ? OR id IN (?)", id, BooksBy(tx, id)).Count(&books).Error; err != nil {
+		return err
+	}
+	if books > 0 {
+		return ErrAuthorHasBooks
+	}
+
+	return tx.Unscoped().Delete(&Author{}, id).Error
+}
+
+// PurgeUser permanently deletes a user with their ratings and everything they signed in with.
+// The books and authors they added stay in the catalogue.
+func PurgeUser(tx *gorm.DB, id uint) error {
+	var ratings []Rating
+	if err := tx.Where("user_id = ?", id).Find(&ratings).Error; err != nil {
+		return err
+	}
+	for _, rating := range ratings {
+		if err := RemoveRating(tx, uint(rating.BookID), rating.Rating); err != nil {
+			return err
+		}
+	}
+
+	// Their votes no longer count, and nobody can vote for their reviews
+	err := tx.Model(&Rating{}).Where("id IN (?)", tx.Model(&ReviewVote{}).Select("rating_id").Where("user_id = ?", id)).
+		UpdateColumn("helpful_count", gorm.Expr("helpful_count - 1")).Error
+	if err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ?", id).Delete(&ReviewVote{}).Error; err != nil {
+		return err
+	}
+	if err := deleteReviewVotes(tx, tx.Unscoped().Model(&Rating{}).Select("id").Where("user_id = ?", id)); err != nil {
+		return err
+	}
+
+	for _, owned := range []interface{}{&Rating{}, &Session{}, &APIKey{}, &Identity{}, &RecoveryCode{}, &PasswordResetToken{}} {
+		if err := tx.Unscoped().Where("user_id = ?", id).Delete(owned).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Unscoped().Delete(&User{}, id).Error
+}
+
+// PurgeTrash permanently deletes everything that was deleted before the given time and reports
+// how many items were removed. Authors that still have books are kept until the books are gone.
+func PurgeTrash(db *gorm.DB, before time.Time) (int, error) {
+	purged := 0
+
+	if err := deleteReviewVotes(db, db.Unscoped().Model(&Rating{}).Select("id").Where("deleted_at < ?", before)); err != nil {
+		return purged, err
+	}
+	result := db.Unscoped().Where("deleted_at < ?", before).Delete(&Rating{})
+	if result.Error != nil {
+		return purged, result.Error
+	}
+	purged += int(result.RowsAffected)
+
+	for _, kind := range []struct {
+		model interface{}
+		purge func(tx *gorm.DB, id uint) error
+	}{
+		{&Book{}, PurgeBook},
+		{&Author{}, PurgeAuthor},
+		{&User{}, PurgeUser},
+	} {
+		var ids []uint
+		if err := db.Unscoped().Model(kind.model).Where("deleted_at < ?", before).Pluck("id", &ids).Error; err != nil {
+			return purged, err
+		}
+
+		for _, id := range ids {
+			err := db.Transaction(func(tx *gorm.DB) error {
+				return kind.purge(tx, id)
+			})
+			if errors.Is(err, ErrAuthorHasBooks) {
+				continue
+			}
+			if err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// deleteReviewVotes deletes the votes for the ratings whose ids are selected by ratings.
+func deleteReviewVotes(tx *gorm.DB, ratings *gorm.DB) error {
+	return tx.Where("rating_id IN (?)", ratings).Delete(&ReviewVote{}).Error
+}
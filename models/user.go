@@ -15,15 +15,27 @@ const (
 	InvalidPasswordLengthMessage = "Password must be at least 8 characters long."
 )
 
+const (
+	RoleAdmin     = "admin"
+	RoleLibrarian = "librarian"
+	RoleReader    = "reader"
+)
+
 type User struct {
-	ID           uint   `gorm:"primarykey"`
-	Firstname    string `validate:"required"`
-	Lastname     string `validate:"required"`
-	Email        string `gorm:"unique;not null" validate:"required,email"`
-	Password     string `json:"password,omitempty" validate:"required" gorm:"-"`
-	PasswordHash string `json:"-" gorm:"not null"`
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID              uint       `gorm:"primarykey"`
+	Firstname       string     `validate:"required"`
+	Lastname        string     `validate:"required"`
+	Email           string     `gorm:"not null;uniqueIndex:idx_users_email,where:deleted_at IS NULL" validate:"required,email"`
+	Password        string     `json:"password,omitempty" validate:"required" gorm:"-"`
+	PasswordHash    string     `json:"-" gorm:"not null"`
+	Role            string     `json:"role" gorm:"not null;default:reader" validate:"omitempty,oneof=admin librarian reader"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	MFASecret       string     `json:"-"`
+	MFAEnabledAt    *time.Time `json:"mfa_enabled_at"`
+	MFALastUsedStep int64      `json:"-"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	DeletedAt       gorm.DeletedAt `gorm:"index"`
 }
 
 func (u *User) SetPassword(password string) error {
@@ -50,6 +62,26 @@ func (u *User) ValidatePassword(password string, minLength int) bool {
 	return len(password) >= minLength
 }
 
+// HasRole reports whether the user holds any of the given roles.
+func (u *User) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if u.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// IsEmailVerified reports whether the user has proven they own their email address.
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// IsMFAEnabled reports whether login requires a second factor.
+func (u *User) IsMFAEnabled() bool {
+	return u.MFAEnabledAt != nil
+}
+
 // Validate validates the User fields.
 func (u *User) Validate() error {
 	validate := validator.New()
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// WebhookSubscription is an endpoint that is sent the events it subscribes to.
+// Deliveries are signed with Secret so that the receiver can tell they came from us.
+type WebhookSubscription struct {
+	ID        uint   `gorm:"primarykey"`
+	URL       string `gorm:"not null"`
+	Secret    string `json:"-" gorm:"not null"`
+	Events    string `gorm:"not null"` // space separated
+	Active    bool   `gorm:"not null;default:true"`
+	CreatedBy uint   `gorm:"not null"`
+	// ConsecutiveFailures counts failed attempts since the last successful one
+	ConsecutiveFailures int `gorm:"not null;default:0"`
+	DisabledAt          *time.Time
+	DisabledReason      string
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+func (s *WebhookSubscription) SetEvents(events []string) {
+	s.Events = strings.Join(events, " ")
+}
+
+func (s *WebhookSubscription) EventList() []string {
+	return strings.Fields(s.Events)
+}
+
+// Subscribes reports whether the endpoint wants to receive the given event type.
+func (s *WebhookSubscription) Subscribes(eventType string) bool {
+	for _, event := range s.EventList() {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryDead      = "dead"
+)
+
+// WebhookDelivery is a single event sent to a single endpoint. It stays pending,
+// with NextAttemptAt set, until it succeeds or runs out of attempts and is dead-lettered.
+type WebhookDelivery struct {
+	ID             uint       `gorm:"primarykey"`
+	SubscriptionID uint       `gorm:"not null;index"`
+	EventID        string     `gorm:"not null;index"`
+	EventType      string     `gorm:"not null"`
+	Payload        string     `gorm:"not null;type:text"`
+	Status         string     `gorm:"not null;default:pending;index"`
+	Attempts       int        `gorm:"not null;default:0"`
+	NextAttemptAt  *time.Time `gorm:"index"`
+	DeliveredAt    *time.Time
+	// ReplayOf is set when an admin sent this delivery again
+	ReplayOf  *uint
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	AttemptLog []WebhookDeliveryAttempt `json:",omitempty" gorm:"foreignKey:DeliveryID"`
+}
+
+// WebhookDeliveryAttempt records how the receiver answered one attempt at a delivery.
+type WebhookDeliveryAttempt struct {
+	ID              uint  `gorm:"primarykey"`
+	DeliveryID      uint  `gorm:"not null;index"`
+	StatusCode      int   // zero when no response was received
+	LatencyMs       int64 `gorm:"not null"`
+	ResponseSnippet string
+	Error           string
+	CreatedAt       time.Time
+}
+
+// Succeeded reports whether the receiver acknowledged the delivery.
+func (a *WebhookDeliveryAttempt) Succeeded() bool {
+	return a.Error == "" && a.StatusCode >= 200 && a.StatusCode <= 299
+}
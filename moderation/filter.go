@@ -0,0 +1,104 @@
+// Package moderation flags review text that a moderator should look at before it is published.
+package moderation
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/fokosun/go-rest-api/config"
+)
+
+// DefaultPatterns flag the links and email addresses that spam reviews are made of.
+var DefaultPatterns = []string{
+	`(?i)\bhttps?://\S+`,
+	`(?i)\bwww\.\S+`,
+	`[\w.+-]+@[\w-]+\.[\w.-]+`,
+}
+
+// Filter flags text that contains one of its words, in any case, or matches one of its patterns.
+type Filter struct {
+	words    *regexp.Regexp
+	patterns []*regexp.Regexp
+}
+
+// NewFilter compiles a filter. Words only match whole words, so "ass" does not flag "class".
+func NewFilter(words []string, patterns []string) (*Filter, error) {
+	f := &Filter{}
+
+	var quoted []string
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) > 0 {
+		f.words = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+
+	for _, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.patterns = append(f.patterns, compiled)
+	}
+
+	return f, nil
+}
+
+// Check returns what flagged the text, or nothing when it is clean.
+func (f *Filter) Check(text string) []string {
+	var matches []string
+	if f.words != nil {
+		matches = append(matches, f.words.FindAllString(text, -1)...)
+	}
+	for _, pattern := range f.patterns {
+		matches = append(matches, pattern.FindAllString(text, -1)...)
+	}
+	return matches
+}
+
+var (
+	filter     *Filter
+	filterOnce sync.Once
+)
+
+// SetDefault replaces the filter used by the handlers.
+func SetDefault(f *Filter) {
+	filterOnce.Do(func() {})
+	filter = f
+}
+
+// Default returns the filter in use, configuring it from the environment on first use.
+func Default() *Filter {
+	filterOnce.Do(func() {
+		filter = FromEnv()
+	})
+	return filter
+}
+
+// FromEnv builds a filter from the comma separated REVIEW_BLOCKED_WORDS and the space separated
+// regular expressions in REVIEW_BLOCKED_PATTERNS, which default to DefaultPatterns.
+// A pattern that does not compile is left out.
+func FromEnv() *Filter {
+	words := strings.Split(config.Env("REVIEW_BLOCKED_WORDS", ""), ",")
+
+	patterns := DefaultPatterns
+	if value := config.Env("REVIEW_BLOCKED_PATTERNS", ""); value != "" {
+		patterns = strings.Fields(value)
+	}
+
+	var valid []string
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			log.Printf("Ignoring review pattern %q: %v", pattern, err)
+			continue
+		}
+		valid = append(valid, pattern)
+	}
+
+	f, _ := NewFilter(words, valid)
+	return f
+}
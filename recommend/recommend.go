@@ -0,0 +1,144 @@
+// Package recommend recommends books from ratings with item-to-item collaborative filtering: two
+// books are similar when the readers who rated both rated them alike, measured as the cosine
+// similarity of their ratings. Everything is worked out by Postgres.
+package recommend
+
+import (
+	"gorm.io/gorm"
+)
+
+// Why a book was recommended.
+const (
+	// ReasonSimilar books are similar to books the reader liked.
+	ReasonSimilar = "similar"
+	// ReasonPopular books are the best rated ones, for readers who have not rated enough yet.
+	ReasonPopular = "popular"
+)
+
+// neutral is the rating that says neither that a reader liked a book nor that they did not.
+// Books similar to those rated below it count against a recommendation.
+const neutral = 3
+
+// Recommendation is a recommended book. Score orders recommendations of the same Reason.
+type Recommendation struct {
+	BookID uint
+	Score  float64
+	Reason string
+}
+
+// Rebuild works out the similarities of every pair of books rated by at least minCoRatings of
+// the same readers, keeping the neighbours most similar books of each book.
+func Rebuild(db *gorm.DB, minCoRatings int, neighbours int) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM book_similarities").Error; err != nil {
+			return err
+		}
+
+		return tx.Exec(`
+			WITH rated AS (
+				SELECT r.user_id, r.book_id, r.rating::float8 AS rating
+				FROM ratings r
+				JOIN books b ON b.id = r.book_id AND b.deleted_at IS NULL
+				WHERE r.deleted_at IS NULL
+			),
+			norms AS (
+				SELECT book_id, sqrt(sum(rating * rating)) AS norm FROM rated GROUP BY book_id
+			),
+			pairs AS (
+				SELECT a.book_id, b.book_id AS similar_book_id, sum(a.rating * b.rating) AS dot, count(*) AS co_ratings
+				FROM rated a
+				JOIN rated b ON b.user_id = a.user_id AND b.book_id <> a.book_id
+				GROUP BY a.book_id, b.book_id
+				HAVING count(*) >= @min_co_ratings
+			),
+			ranked AS (
+				SELECT p.book_id, p.similar_book_id, p.dot / (na.norm * nb.norm) AS score, p.co_ratings,
+					row_number() OVER (PARTITION BY p.book_id ORDER BY p.dot / (na.norm * nb.norm) DESC, p.similar_book_id) AS position
+				FROM pairs p
+				JOIN norms na ON na.book_id = p.book_id
+				JOIN norms nb ON nb.book_id = p.similar_book_id
+			)
+			INSERT INTO book_similarities (book_id, similar_book_id, score, co_ratings)
+			SELECT book_id, similar_book_id, score, co_ratings FROM ranked WHERE position <= @neighbours`,
+			map[string]interface{}{"min_co_ratings": minCoRatings, "neighbours": neighbours},
+		).Error
+	})
+}
+
+// Similar returns the books most similar to a book, most similar first.
+func Similar(db *gorm.DB, bookID uint, limit int) ([]Recommendation, error) {
+	similar := []Recommendation{}
+	err := db.Table("book_similarities").
+		Select("book_similarities.similar_book_id AS book_id, book_similarities.score").
+		Joins("JOIN books ON books.id = book_similarities.similar_book_id AND books.deleted_at IS NULL").
+		Where("book_similarities.book_id = ?", bookID).
+		Order("book_similarities.score DESC, book_similarities.similar_book_id").
+		Limit(limit).
+		Scan(&similar).Error
+
+	for i := range similar {
+		similar[i].Reason = ReasonSimilar
+	}
+	return similar, err
+}
+
+// ForUser recommends books a user has not rated: first those similar to the books they liked,
+// then, when there are not enough of those, the best rated books.
+func ForUser(db *gorm.DB, userID uint, limit int) ([]Recommendation, error) {
+	recommendations := []Recommendation{}
+	err := db.Raw(`
+		SELECT s.similar_book_id AS book_id, sum(s.score * (r.rating - @neutral)) AS score
+		FROM ratings r
+		JOIN book_similarities s ON s.book_id = r.book_id
+		JOIN books b ON b.id = s.similar_book_id AND b.deleted_at IS NULL
+		WHERE r.user_id = @user AND r.deleted_at IS NULL
+			AND NOT EXISTS (
+				SELECT 1 FROM ratings own
+				WHERE own.user_id = @user AND own.book_id = s.similar_book_id AND own.deleted_at IS NULL
+			)
+		GROUP BY s.similar_book_id
+		HAVING sum(s.score * (r.rating - @neutral)) > 0
+		ORDER BY score DESC, book_id
+		LIMIT @limit`,
+		map[string]interface{}{"user": userID, "neutral": neutral, "limit": limit},
+	).Scan(&recommendations).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range recommendations {
+		recommendations[i].Reason = ReasonSimilar
+	}
+	if len(recommendations) >= limit {
+		return recommendations, nil
+	}
+
+	popular, err := popular(db, userID, recommendations, limit-len(recommendations))
+	return append(recommendations, popular...), err
+}
+
+// popular returns the books with the best rating scores that the user has not rated and that
+// are not already recommended.
+func popular(db *gorm.DB, userID uint, recommended []Recommendation, limit int) ([]Recommendation, error) {
+	query := db.Table("books").
+		Select("books.id AS book_id, book_rating_stats.score").
+		Joins("JOIN book_rating_stats ON book_rating_stats.book_id = books.id").
+		Where("books.deleted_at IS NULL AND book_rating_stats.count > 0").
+		Where("NOT EXISTS (SELECT 1 FROM ratings WHERE ratings.user_id = ? AND ratings.book_id = books.id AND ratings.deleted_at IS NULL)", userID)
+
+	if len(recommended) > 0 {
+		ids := make([]uint, len(recommended))
+		for i, recommendation := range recommended {
+			ids[i] = recommendation.BookID
+		}
+		query = query.Where("books.id NOT IN ?", ids)
+	}
+
+	books := []Recommendation{}
+	err := query.Order("book_rating_stats.score DESC, book_rating_stats.count DESC, books.id").Limit(limit).Scan(&books).Error
+
+	for i := range books {
+		books[i].Reason = ReasonPopular
+	}
+	return books, err
+}
@@ -1,8 +1,10 @@
 package routes
 
 import (
+	"github.com/fokosun/go-rest-api/auth"
 	"github.com/fokosun/go-rest-api/handlers"
 	"github.com/fokosun/go-rest-api/middlewares"
+	"github.com/fokosun/go-rest-api/models"
 	"github.com/gin-gonic/gin"
 )
 
@@ -10,30 +12,86 @@ func SetupApiRouter(router *gin.Engine) {
 	// Users Routes
 	users := router.Group("/api/users").Use(middlewares.AuthMiddleware())
 	{
-		users.GET("", handlers.GetUsers)
-		users.GET("/:id", handlers.GetUserByID)
-		users.PUT("/:id", handlers.UpdateUser)
-		users.DELETE("/:id", handlers.DeleteUser)
+		users.GET("", middlewares.RequirePermission(auth.PermUsersRead), handlers.GetUsers)
+		// Deleted accounts can be restored or purged by an admin
+		users.GET("/trash", middlewares.RequireRole(models.RoleAdmin), handlers.GetDeletedUsers)
+		users.POST("/:id/restore", middlewares.RequireRole(models.RoleAdmin), handlers.RestoreUser)
+		users.DELETE("/trash/:id", middlewares.RequireRole(models.RoleAdmin), handlers.PurgeUser)
+		users.GET("/:id", middlewares.RequirePermission(auth.PermUsersRead), handlers.GetUserByID)
+		// Users can update or delete their own account, admins can update or delete any account
+		users.PUT("/:id", middlewares.RequirePermission(auth.PermUsersWrite), handlers.UpdateUser)
+		users.DELETE("/:id", middlewares.RequirePermission(auth.PermUsersWrite), handlers.DeleteUser)
+		users.PUT("/:id/role", middlewares.RequireRole(models.RoleAdmin), handlers.UpdateUserRole)
+		users.DELETE("/:id/mfa", middlewares.RequireRole(models.RoleAdmin), handlers.ResetMFA)
+		users.POST("/:id/unlock", middlewares.RequireRole(models.RoleAdmin), handlers.UnlockUser)
 
 		// A user i.e reader can create/view/update an author
-		users.POST("/authors", handlers.CreateAuthor)
-		users.GET("/authors", handlers.GetAuthors)
-		users.GET("/authors/:id", handlers.GetAuthor)
-		users.PUT("/authors/:id", handlers.EditAuthor)
+		users.POST("/authors", middlewares.RequirePermission(auth.PermAuthorsWrite), handlers.CreateAuthor)
+		users.GET("/authors", middlewares.RequirePermission(auth.PermAuthorsRead), handlers.GetAuthors)
+		users.GET("/authors/:id", middlewares.RequirePermission(auth.PermAuthorsRead), handlers.GetAuthor)
+		users.GET("/authors/:id/books", middlewares.RequirePermission(auth.PermAuthorsRead), middlewares.RequirePermission(auth.PermBooksRead), handlers.GetAuthorBooks)
+		// Only the creator of the author or a librarian can update
+		users.PUT("/authors/:id", middlewares.RequirePermission(auth.PermAuthorsWrite), handlers.EditAuthor)
+		users.DELETE("/authors/:id", middlewares.RequirePermission(auth.PermAuthorsWrite), handlers.DeleteAuthor)
+		users.GET("/authors/trash", middlewares.RequirePermission(auth.PermAuthorsWrite), handlers.GetDeletedAuthors)
+		users.POST("/authors/:id/restore", middlewares.RequirePermission(auth.PermAuthorsWrite), handlers.RestoreAuthor)
+		users.DELETE("/authors/trash/:id", middlewares.RequireRole(models.RoleAdmin), handlers.PurgeAuthor)
+	}
+
+	// Routes for the authenticated user's own account
+	// These settings can only be changed by the user themselves, never with an API key
+	me := router.Group("/api/me").Use(middlewares.AuthMiddleware(), middlewares.DenyAPIKeys())
+	{
+		me.POST("/mfa/enroll", handlers.EnrollMFA)
+		me.POST("/mfa/confirm", handlers.ConfirmMFA)
+
+		me.GET("/api-keys", handlers.GetAPIKeys)
+		me.POST("/api-keys", handlers.CreateAPIKey)
+		me.DELETE("/api-keys/:id", handlers.RevokeAPIKey)
+
+		me.GET("/sessions", handlers.GetSessions)
+		me.DELETE("/sessions/:id", handlers.RevokeSession)
+	}
+
+	// Books recommended for the authenticated user, from their ratings
+	recommendations := router.Group("/api/me").Use(middlewares.AuthMiddleware(), middlewares.RequirePermission(auth.PermBooksRead))
+	{
+		recommendations.GET("/recommendations", handlers.GetRecommendations)
 	}
 
 	// Books Routes
 	books := router.Group("/api/books").Use(middlewares.AuthMiddleware())
 	{
-		books.GET("", handlers.GetBooks)
-		books.GET("/:id", handlers.GetBookByID)
-		books.POST("", handlers.CreateBook)
-		// Only the original creator of the book can delete
-		books.DELETE("/:id", handlers.DeleteBook)
+		books.GET("", middlewares.RequirePermission(auth.PermBooksRead), handlers.GetBooks)
+		books.GET("/:id", middlewares.RequirePermission(auth.PermBooksRead), handlers.GetBookByID)
+		books.GET("/isbn/:isbn", middlewares.RequirePermission(auth.PermBooksRead), handlers.GetBookByISBN)
+		books.GET("/:id/similar", middlewares.RequirePermission(auth.PermBooksRead), handlers.GetSimilarBooks)
+		books.POST("", middlewares.RequirePermission(auth.PermBooksWrite), handlers.CreateBook)
+		// Only the original creator of the book or a librarian can update. Admins are librarians too,
+		// and librarians, who may delete any book, may also correct it rather than recreate it.
+		books.PUT("/:id", middlewares.RequirePermission(auth.PermBooksWrite), handlers.UpdateBook)
+		books.PATCH("/:id", middlewares.RequirePermission(auth.PermBooksWrite), handlers.PatchBook)
+		// Only the original creator of the book or a librarian can delete
+		books.DELETE("/:id", middlewares.RequirePermission(auth.PermBooksWrite), handlers.DeleteBook)
+		// Deleted books stay in the trash until they are purged
+		books.GET("/trash", middlewares.RequirePermission(auth.PermBooksWrite), handlers.GetDeletedBooks)
+		books.POST("/:id/restore", middlewares.RequirePermission(auth.PermBooksWrite), handlers.RestoreBook)
+		books.DELETE("/trash/:id", middlewares.RequireRole(models.RoleAdmin), handlers.PurgeBook)
 
 		// Ratings
-		books.GET("/ratings", handlers.GetRatings)
-		books.GET("/:id/ratings", handlers.GetRatingsByBookID)
-		books.POST("/:id/ratings", handlers.CreateOrUpdateRating)
+		books.GET("/ratings", middlewares.RequirePermission(auth.PermRatingsRead), handlers.GetRatings)
+		books.GET("/:id/ratings", middlewares.RequirePermission(auth.PermRatingsRead), handlers.GetRatingsByBookID)
+		books.GET("/:id/ratings/summary", middlewares.RequirePermission(auth.PermRatingsRead), handlers.GetRatingSummary)
+		books.POST("/:id/ratings", middlewares.RequirePermission(auth.PermRatingsWrite), handlers.CreateOrUpdateRating)
+		books.DELETE("/:id/ratings", middlewares.RequirePermission(auth.PermRatingsWrite), handlers.DeleteRating)
+		// Reviews
+		books.GET("/ratings/moderation", middlewares.RequirePermission(auth.PermRatingsManage), handlers.GetReviewQueue)
+		books.POST("/ratings/:id/approve", middlewares.RequirePermission(auth.PermRatingsManage), handlers.ModerateReview(models.ReviewApproved))
+		books.POST("/ratings/:id/reject", middlewares.RequirePermission(auth.PermRatingsManage), handlers.ModerateReview(models.ReviewRejected))
+		books.POST("/ratings/:id/helpful", middlewares.RequirePermission(auth.PermRatingsWrite), handlers.VoteHelpful)
+		books.DELETE("/ratings/:id/helpful", middlewares.RequirePermission(auth.PermRatingsWrite), handlers.RemoveHelpfulVote)
 	}
+
+	// Search books and authors
+	router.GET("/api/search", middlewares.AuthMiddleware(), middlewares.RequirePermission(auth.PermBooksRead), middlewares.RequirePermission(auth.PermAuthorsRead), handlers.Search)
 }
@@ -10,8 +10,20 @@ func SetUpWebRouter(router *gin.Engine) {
 	auth := router.Group("/auth")
 	{
 		auth.POST("/login", handlers.Login)
+		auth.POST("/login/mfa", handlers.LoginMFA)
+		auth.POST("/refresh", handlers.RefreshToken)
+		auth.POST("/logout", handlers.Logout)
+		auth.POST("/password/forgot", handlers.ForgotPassword)
+		auth.POST("/password/reset", handlers.ResetPassword)
+		auth.GET("/verify", handlers.VerifyEmail)
+		auth.POST("/verify/resend", handlers.ResendVerificationEmail)
+		auth.GET("/oidc/:provider", handlers.OIDCLogin)
+		auth.GET("/oidc/:provider/callback", handlers.OIDCCallback)
 	}
 
 	// Register a new user
 	router.POST("/register", handlers.RegisterUser)
+
+	// Public keys for verifying the tokens we issue
+	router.GET("/.well-known/jwks.json", handlers.JWKS)
 }
@@ -1,5 +1,32 @@
 package routes
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/middlewares"
+	"github.com/fokosun/go-rest-api/webhooks"
+	"github.com/gin-gonic/gin"
+)
 
-func SetUpWebhookRouter(router *gin.Engine) {}
+func SetUpWebhookRouter(router *gin.Engine) {
+	// Outbound webhook subscriptions, managed by admins
+	subscriptions := router.Group("/api/webhooks").Use(middlewares.AuthMiddleware(), middlewares.RequirePermission(auth.PermWebhooksManage))
+	{
+		subscriptions.GET("", handlers.GetWebhookSubscriptions)
+		subscriptions.POST("", handlers.CreateWebhookSubscription)
+		subscriptions.GET("/:id", handlers.GetWebhookSubscription)
+		subscriptions.PUT("/:id", handlers.UpdateWebhookSubscription)
+		subscriptions.DELETE("/:id", handlers.DeleteWebhookSubscription)
+
+		// Delivery log, and sending deliveries again once a receiver has recovered
+		subscriptions.GET("/deliveries", handlers.GetWebhookDeliveries)
+		subscriptions.GET("/deliveries/:id", handlers.GetWebhookDelivery)
+		subscriptions.POST("/deliveries/replay", handlers.ReplayWebhookDeliveries)
+		subscriptions.POST("/deliveries/:id/replay", handlers.ReplayWebhookDelivery)
+	}
+
+	// Inbound webhooks from upstream systems, authenticated by their signature
+	webhooks.Handle("catalogue", handlers.SyncCatalogue)
+
+	router.POST("/webhooks/:source", middlewares.WebhookMiddleware(), handlers.ReceiveWebhook)
+}
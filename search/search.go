@@ -0,0 +1,119 @@
+// Package search finds books and authors with Postgres full text search, falling back on
+// trigram similarity so that misspelt searches still find what was meant.
+package search
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/fokosun/go-rest-api/isbn"
+	"gorm.io/gorm"
+)
+
+// Hit is a book or author that matched a search. Snippet is HTML escaped with the matching
+// words wrapped in <mark>.
+type Hit struct {
+	ID      uint
+	Score   float64
+	Snippet string
+}
+
+// Highlights are marked with control characters by Postgres and turned into tags once the
+// text is escaped, so that a title cannot inject markup.
+const (
+	startSel = "\x02"
+	stopSel  = "\x03"
+
+	headlineOptions = "StartSel=" + startSel + ", StopSel=" + stopSel + ", HighlightAll=true"
+)
+
+var isbnLike = regexp.MustCompile(`^[0-9Xx\- ]+$`)
+
+// TSQuery turns what a reader typed into a query that matches the beginning of every word,
+// e.g. "pragmatic prog" becomes "pragmatic:* & prog:*". Something that looks like an ISBN
+// also matches books by its digits, whichever way it is hyphenated.
+// It returns "" when there is nothing to search for.
+func TSQuery(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return ""
+	}
+
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	query := strings.Join(words, " & ")
+
+	if isbnLike.MatchString(text) {
+		digits := strings.ToLower(isbn.Normalize(text))
+		// Books are saved under their ISBN-13, which is how an ISBN-10 finds them
+		if canonical, err := isbn.Parse(text); err == nil {
+			digits = canonical
+		}
+		if len(words) > 1 || words[0] != digits+":*" {
+			query = "(" + query + ") | " + digits + ":*"
+		}
+	}
+
+	return query
+}
+
+// Books returns the best matching books, best first. A book also matches the names of its
+// authors and other contributors, which rank below its title and ISBN.
+func Books(db *gorm.DB, text string, limit int) ([]Hit, error) {
+	hits := []Hit{}
+	err := db.Raw(`
+		SELECT b.id,
+			ts_rank(b.search_vector || setweight(to_tsvector('simple', coalesce(c.names, '')), 'C'), q)
+				+ greatest(word_similarity(@text, b.title), word_similarity(@text, coalesce(c.names, ''))) AS score,
+			ts_headline('simple', b.title, q, @options) AS snippet
+		FROM books b
+		CROSS JOIN to_tsquery('simple', @query) q
+		LEFT JOIN LATERAL (
+			SELECT string_agg(a.firstname || ' ' || a.lastname, ' ' ORDER BY ba.position) AS names
+			FROM book_authors ba JOIN authors a ON a.id = ba.author_id
+			WHERE ba.book_id = b.id AND a.deleted_at IS NULL
+		) c ON true
+		WHERE b.deleted_at IS NULL AND (
+			b.search_vector @@ q OR @text <% b.title
+			OR b.id IN (
+				SELECT ba.book_id FROM book_authors ba JOIN authors a ON a.id = ba.author_id
+				WHERE a.deleted_at IS NULL
+					AND (a.search_vector @@ q OR @text <% (a.firstname || ' ' || a.lastname))
+			)
+		)
+		ORDER BY score DESC, b.id
+		LIMIT @limit`,
+		map[string]interface{}{"text": text, "query": TSQuery(text), "options": headlineOptions, "limit": limit},
+	).Scan(&hits).Error
+
+	return highlight(hits), err
+}
+
+// Authors returns the best matching authors, best first.
+func Authors(db *gorm.DB, text string, limit int) ([]Hit, error) {
+	hits := []Hit{}
+	err := db.Raw(`
+		SELECT a.id,
+			ts_rank(a.search_vector, q) + word_similarity(@text, a.firstname || ' ' || a.lastname) AS score,
+			ts_headline('simple', a.firstname || ' ' || a.lastname, q, @options) AS snippet
+		FROM authors a, to_tsquery('simple', @query) q
+		WHERE a.deleted_at IS NULL AND (a.search_vector @@ q OR @text <% (a.firstname || ' ' || a.lastname))
+		ORDER BY score DESC, a.id
+		LIMIT @limit`,
+		map[string]interface{}{"text": text, "query": TSQuery(text), "options": headlineOptions, "limit": limit},
+	).Scan(&hits).Error
+
+	return highlight(hits), err
+}
+
+func highlight(hits []Hit) []Hit {
+	for i, hit := range hits {
+		hits[i].Snippet = strings.NewReplacer(startSel, "<mark>", stopSel, "</mark>").Replace(html.EscapeString(hit.Snippet))
+	}
+	return hits
+}
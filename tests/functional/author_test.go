@@ -242,3 +242,32 @@ func TestGetAuthorByIdRespondsWith200IfUserExists(t *testing.T) {
 		config.DB.Delete(&newAuthor)
 	})
 }
+
+func TestEditAuthorCannotTargetAnotherAuthorThroughTheBody(t *testing.T) {
+	own := models.Author{Firstname: "Own", Lastname: "Author", CreatedBy: testUser.ID}
+	other := models.Author{Firstname: "Other", Lastname: "Author", CreatedBy: testUser.ID + 1}
+	config.DB.Create(&own)
+	config.DB.Create(&other)
+
+	t.Cleanup(func() {
+		config.DB.Unscoped().Delete(&own)
+		config.DB.Unscoped().Delete(&other)
+	})
+
+	w := httptest.NewRecorder()
+	body := fmt.Sprintf(`{"ID": %d, "firstname": "Renamed", "CreatedBy": %d}`, other.ID, testUser.ID+1)
+	req, _ := http.NewRequest("PUT", "/api/users/authors/"+strconv.Itoa(int(own.ID)), bytes.NewBufferString(body))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var unchanged models.Author
+	config.DB.First(&unchanged, other.ID)
+	assert.Equal(t, "Other", unchanged.Firstname)
+
+	var edited models.Author
+	config.DB.First(&edited, own.ID)
+	assert.Equal(t, "Renamed", edited.Firstname)
+	assert.Equal(t, "Author", edited.Lastname)
+	assert.Equal(t, testUser.ID, edited.CreatedBy)
+}
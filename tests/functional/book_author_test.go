@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func createContributor(t *testing.T, lastname string) models.Author {
+	author := models.Author{Firstname: "Contributing", Lastname: lastname, CreatedBy: testUser.ID}
+	config.DB.Create(&author)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&author)
+	})
+
+	return author
+}
+
+// createCreditedBook creates a book through the API with the given title and contributors.
+func createCreditedBook(t *testing.T, title string, contributors string) handlers.NewBook {
+	w := postJSON("/api/books", json.RawMessage(fmt.Sprintf(`{"title": %q, "contributors": %s}`, title, contributors)))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var book handlers.NewBook
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &book))
+
+	t.Cleanup(func() {
+		config.DB.Delete(&models.Book{}, book.ID)
+	})
+
+	return book
+}
+
+func TestBooksAreCreditedToEveryContributorInOrder(t *testing.T) {
+	translator := createContributor(t, "Translator")
+	writer := createContributor(t, "Writer")
+
+	book := createCreditedBook(t, "Credited", fmt.Sprintf(`[{"author_id": %d, "role": "translator"}, {"author_id": %d}]`, translator.ID, writer.ID))
+
+	assert.Equal(t, writer.ID, book.Author.ID, "the book is filed under its first author")
+	if assert.Len(t, book.Contributors, 2) {
+		assert.Equal(t, translator.ID, book.Contributors[0].AuthorID)
+		assert.Equal(t, models.ContributorTranslator, book.Contributors[0].Role)
+		assert.Equal(t, "Translator", book.Contributors[0].Author.Lastname)
+		assert.Equal(t, models.ContributorAuthor, book.Contributors[1].Role)
+		assert.Equal(t, 1, book.Contributors[1].Position)
+	}
+
+	w := sendRequest("GET", "/api/books/"+strconv.Itoa(book.ID), "", "")
+	var found handlers.NewBook
+	json.Unmarshal(w.Body.Bytes(), &found)
+	assert.Equal(t, book.Contributors[0].AuthorID, found.Contributors[0].AuthorID)
+}
+
+func TestBooksCreatedWithAnAuthorIDAreCreditedToThem(t *testing.T) {
+	w := postJSON("/api/books", CreateBookRequest{Title: "Single", AuthorID: testAuthor.ID})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var book handlers.NewBook
+	json.Unmarshal(w.Body.Bytes(), &book)
+	t.Cleanup(func() {
+		config.DB.Delete(&models.Book{}, book.ID)
+	})
+
+	if assert.Len(t, book.Contributors, 1) {
+		assert.Equal(t, testAuthor.ID, book.Contributors[0].AuthorID)
+		assert.Equal(t, models.ContributorAuthor, book.Contributors[0].Role)
+	}
+}
+
+func TestInvalidContributorsAreRefused(t *testing.T) {
+	author := createContributor(t, "Refused")
+
+	cases := map[string]struct {
+		contributors string
+		code         int
+	}{
+		"unknown role":   {fmt.Sprintf(`[{"author_id": %d, "role": "narrator"}]`, author.ID), http.StatusBadRequest},
+		"same role":      {fmt.Sprintf(`[{"author_id": %d}, {"author_id": %d, "role": "author"}]`, author.ID, author.ID), http.StatusBadRequest},
+		"missing author": {`[{"author_id": 999999999}]`, http.StatusNotFound},
+	}
+	for name, tc := range cases {
+		w := postJSON("/api/books", json.RawMessage(fmt.Sprintf(`{"title": "Refused", "contributors": %s}`, tc.contributors)))
+		assert.Equal(t, tc.code, w.Code, name+": "+w.Body.String())
+	}
+}
+
+func TestPatchingContributorsReplacesTheCredits(t *testing.T) {
+	writer := createContributor(t, "Patched Writer")
+	illustrator := createContributor(t, "Illustrator")
+	book := createCreditedBook(t, "Credited", fmt.Sprintf(`[{"author_id": %d}]`, writer.ID))
+
+	body := fmt.Sprintf(`{"contributors": [{"author_id": %d}, {"author_id": %d, "role": "illustrator"}]}`, writer.ID, illustrator.ID)
+	w := sendBookChange("PATCH", uint(book.ID), body, "application/merge-patch+json", `"1"`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var patched handlers.NewBook
+	json.Unmarshal(w.Body.Bytes(), &patched)
+	if assert.Len(t, patched.Contributors, 2) {
+		assert.Equal(t, illustrator.ID, patched.Contributors[1].AuthorID)
+		assert.Equal(t, models.ContributorIllustrator, patched.Contributors[1].Role)
+	}
+	assert.Equal(t, "Credited", patched.Title)
+}
+
+func TestAuthorBooksListEveryRole(t *testing.T) {
+	editor := createContributor(t, "Editor")
+	writer := createContributor(t, "Edited Writer")
+	createCreditedBook(t, "Edited", fmt.Sprintf(`[{"author_id": %d}, {"author_id": %d, "role": "editor"}]`, writer.ID, editor.ID))
+	createCreditedBook(t, "Written", fmt.Sprintf(`[{"author_id": %d}]`, editor.ID))
+
+	url := "/api/users/authors/" + strconv.Itoa(int(editor.ID)) + "/books"
+	w := sendRequest("GET", url, "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.ElementsMatch(t, []string{"Edited", "Written"}, bookTitles(t, w))
+
+	w = sendRequest("GET", url+"?role=editor", "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{"Edited"}, bookTitles(t, w))
+}
+
+func TestAuthorsCreditedOnABookCannotBeDeleted(t *testing.T) {
+	translator := createContributor(t, "Credited Translator")
+	createCreditedBook(t, "Credited", fmt.Sprintf(`[{"author_id": %d}, {"author_id": %d, "role": "translator"}]`, testAuthor.ID, translator.ID))
+
+	w := sendRequest("DELETE", "/api/users/authors/"+strconv.Itoa(int(translator.ID)), "", "")
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+}
@@ -138,7 +138,7 @@ func TestCreateBookRequiresBookTitleWhenNull(t *testing.T) {
 	w := httptest.NewRecorder()
 
 	requestData := CreateBookRequest{
-		Isbn: "ISB-111-111-111",
+		Isbn: "9780134190440",
 	}
 
 	jsonData, err := json.Marshal(requestData)
@@ -178,7 +178,7 @@ func TestCreateBookRequiresBookTitleWhenEmpty(t *testing.T) {
 
 	requestData := CreateBookRequest{
 		Title: "",
-		Isbn:  "ISB-111-111-111",
+		Isbn:  "9780134190440",
 	}
 
 	jsonData, err := json.Marshal(requestData)
@@ -213,54 +213,36 @@ func TestCreateBookRequiresBookTitleWhenEmpty(t *testing.T) {
 	assert.Equal(t, "title is required", errorResponse.Message)
 }
 
-func TestCreateBookRequiresUserID(t *testing.T) {
-	w := httptest.NewRecorder()
+func TestCreatedBooksBelongToTheAuthenticatedUser(t *testing.T) {
+	var otherUser models.User
+	otherUser.Firstname = "Not"
+	otherUser.Lastname = "Owner"
+	otherUser.Email = "not.owner@test.com"
+	otherUser.SetPassword("avalidPass")
+	config.DB.Create(&otherUser)
 
-	requestData := CreateBookRequest{
-		Title:    "Example Title",
-		Isbn:     "ISB-111-111-111",
-		AuthorID: 1,
-	}
+	w := postJSON("/api/books", CreateBookRequest{Title: "Owned", UserID: otherUser.ID, AuthorID: testAuthor.ID})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
 
-	jsonData, err := json.Marshal(requestData)
-	if err != nil {
-		panic(err)
-	}
+	var created handlers.NewBook
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
 
-	fullURL := "http://localhost:8080/api/books"
-	req, err := http.NewRequest("POST", fullURL, bytes.NewBuffer(jsonData))
-
-	if err != nil {
-		panic(err)
-	}
-
-	router.ServeHTTP(w, req)
+	var saved models.Book
+	config.DB.First(&saved, created.ID)
+	assert.Equal(t, testUser.ID, saved.UserID)
 
-	assert.Equal(t, http.StatusBadRequest, w.Code)
-
-	// Read the response body
-	bodyBytes, err := io.ReadAll(w.Result().Body)
-	assert.NoError(t, err)
-
-	// Print the response body for debugging purposes
-	fmt.Println(string(bodyBytes))
-
-	// Unmarshal the response body
-	var errorResponse *handlers.ErrorResponse
-	err = json.Unmarshal(bodyBytes, &errorResponse)
-	assert.NoError(t, err)
-
-	// Assert that the error message is as expected
-	assert.Equal(t, "user_id is required", errorResponse.Message)
+	t.Cleanup(func() {
+		config.DB.Delete(&saved)
+		config.DB.Unscoped().Delete(&otherUser)
+	})
 }
 
 func TestCreateBookRequiresAuthorID(t *testing.T) {
 	w := httptest.NewRecorder()
 
 	requestData := CreateBookRequest{
-		Title:  "Example Title",
-		Isbn:   "ISB-111-111-111",
-		UserID: uint(testUser.ID),
+		Title: "Example Title",
+		Isbn:  "9780134190440",
 	}
 
 	jsonData, err := json.Marshal(requestData)
@@ -311,8 +293,7 @@ func TestCreateBookRequiresAuthorIDToExist(t *testing.T) {
 
 	requestData := CreateBookRequest{
 		Title:    "Example Title",
-		Isbn:     "ISB-111-111-111",
-		UserID:   uint(testUser.ID),
+		Isbn:     "9780134190440",
 		AuthorID: lastAuthorID,
 	}
 
@@ -360,8 +341,7 @@ func TestCreateBookCanSuccessfullyCreateABookWhenNoErrors(t *testing.T) {
 
 	requestData := CreateBookRequest{
 		Title:    "Gonmmet Ditum",
-		Isbn:     "ISB-111-111-111",
-		UserID:   uint(newUser.ID),
+		Isbn:     "9780134190440",
 		AuthorID: uint(testAuthor.ID),
 	}
 
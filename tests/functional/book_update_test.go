@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func sendBookChange(method string, id uint, body string, contentType string, ifMatch string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest(method, "/api/books/"+strconv.Itoa(int(id)), bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", contentType)
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func createEditableBook(t *testing.T, ownerID uint) models.Book {
+	book := models.Book{Title: "Teh Typo", Isbn: "9780306406157", UserID: ownerID, AuthorID: testAuthor.ID, Version: 1}
+	config.DB.Create(&book)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&book)
+	})
+
+	return book
+}
+
+func TestGetBookSendsItsVersionAsETag(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+
+	w := sendRequest("GET", "/api/books/"+strconv.Itoa(int(book.ID)), "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+}
+
+func TestPutReplacesABook(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+
+	w := sendBookChange("PUT", book.ID, `{"title": "The Typo", "author_id": `+strconv.Itoa(int(testAuthor.ID))+`}`, "application/json", `"1"`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	var updated handlers.NewBook
+	json.Unmarshal(w.Body.Bytes(), &updated)
+	assert.Equal(t, "The Typo", updated.Title)
+	// A replace sets every field, so the ISBN that was left out is cleared
+	assert.Equal(t, "", updated.Isbn)
+	assert.Equal(t, uint(2), updated.Version)
+	assert.Equal(t, testAuthor.ID, updated.Author.ID)
+}
+
+func TestPatchOnlyChangesTheFieldsItNames(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+
+	w := sendBookChange("PATCH", book.ID, `{"title": "The Typo"}`, "application/merge-patch+json", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var stored models.Book
+	config.DB.First(&stored, book.ID)
+	assert.Equal(t, "The Typo", stored.Title)
+	assert.Equal(t, "9780306406157", stored.Isbn)
+	assert.Equal(t, uint(2), stored.Version)
+
+	// null removes a field
+	w = sendBookChange("PATCH", book.ID, `{"isbn": null}`, "application/merge-patch+json", `"2"`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	config.DB.First(&stored, book.ID)
+	assert.Equal(t, "", stored.Isbn)
+	assert.Equal(t, "The Typo", stored.Title)
+}
+
+func TestChangingABookWithAStaleETagFails(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+
+	// Another editor saves first
+	w := sendBookChange("PATCH", book.ID, `{"title": "Their Title"}`, "application/merge-patch+json", `"1"`)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = sendBookChange("PATCH", book.ID, `{"title": "My Title"}`, "application/merge-patch+json", `"1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = sendBookChange("PUT", book.ID, `{"title": "My Title", "author_id": 1}`, "application/json", `"1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	var stored models.Book
+	config.DB.First(&stored, book.ID)
+	assert.Equal(t, "Their Title", stored.Title)
+}
+
+func TestInvalidBookChangesAreRefused(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+
+	for _, change := range []struct {
+		method string
+		body   string
+		status int
+	}{
+		{"PUT", `{"isbn": "123", "author_id": 1}`, http.StatusBadRequest},
+		{"PUT", `{"title": "Title"}`, http.StatusBadRequest},
+		{"PUT", `{"title": "Title", "author_id": 1, "user_id": 5}`, http.StatusBadRequest},
+		{"PATCH", `{"title": null}`, http.StatusBadRequest},
+		{"PATCH", `{"version": 9}`, http.StatusBadRequest},
+		{"PATCH", `{"author_id": 999999}`, http.StatusNotFound},
+		{"PATCH", `not json`, http.StatusBadRequest},
+	} {
+		w := sendBookChange(change.method, book.ID, change.body, "application/merge-patch+json", "")
+		assert.Equal(t, change.status, w.Code, change.body)
+	}
+
+	w := sendBookChange("PATCH", book.ID, `{"title": "Title"}`, "text/plain", "")
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	var stored models.Book
+	config.DB.First(&stored, book.ID)
+	assert.Equal(t, uint(1), stored.Version)
+}
+
+func TestOnlyTheOwnerOrALibrarianCanChangeABook(t *testing.T) {
+	owner := createLoginUser("book-owner@example.com")
+	t.Cleanup(func() {
+		config.DB.Delete(&owner)
+	})
+	book := createEditableBook(t, owner.ID)
+
+	w := sendBookChange("PATCH", book.ID, `{"title": "Not Mine"}`, "application/merge-patch+json", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	actAs(t, models.RoleLibrarian)
+
+	w = sendBookChange("PATCH", book.ID, `{"title": "Fixed By A Librarian"}`, "application/merge-patch+json", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestChangingAMissingBookFails(t *testing.T) {
+	w := sendBookChange("PATCH", 0, `{"title": "Nothing"}`, "application/merge-patch+json", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
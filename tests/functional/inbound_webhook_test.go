@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/events"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/fokosun/go-rest-api/webhooks"
+	"github.com/stretchr/testify/assert"
+)
+
+const catalogueSecret = "catalogue-secret"
+
+func useCatalogueSource() {
+	webhooks.SetSource(webhooks.Source{
+		Name:            "catalogue",
+		Secret:          catalogueSecret,
+		SignatureHeader: webhooks.SignatureHeader,
+		TimestampHeader: webhooks.TimestampHeader,
+		NonceHeader:     webhooks.IDHeader,
+		Tolerance:       5 * time.Minute,
+	})
+}
+
+func sendWebhook(source string, body string, timestamp time.Time, signature string, id string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	req, err := http.NewRequest("POST", "/webhooks/"+source, bytes.NewBufferString(body))
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set(webhooks.TimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+	req.Header.Set(webhooks.SignatureHeader, signature)
+	req.Header.Set(webhooks.IDHeader, id)
+
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func restoreTestBookTitle(t *testing.T) {
+	t.Cleanup(func() {
+		config.DB.Model(&models.Book{}).Where("id = ?", testBook.ID).Update("title", testBook.Title)
+	})
+}
+
+func TestSignedCatalogueWebhookUpdatesBooks(t *testing.T) {
+	useCatalogueSource()
+	restoreTestBookTitle(t)
+
+	var before models.Book
+	config.DB.First(&before, testBook.ID)
+	var lastEvent models.OutboxEvent
+	config.DB.Order("id DESC").Limit(1).Find(&lastEvent)
+
+	body := `{"books":[{"isbn":"` + testBook.Isbn + `","title":"Updated upstream"}]}`
+	now := time.Now()
+
+	w := sendWebhook("catalogue", body, now, webhooks.Sign(catalogueSecret, now, []byte(body)), auth.NewSecret())
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var book models.Book
+	config.DB.First(&book, testBook.ID)
+	assert.Equal(t, "Updated upstream", book.Title)
+	assert.Equal(t, before.Version+1, book.Version, "the ETags of the book are stale now")
+
+	var recorded int64
+	config.DB.Model(&models.OutboxEvent{}).Where("aggregate_type = ? AND aggregate_id = ? AND event_type = ? AND id > ?", events.AggregateBook, testBook.ID, webhooks.EventBookUpdated, lastEvent.ID).Count(&recorded)
+	assert.Equal(t, int64(1), recorded)
+}
+
+func TestWebhookWithInvalidSignatureIsRejected(t *testing.T) {
+	useCatalogueSource()
+	restoreTestBookTitle(t)
+
+	body := `{"books":[{"isbn":"` + testBook.Isbn + `","title":"Forged"}]}`
+	now := time.Now()
+
+	w := sendWebhook("catalogue", body, now, webhooks.Sign("wrong-secret", now, []byte(body)), auth.NewSecret())
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Signed for another body
+	w = sendWebhook("catalogue", body, now, webhooks.Sign(catalogueSecret, now, []byte(`{"books":[]}`)), auth.NewSecret())
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var book models.Book
+	config.DB.First(&book, testBook.ID)
+	assert.Equal(t, testBook.Title, book.Title)
+}
+
+func TestWebhookOutsideTheToleranceWindowIsRejected(t *testing.T) {
+	useCatalogueSource()
+
+	body := `{"books":[]}`
+	old := time.Now().Add(-10 * time.Minute)
+
+	w := sendWebhook("catalogue", body, old, webhooks.Sign(catalogueSecret, old, []byte(body)), auth.NewSecret())
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestReplayedWebhookIsRejected(t *testing.T) {
+	useCatalogueSource()
+
+	body := `{"books":[]}`
+	now := time.Now()
+	signature := webhooks.Sign(catalogueSecret, now, []byte(body))
+
+	w := sendWebhook("catalogue", body, now, signature, auth.NewSecret())
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The same request, even with a different id
+	w = sendWebhook("catalogue", body, now, signature, auth.NewSecret())
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	// The same delivery signed again, as a source retrying it would
+	id := auth.NewSecret()
+	later := now.Add(time.Second)
+	w = sendWebhook("catalogue", body, later, webhooks.Sign(catalogueSecret, later, []byte(body)), id)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	evenLater := now.Add(2 * time.Second)
+	w = sendWebhook("catalogue", body, evenLater, webhooks.Sign(catalogueSecret, evenLater, []byte(body)), id)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestFailedWebhookCanBeRetriedBySource(t *testing.T) {
+	useCatalogueSource()
+
+	id := auth.NewSecret()
+	body := `{"books":[{"isbn":"","title":"No isbn"}]}`
+	now := time.Now()
+
+	w := sendWebhook("catalogue", body, now, webhooks.Sign(catalogueSecret, now, []byte(body)), id)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	body = `{"books":[]}`
+	later := now.Add(time.Second)
+	w = sendWebhook("catalogue", body, later, webhooks.Sign(catalogueSecret, later, []byte(body)), id)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestFailedWebhookCanBeRetriedUnchanged(t *testing.T) {
+	useCatalogueSource()
+
+	id := auth.NewSecret()
+	body := `{"books":[{"isbn":"","title":"No isbn"}]}`
+	now := time.Now()
+	signature := webhooks.Sign(catalogueSecret, now, []byte(body))
+
+	w := sendWebhook("catalogue", body, now, signature, id)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "needs an isbn and a title")
+
+	// Neither the signature nor the id is held against the exact same request sent again
+	w = sendWebhook("catalogue", body, now, signature, id)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestWebhookThatCannotBeProcessedIsAnErrorOnOurSide(t *testing.T) {
+	webhooks.SetSource(webhooks.Source{
+		Name:            "unreachable",
+		Secret:          catalogueSecret,
+		SignatureHeader: webhooks.SignatureHeader,
+		TimestampHeader: webhooks.TimestampHeader,
+		NonceHeader:     webhooks.IDHeader,
+		Tolerance:       5 * time.Minute,
+	})
+	webhooks.Handle("unreachable", func(context.Context, []byte) error {
+		return errors.New("dial tcp 10.0.0.1:5432: connection refused")
+	})
+
+	id := auth.NewSecret()
+	body := `{}`
+	now := time.Now()
+	signature := webhooks.Sign(catalogueSecret, now, []byte(body))
+
+	w := sendWebhook("unreachable", body, now, signature, id)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "connection refused")
+
+	// The source may send it again once we have recovered
+	webhooks.Handle("unreachable", func(context.Context, []byte) error { return nil })
+	w = sendWebhook("unreachable", body, now, signature, id)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestWebhookFromUnknownSourceIsNotFound(t *testing.T) {
+	body := `{}`
+	now := time.Now()
+
+	w := sendWebhook("nobody", body, now, webhooks.Sign("secret", now, []byte(body)), auth.NewSecret())
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
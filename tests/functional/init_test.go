@@ -1,12 +1,23 @@
 package tests
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/fokosun/go-rest-api/auth"
 	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/events"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/jobs"
+	"github.com/fokosun/go-rest-api/mail"
 	"github.com/fokosun/go-rest-api/models"
 	"github.com/fokosun/go-rest-api/routes"
+	"github.com/fokosun/go-rest-api/webhooks"
 	"github.com/gin-gonic/gin"
 )
 
@@ -14,10 +25,15 @@ var router *gin.Engine
 var testUser models.User
 var testAuthor models.Author
 var testBook models.Book
+var testMailer *mail.MemoryMailer
+var relay *events.Relay
 
 func TestMain(m *testing.M) {
 	gin.SetMode(gin.TestMode)
 
+	// Tokens are signed with an ephemeral key
+	os.Setenv("APP_ENV", "test")
+
 	os.Setenv("DB_HOST", "localhost")
 	os.Setenv("DB_USER", "root")
 	os.Setenv("DB_PASSWORD", "pass")
@@ -25,6 +41,53 @@ func TestMain(m *testing.M) {
 	os.Setenv("DB_PORT", "5432")
 
 	config.ConnectDatabase()
+	config.ConnectToRedisServer()
+	models.Migrate(config.DB)
+
+	// Start every run without failed logins or reset requests left over from previous runs
+	for _, prefix := range []string{auth.LoginThrottlePrefix, auth.PasswordResetThrottlePrefix} {
+		throttled, _ := config.Client.Keys(config.Ctx, prefix+"*").Result()
+		if len(throttled) > 0 {
+			config.Client.Del(config.Ctx, throttled...)
+		}
+	}
+
+	testMailer = mail.NewMemoryMailer()
+	mail.SetDefault(testMailer)
+
+	// Retry failed webhooks quickly so that tests do not have to wait for them
+	dispatcher := webhooks.NewDispatcher(config.WebhookRetry{
+		MaxAttempts:  3,
+		BaseDelay:    20 * time.Millisecond,
+		MaxDelay:     100 * time.Millisecond,
+		DisableAfter: 5,
+		PollInterval: 20 * time.Millisecond,
+		RequeueAfter: time.Minute,
+	})
+	webhooks.SetDefault(dispatcher)
+
+	// Run the jobs the handlers queue, quickly
+	queue := &jobs.Queue{
+		Stream:            "test:jobs",
+		Group:             "workers",
+		Concurrency:       2,
+		VisibilityTimeout: 5 * time.Second,
+		MaxAttempts:       3,
+		RetryBaseDelay:    10 * time.Millisecond,
+		RetryMaxDelay:     20 * time.Millisecond,
+		PollInterval:      10 * time.Millisecond,
+	}
+	config.Client.Del(config.Ctx, queue.Stream, queue.Stream+":delayed", queue.Stream+":dead")
+	jobs.SetDefault(queue)
+	queue.Register(handlers.SendPasswordResetJob, handlers.SendPasswordReset)
+	dispatcher.HandleJobs(queue)
+	if err := queue.Start(); err != nil {
+		panic(err)
+	}
+
+	relay = events.NewRelay(webhooks.OutboxSink{}, events.DefaultBus)
+	relay.PollInterval = 20 * time.Millisecond
+	relay.Start()
 
 	testUser.Firstname = "Test User Firstname"
 	testUser.Lastname = "Test User Lastname"
@@ -42,7 +105,7 @@ func TestMain(m *testing.M) {
 	config.DB.FirstOrCreate(&testAuthor)
 
 	testBook.Title = "Test Book title"
-	testBook.Isbn = "ISB-111-111-111"
+	testBook.Isbn = "9780262033848"
 	testBook.UserID = testUser.ID
 	testBook.AuthorID = testAuthor.ID
 
@@ -55,9 +118,27 @@ func TestMain(m *testing.M) {
 	code := m.Run()
 
 	// Cleanup
+	queue.Stop(context.Background())
 	config.DB.Delete(&testUser)
 	config.DB.Delete(&testBook)
 	config.DB.Delete(&testAuthor)
 
 	os.Exit(code)
 }
+
+// sendRequest serves a request with the given body to the router, as the bearer of token when
+// it is not empty and otherwise as the test user.
+func sendRequest(method string, url string, body string, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		panic(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	router.ServeHTTP(w, req)
+	return w
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/isbn"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestISBNsAreParsedAsISBN13(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+		err   error
+	}{
+		{"0-306-40615-2", "9780306406157", nil},
+		{"978 0 306 40615 7", "9780306406157", nil},
+		{"080442957x", "9780804429573", nil},
+		{"979-10-90636-07-1", "9791090636071", nil},
+		{"0-306-40615-3", "", isbn.ErrInvalidChecksum},
+		{"978-0-306-40615-8", "", isbn.ErrInvalidChecksum},
+		{"03064X6152", "", isbn.ErrInvalidCharacter},
+		{"9770306406157", "", isbn.ErrInvalidPrefix},
+		{"ISB-111-111-111", "", isbn.ErrInvalidLength},
+	}
+
+	for _, tc := range cases {
+		got, err := isbn.Parse(tc.input)
+		assert.Equal(t, tc.want, got, tc.input)
+		assert.Equal(t, tc.err, err, tc.input)
+	}
+}
+
+func TestOnlyISBN13sStartingWith978HaveAnISBN10(t *testing.T) {
+	isbn10, ok := isbn.To10("9780804429573")
+	assert.True(t, ok)
+	assert.Equal(t, "080442957X", isbn10)
+
+	_, ok = isbn.To10("9791090636071")
+	assert.False(t, ok)
+}
+
+func TestCreateBookRefusesAnISBNWithABadChecksum(t *testing.T) {
+	w := postJSON("/api/books", CreateBookRequest{Title: "Checked", Isbn: "978-0-306-40615-8", AuthorID: testAuthor.ID})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var response handlers.FieldErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	assert.Equal(t, isbn.ErrInvalidChecksum.Error(), response.Errors["isbn"])
+}
+
+func TestCreateBookSavesTheISBN13(t *testing.T) {
+	w := postJSON("/api/books", CreateBookRequest{Title: "Hyphenated", Isbn: "0-306-40615-2", AuthorID: testAuthor.ID})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var created handlers.NewBook
+	json.Unmarshal(w.Body.Bytes(), &created)
+	t.Cleanup(func() {
+		config.DB.Delete(&models.Book{}, created.ID)
+	})
+
+	assert.Equal(t, "9780306406157", created.Isbn)
+	assert.Equal(t, "0306406152", created.Isbn10)
+}
+
+func TestBooksCannotShareAnISBN(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+
+	// The ISBN-10 of the same book is the same ISBN
+	w := postJSON("/api/books", CreateBookRequest{Title: "Copy", Isbn: "0306406152", AuthorID: testAuthor.ID})
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+
+	other := models.Book{Title: "Other", AuthorID: testAuthor.ID, Version: 1}
+	config.DB.Create(&other)
+	t.Cleanup(func() {
+		config.DB.Delete(&other)
+	})
+
+	w = sendBookChange("PATCH", other.ID, `{"isbn": "`+book.Isbn+`"}`, "application/merge-patch+json", "")
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+
+	// A book keeps its own ISBN when it is changed
+	w = sendBookChange("PATCH", book.ID, `{"title": "Same ISBN"}`, "application/merge-patch+json", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func TestBooksCanBeLookedUpByEitherISBN(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+
+	for _, value := range []string{"978-0-306-40615-7", "0-306-40615-2"} {
+		w := sendRequest("GET", "/api/books/isbn/"+value, "", "")
+		assert.Equal(t, http.StatusOK, w.Code, value)
+
+		var found handlers.NewBook
+		json.Unmarshal(w.Body.Bytes(), &found)
+		assert.Equal(t, int(book.ID), found.ID)
+	}
+
+	w := sendRequest("GET", "/api/books/isbn/0-306-40615-3", "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = sendRequest("GET", "/api/books/isbn/9780201633610", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
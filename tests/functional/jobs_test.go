@@ -0,0 +1,248 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/jobs"
+	"github.com/fokosun/go-rest-api/mail"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestQueue returns a queue on its own stream that retries quickly. It is not started.
+func newTestQueue(t *testing.T) *jobs.Queue {
+	queue := &jobs.Queue{
+		Stream:            "test:jobs:" + t.Name(),
+		Group:             "workers",
+		Concurrency:       2,
+		VisibilityTimeout: time.Second,
+		MaxAttempts:       3,
+		RetryBaseDelay:    10 * time.Millisecond,
+		RetryMaxDelay:     20 * time.Millisecond,
+		PollInterval:      10 * time.Millisecond,
+	}
+
+	t.Cleanup(func() {
+		config.Client.Del(config.Ctx, queue.Stream, queue.Stream+":delayed", queue.Stream+":dead")
+	})
+
+	return queue
+}
+
+func startTestQueue(t *testing.T, queue *jobs.Queue) {
+	assert.NoError(t, queue.Start())
+	t.Cleanup(func() {
+		queue.Stop(context.Background())
+	})
+}
+
+func receive(t *testing.T, ran chan jobs.Job) jobs.Job {
+	select {
+	case job := <-ran:
+		return job
+	case <-time.After(5 * time.Second):
+		t.Fatal("the job did not run")
+		return jobs.Job{}
+	}
+}
+
+func TestJobsRunWithTheirPayload(t *testing.T) {
+	queue := newTestQueue(t)
+	ran := make(chan jobs.Job, 1)
+	queue.Register("greet", func(ctx context.Context, job jobs.Job) error {
+		ran <- job
+		return nil
+	})
+	startTestQueue(t, queue)
+
+	enqueued, err := queue.Enqueue(context.Background(), "greet", map[string]string{"name": "Ada"})
+	assert.NoError(t, err)
+
+	job := receive(t, ran)
+	assert.Equal(t, enqueued.ID, job.ID)
+	assert.Equal(t, 0, job.Attempts)
+
+	var payload map[string]string
+	assert.NoError(t, job.Decode(&payload))
+	assert.Equal(t, "Ada", payload["name"])
+}
+
+func TestFailedJobsAreRetried(t *testing.T) {
+	queue := newTestQueue(t)
+	ran := make(chan jobs.Job, 3)
+	queue.Register("flaky", func(ctx context.Context, job jobs.Job) error {
+		ran <- job
+		if job.Attempts < 2 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	startTestQueue(t, queue)
+
+	_, err := queue.Enqueue(context.Background(), "flaky", nil)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 0, receive(t, ran).Attempts)
+	assert.Equal(t, 1, receive(t, ran).Attempts)
+
+	last := receive(t, ran)
+	assert.Equal(t, 2, last.Attempts)
+	assert.Equal(t, "not yet", last.LastError)
+}
+
+func TestJobsThatRunOutOfAttemptsAreDeadLettered(t *testing.T) {
+	queue := newTestQueue(t)
+	var runs int32
+	queue.Register("broken", func(ctx context.Context, job jobs.Job) error {
+		atomic.AddInt32(&runs, 1)
+		panic("broken for good")
+	})
+	startTestQueue(t, queue)
+
+	enqueued, err := queue.Enqueue(context.Background(), "broken", nil)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		dead, _ := queue.DeadJobs(context.Background(), 10)
+		return len(dead) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	dead, _ := queue.DeadJobs(context.Background(), 10)
+	assert.Equal(t, enqueued.ID, dead[0].ID)
+	assert.Equal(t, 3, dead[0].Attempts)
+	assert.Contains(t, dead[0].LastError, "broken for good")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&runs))
+}
+
+func TestJobsOfAnUnknownTypeAreDeadLettered(t *testing.T) {
+	queue := newTestQueue(t)
+	startTestQueue(t, queue)
+
+	_, err := queue.Enqueue(context.Background(), "unknown", nil)
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		dead, _ := queue.DeadJobs(context.Background(), 10)
+		return len(dead) == 1 && dead[0].Type == "unknown"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	dead, _ := queue.DeadJobs(context.Background(), 10)
+	assert.Contains(t, dead[0].LastError, jobs.ErrUnknownJobType.Error())
+}
+
+func TestDelayedJobsWaitUntilTheyAreDue(t *testing.T) {
+	queue := newTestQueue(t)
+	ran := make(chan jobs.Job, 1)
+	queue.Register("later", func(ctx context.Context, job jobs.Job) error {
+		ran <- job
+		return nil
+	})
+	startTestQueue(t, queue)
+
+	enqueuedAt := time.Now()
+	_, err := queue.EnqueueIn(context.Background(), "later", nil, 300*time.Millisecond)
+	assert.NoError(t, err)
+
+	receive(t, ran)
+	assert.GreaterOrEqual(t, time.Since(enqueuedAt), 300*time.Millisecond)
+}
+
+func TestStuckJobsAreClaimedBack(t *testing.T) {
+	queue := newTestQueue(t)
+	queue.VisibilityTimeout = 100 * time.Millisecond
+	ran := make(chan jobs.Job, 1)
+	queue.Register("stuck", func(ctx context.Context, job jobs.Job) error {
+		ran <- job
+		return nil
+	})
+
+	// A worker of another instance takes the job and dies before finishing it
+	assert.NoError(t, config.Client.XGroupCreateMkStream(config.Ctx, queue.Stream, queue.Group, "0").Err())
+	enqueued, err := queue.Enqueue(context.Background(), "stuck", nil)
+	assert.NoError(t, err)
+	taken, err := config.Client.XReadGroup(config.Ctx, &redis.XReadGroupArgs{
+		Group:    queue.Group,
+		Consumer: "dead-instance",
+		Streams:  []string{queue.Stream, ">"},
+		Count:    1,
+	}).Result()
+	assert.NoError(t, err)
+	assert.Len(t, taken[0].Messages, 1)
+
+	startTestQueue(t, queue)
+
+	job := receive(t, ran)
+	assert.Equal(t, enqueued.ID, job.ID)
+	assert.Equal(t, 1, job.Attempts)
+	assert.Equal(t, jobs.ErrVisibilityTimeout.Error(), job.LastError)
+}
+
+func TestStopWaitsForRunningJobs(t *testing.T) {
+	queue := newTestQueue(t)
+	started := make(chan struct{})
+	var finished int32
+	queue.Register("slow", func(ctx context.Context, job jobs.Job) error {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+		return nil
+	})
+	assert.NoError(t, queue.Start())
+
+	_, err := queue.Enqueue(context.Background(), "slow", nil)
+	assert.NoError(t, err)
+	<-started
+
+	assert.NoError(t, queue.Stop(context.Background()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&finished))
+
+	pending, err := config.Client.XPending(config.Ctx, queue.Stream, queue.Group).Result()
+	assert.NoError(t, err)
+	assert.Zero(t, pending.Count)
+}
+
+func TestQueuedMailerSendsFromTheQueue(t *testing.T) {
+	queue := newTestQueue(t)
+	mailer := mail.NewMemoryMailer()
+	mail.HandleJobs(queue, mailer)
+	startTestQueue(t, queue)
+
+	err := mail.QueuedMailer{Queue: queue}.Send(mail.Message{To: "queued@example.com", Subject: "Hello", Body: "From the queue"})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		msg, ok := mailer.LastTo("queued@example.com")
+		return ok && msg.Subject == "Hello"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestRecurringJobsRunOncePerInterval(t *testing.T) {
+	var runs int32
+	first := newTestQueue(t)
+	second := newTestQueue(t)
+	t.Cleanup(func() {
+		config.Client.Del(config.Ctx, first.Stream+":recurring:tick")
+	})
+
+	// Two instances share the stream and both schedule the job
+	for _, queue := range []*jobs.Queue{first, second} {
+		queue.Register("tick", func(ctx context.Context, job jobs.Job) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		})
+		queue.Every("tick", time.Hour)
+		startTestQueue(t, queue)
+	}
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&runs) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&runs))
+}
@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWKSPublishesTheSigningKey(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Read the response body
+	bodyBytes, err := io.ReadAll(w.Result().Body)
+	assert.NoError(t, err)
+
+	// Print the response body for debugging purposes
+	fmt.Println(string(bodyBytes))
+
+	var jwks auth.JSONWebKeySet
+	err = json.Unmarshal(bodyBytes, &jwks)
+	assert.NoError(t, err)
+
+	pair, err := auth.GenerateTokenPair(testUser.Email, testUser.Role, "")
+	assert.NoError(t, err)
+	token := pair.AccessToken
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &auth.Claims{})
+	assert.NoError(t, err)
+
+	var kids []string
+	for _, key := range jwks.Keys {
+		kids = append(kids, key.Kid)
+		assert.NotEqual(t, "oct", key.Kty, "symmetric keys must never be published")
+	}
+
+	assert.Contains(t, kids, parsed.Header["kid"])
+}
+
+func TestTokensSignedBeforeARotationRemainValid(t *testing.T) {
+	previous := auth.Keys()
+	t.Cleanup(func() {
+		auth.SetKeys(previous)
+	})
+
+	oldKey, err := auth.GenerateSigningKey()
+	assert.NoError(t, err)
+
+	km := auth.NewKeyManager(oldKey)
+	auth.SetKeys(km)
+
+	oldPair, err := auth.GenerateTokenPair(testUser.Email, testUser.Role, "")
+	assert.NoError(t, err)
+	oldToken := oldPair.AccessToken
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	newKey, err := auth.NewSigningKey(rsaKey, "")
+	assert.NoError(t, err)
+
+	km.Rotate(newKey)
+	assert.Equal(t, newKey.ID, km.SigningKeyID())
+
+	newPair, err := auth.GenerateTokenPair(testUser.Email, testUser.Role, "")
+	assert.NoError(t, err)
+	newToken := newPair.AccessToken
+
+	parsed, _, err := new(jwt.Parser).ParseUnverified(newToken, &auth.Claims{})
+	assert.NoError(t, err)
+	assert.Equal(t, "RS256", parsed.Method.Alg())
+	assert.Equal(t, newKey.ID, parsed.Header["kid"])
+
+	_, err = auth.ParseToken(oldToken)
+	assert.NoError(t, err)
+
+	_, err = auth.ParseToken(newToken)
+	assert.NoError(t, err)
+
+	assert.Len(t, km.JWKS().Keys, 2)
+
+	// Once the old key is retired its tokens are rejected
+	km.RemoveKey(oldKey.ID)
+
+	_, err = auth.ParseToken(oldToken)
+	assert.Error(t, err)
+
+	assert.Len(t, km.JWKS().Keys, 1)
+}
+
+func TestTokensWithAnUnknownKidAreRejected(t *testing.T) {
+	other, err := auth.GenerateSigningKey()
+	assert.NoError(t, err)
+
+	token, err := auth.NewKeyManager(other).Sign(&auth.Claims{Email: testUser.Email})
+	assert.NoError(t, err)
+
+	_, err = auth.ParseToken(token)
+	assert.Error(t, err)
+}
+
+func TestAnEphemeralSigningKeyIsRefusedOutsideDevAndTest(t *testing.T) {
+	t.Setenv("JWT_SIGNING_KEY_FILE", "")
+
+	t.Setenv("APP_ENV", "production")
+	_, err := auth.LoadKeyManager()
+	assert.Error(t, err)
+
+	t.Setenv("APP_ENV", "dev")
+	_, err = auth.LoadKeyManager()
+	assert.NoError(t, err)
+}
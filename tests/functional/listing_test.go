@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+var linkPattern = regexp.MustCompile(`<([^>]+)>; rel="(\w+)"`)
+
+// pageLinks returns the links of a list response by rel, relative to the app.
+func pageLinks(w *httptest.ResponseRecorder) map[string]string {
+	links := map[string]string{}
+	for _, match := range linkPattern.FindAllStringSubmatch(w.Header().Get("Link"), -1) {
+		links[match[2]] = strings.TrimPrefix(match[1], config.AppURL())
+	}
+	return links
+}
+
+// createListedBooks creates books with the given titles for a new author.
+func createListedBooks(t *testing.T, titles ...string) models.Author {
+	author := models.Author{Firstname: "Listed", Lastname: "Author", CreatedBy: testUser.ID}
+	config.DB.Create(&author)
+
+	for i, title := range titles {
+		book := models.Book{Title: title, Isbn: fmt.Sprintf("LIST-%d-%d", author.ID, i), UserID: testUser.ID, AuthorID: author.ID}
+		config.DB.Create(&book)
+	}
+
+	t.Cleanup(func() {
+		config.DB.Where("author_id = ?", author.ID).Delete(&models.Book{})
+		config.DB.Delete(&author)
+	})
+
+	return author
+}
+
+func bookTitles(t *testing.T, w *httptest.ResponseRecorder) []string {
+	var books []models.Book
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &books))
+
+	titles := make([]string, len(books))
+	for i, book := range books {
+		titles[i] = book.Title
+	}
+	return titles
+}
+
+func TestBooksCanBeWalkedWithACursor(t *testing.T) {
+	author := createListedBooks(t, "Alpha", "Bravo", "Charlie")
+
+	w := sendRequest("GET", fmt.Sprintf("/api/books?author_id=%d&limit=2", author.ID), "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"Alpha", "Bravo"}, bookTitles(t, w))
+
+	links := pageLinks(w)
+	assert.Contains(t, links["next"], "cursor=")
+	assert.NotContains(t, links, "prev")
+
+	w = sendRequest("GET", links["next"], "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"Charlie"}, bookTitles(t, w))
+	assert.NotContains(t, pageLinks(w), "next")
+}
+
+func TestBooksCanBeSorted(t *testing.T) {
+	author := createListedBooks(t, "Bravo", "Charlie", "Alpha")
+
+	w := sendRequest("GET", fmt.Sprintf("/api/books?author_id=%d&sort=-title", author.ID), "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"Charlie", "Bravo", "Alpha"}, bookTitles(t, w))
+
+	// The cursor keeps the order of the page it came from
+	w = sendRequest("GET", fmt.Sprintf("/api/books?author_id=%d&sort=-title&limit=1", author.ID), "", "")
+	w = sendRequest("GET", pageLinks(w)["next"], "", "")
+	assert.Equal(t, []string{"Bravo"}, bookTitles(t, w))
+}
+
+func TestBooksCanBePagedWithAnOffset(t *testing.T) {
+	author := createListedBooks(t, "Alpha", "Bravo", "Charlie")
+
+	w := sendRequest("GET", fmt.Sprintf("/api/books?author_id=%d&limit=1&offset=1", author.ID), "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"Bravo"}, bookTitles(t, w))
+
+	links := pageLinks(w)
+	assert.Contains(t, links["next"], "offset=2")
+	assert.Contains(t, links["prev"], "offset=0")
+	assert.NotContains(t, links["first"], "offset")
+}
+
+func TestCursorMadeForAnotherSortIsRefused(t *testing.T) {
+	author := createListedBooks(t, "Alpha", "Bravo")
+
+	w := sendRequest("GET", fmt.Sprintf("/api/books?author_id=%d&limit=1", author.ID), "", "")
+	next := pageLinks(w)["next"]
+
+	w = sendRequest("GET", next+"&sort=title", "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestListsRefuseUnknownParameters(t *testing.T) {
+	for _, url := range []string{
+		"/api/books?colour=blue",
+		"/api/books?sort=colour",
+		"/api/books?limit=0",
+		"/api/books?limit=1000",
+		"/api/books?author_id=abc",
+		"/api/books?cursor=garbage",
+		"/api/users?password_hash=x",
+		"/api/users/authors?sort=-books",
+		"/api/books/ratings?rating_gte=high",
+	} {
+		w := sendRequest("GET", url, "", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, url)
+
+		var response map[string]string
+		json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NotEmpty(t, response["message"], url)
+	}
+}
+
+func TestRatingsCanBeFilteredByRating(t *testing.T) {
+	author := createListedBooks(t, "Rated")
+	var book models.Book
+	config.DB.Where("author_id = ?", author.ID).First(&book)
+
+	for _, value := range []int{2, 4, 5} {
+		rating := models.Rating{UserID: testUser.ID, BookID: int(book.ID), Rating: value}
+		config.DB.Create(&rating)
+		t.Cleanup(func() {
+			config.DB.Delete(&rating)
+		})
+	}
+
+	w := sendRequest("GET", fmt.Sprintf("/api/books/ratings?book_id=%d&rating_gte=4&sort=-rating", book.ID), "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var ratings []models.Rating
+	json.Unmarshal(w.Body.Bytes(), &ratings)
+	assert.Len(t, ratings, 2)
+	assert.Equal(t, 5, ratings[0].Rating)
+	assert.Equal(t, 4, ratings[1].Rating)
+}
+
+func TestUsersCanBeFilteredByCreationDate(t *testing.T) {
+	since := time.Now().Add(-time.Second).UTC().Format(time.RFC3339)
+	user := createLoginUser("listed-user@example.com")
+	t.Cleanup(func() {
+		config.DB.Delete(&user)
+	})
+
+	w := sendRequest("GET", "/api/users?created_after="+since+"&email="+user.Email, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var users []models.User
+	json.Unmarshal(w.Body.Bytes(), &users)
+	assert.Len(t, users, 1)
+
+	w = sendRequest("GET", "/api/users?created_before="+since+"&email="+user.Email, "", "")
+	json.Unmarshal(w.Body.Bytes(), &users)
+	assert.Empty(t, users)
+}
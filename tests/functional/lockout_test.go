@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func loginFrom(ip string, email string, password string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	jsonData, err := json.Marshal(LoginRequest{Email: email, Password: password})
+	if err != nil {
+		panic(err)
+	}
+
+	req, err := http.NewRequest("POST", "/auth/login", bytes.NewBuffer(jsonData))
+	if err != nil {
+		panic(err)
+	}
+	req.RemoteAddr = ip + ":4321"
+
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRepeatedFailuresIntroduceAProgressiveDelay(t *testing.T) {
+	t.Setenv("LOGIN_DELAY_AFTER", "1")
+	t.Setenv("LOGIN_BASE_DELAY", "30s")
+
+	ip := "203.0.113.10"
+	newUser := createLoginUser("delay@test.com")
+
+	w := loginFrom(ip, newUser.Email, "wrongpassword")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = loginFrom(ip, newUser.Email, "wrongpassword")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Even the right password has to wait now
+	w = loginFrom(ip, newUser.Email, "validpassword")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	assert.NoError(t, err)
+	assert.InDelta(t, 30, retryAfter, 1)
+
+	// Read the response body
+	bodyBytes, err := io.ReadAll(w.Result().Body)
+	assert.NoError(t, err)
+
+	// Print the response body for debugging purposes
+	fmt.Println(string(bodyBytes))
+
+	var errorResponse *handlers.ErrorResponse
+	err = json.Unmarshal(bodyBytes, &errorResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, handlers.TooManyLoginsMessage, errorResponse.Message)
+
+	t.Cleanup(func() {
+		auth.UnlockAccount(newUser.Email)
+		auth.UnlockIP(ip)
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestAccountIsLockedAfterTooManyFailuresUntilAnAdminUnlocksIt(t *testing.T) {
+	t.Setenv("LOGIN_MAX_ACCOUNT_FAILURES", "3")
+	t.Setenv("LOGIN_DELAY_AFTER", "10")
+	t.Setenv("LOGIN_LOCKOUT_DURATION", "10m")
+
+	ip := "203.0.113.11"
+	newUser := createLoginUser("locked@test.com")
+
+	for i := 0; i < 3; i++ {
+		w := loginFrom(ip, newUser.Email, "wrongpassword")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	// Another client is locked out of the account as well
+	w := loginFrom("203.0.113.12", newUser.Email, "validpassword")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	assert.NoError(t, err)
+	assert.InDelta(t, 600, retryAfter, 1)
+
+	actAs(t, models.RoleAdmin)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/users/"+strconv.Itoa(int(newUser.ID))+"/unlock", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = loginFrom(ip, newUser.Email, "validpassword")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	t.Cleanup(func() {
+		auth.UnlockAccount(newUser.Email)
+		auth.UnlockIP(ip)
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestFailuresAreForgottenAfterTheFailureWindow(t *testing.T) {
+	t.Setenv("LOGIN_MAX_ACCOUNT_FAILURES", "3")
+	t.Setenv("LOGIN_DELAY_AFTER", "10")
+	t.Setenv("LOGIN_FAILURE_WINDOW", "1s")
+
+	ip := "203.0.113.16"
+	newUser := createLoginUser("window@test.com")
+
+	for i := 0; i < 2; i++ {
+		w := loginFrom(ip, newUser.Email, "wrongpassword")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	// The window starts with the first failure and is not extended by later ones
+	ttl := config.Client.PTTL(config.Ctx, auth.LoginThrottlePrefix+"failures:account:"+newUser.Email).Val()
+	assert.Greater(t, ttl, time.Duration(0))
+	assert.LessOrEqual(t, ttl, time.Second)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		w := loginFrom(ip, newUser.Email, "wrongpassword")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	w := loginFrom(ip, newUser.Email, "validpassword")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	t.Cleanup(func() {
+		auth.UnlockAccount(newUser.Email)
+		auth.UnlockIP(ip)
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestSuccessfulLoginResetsTheFailedAttempts(t *testing.T) {
+	t.Setenv("LOGIN_DELAY_AFTER", "2")
+
+	ip := "203.0.113.13"
+	newUser := createLoginUser("reset.failures@test.com")
+
+	for i := 0; i < 2; i++ {
+		w := loginFrom(ip, newUser.Email, "wrongpassword")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	w := loginFrom(ip, newUser.Email, "validpassword")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Two more failures are not enough to be delayed again
+	for i := 0; i < 2; i++ {
+		w := loginFrom(ip, newUser.Email, "wrongpassword")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	w = loginFrom(ip, newUser.Email, "validpassword")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	t.Cleanup(func() {
+		auth.UnlockAccount(newUser.Email)
+		auth.UnlockIP(ip)
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestClientIPIsLockedOutAfterFailuresAcrossAccounts(t *testing.T) {
+	t.Setenv("LOGIN_MAX_IP_FAILURES", "3")
+
+	ip := "203.0.113.14"
+	newUser := createLoginUser("ip.victim@test.com")
+
+	for i := 0; i < 3; i++ {
+		w := loginFrom(ip, "sprayed"+strconv.Itoa(i)+"@test.com", "wrongpassword")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	w := loginFrom(ip, newUser.Email, "validpassword")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// Other clients are unaffected
+	w = loginFrom("203.0.113.15", newUser.Email, "validpassword")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	t.Cleanup(func() {
+		for i := 0; i < 3; i++ {
+			auth.UnlockAccount("sprayed" + strconv.Itoa(i) + "@test.com")
+		}
+		auth.UnlockIP(ip)
+		auth.UnlockIP("203.0.113.15")
+		config.DB.Delete(&newUser)
+	})
+}
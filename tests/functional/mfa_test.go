@@ -0,0 +1,245 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+type MFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+func currentTOTPCode(secret string) string {
+	code, err := auth.TOTPCode(secret, auth.TOTPStep(time.Now()))
+	if err != nil {
+		panic(err)
+	}
+	return code
+}
+
+// createMFAUser creates a user with two-factor authentication already enabled.
+func createMFAUser(email string) (models.User, string) {
+	newUser := createLoginUser(email)
+
+	secret := auth.GenerateTOTPSecret()
+	config.DB.Model(&newUser).Updates(map[string]interface{}{"mfa_secret": secret, "mfa_enabled_at": time.Now()})
+
+	return newUser, secret
+}
+
+func mfaChallengeFor(t *testing.T, email string) string {
+	w := postJSON("/auth/login", LoginRequest{Email: email, Password: "validpassword"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var challenge handlers.MFAChallenge
+	err := json.Unmarshal(w.Body.Bytes(), &challenge)
+	assert.NoError(t, err)
+	assert.True(t, challenge.MFARequired)
+
+	return challenge.MFAToken
+}
+
+func TestEnrollAndConfirmMFA(t *testing.T) {
+	t.Cleanup(func() {
+		config.DB.Where("user_id = ?", testUser.ID).Delete(&models.RecoveryCode{})
+		config.DB.Model(&models.User{}).Where("id = ?", testUser.ID).
+			UpdateColumns(map[string]interface{}{"mfa_secret": "", "mfa_enabled_at": nil, "mfa_last_used_step": 0})
+	})
+
+	w := postJSON("/api/me/mfa/enroll", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Read the response body
+	bodyBytes, err := io.ReadAll(w.Result().Body)
+	assert.NoError(t, err)
+
+	// Print the response body for debugging purposes
+	fmt.Println(string(bodyBytes))
+
+	var enrollment handlers.MFAEnrollment
+	err = json.Unmarshal(bodyBytes, &enrollment)
+	assert.NoError(t, err)
+
+	assert.NotEmpty(t, enrollment.Secret)
+	assert.True(t, strings.HasPrefix(enrollment.OtpauthURI, "otpauth://totp/"))
+	assert.Contains(t, enrollment.OtpauthURI, "secret="+enrollment.Secret)
+
+	w = postJSON("/api/me/mfa/confirm", MFACodeRequest{Code: "000000"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = postJSON("/api/me/mfa/confirm", MFACodeRequest{Code: currentTOTPCode(enrollment.Secret)})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var recovery handlers.MFARecoveryCodes
+	err = json.Unmarshal(w.Body.Bytes(), &recovery)
+	assert.NoError(t, err)
+	assert.Len(t, recovery.RecoveryCodes, auth.RecoveryCodeCount)
+
+	var stored []models.RecoveryCode
+	config.DB.Where("user_id = ?", testUser.ID).Find(&stored)
+	assert.Len(t, stored, auth.RecoveryCodeCount)
+	for _, code := range stored {
+		assert.NotContains(t, recovery.RecoveryCodes, code.CodeHash)
+	}
+
+	var reloaded models.User
+	config.DB.First(&reloaded, testUser.ID)
+	assert.True(t, reloaded.IsMFAEnabled())
+
+	// Enrolling again is refused once enabled
+	w = postJSON("/api/me/mfa/enroll", nil)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestLoginWithMFARequiresASecondStep(t *testing.T) {
+	newUser, secret := createMFAUser("mfa.login@test.com")
+
+	challenge := mfaChallengeFor(t, newUser.Email)
+
+	// The challenge is not an access token
+	claims, err := auth.ParseToken(challenge)
+	assert.NoError(t, err)
+	assert.False(t, claims.IsAccessToken())
+
+	code := currentTOTPCode(secret)
+
+	w := postJSON("/auth/login/mfa", MFALoginRequest{MFAToken: challenge, Code: code})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var loginToken handlers.LoginToken
+	err = json.Unmarshal(w.Body.Bytes(), &loginToken)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, loginToken.Token)
+	assert.NotEmpty(t, loginToken.RefreshToken)
+
+	// The challenge cannot be exchanged twice
+	w = postJSON("/auth/login/mfa", MFALoginRequest{MFAToken: challenge, Code: code})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Nor can the same code be replayed with a new challenge
+	w = postJSON("/auth/login/mfa", MFALoginRequest{MFAToken: mfaChallengeFor(t, newUser.Email), Code: code})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestRecoveryCodesCanOnlyBeUsedOnce(t *testing.T) {
+	newUser, _ := createMFAUser("mfa.recovery@test.com")
+
+	code := auth.GenerateRecoveryCodes(1)[0]
+	config.DB.Create(&models.RecoveryCode{UserID: newUser.ID, CodeHash: auth.HashRecoveryCode(code)})
+
+	w := postJSON("/auth/login/mfa", MFALoginRequest{MFAToken: mfaChallengeFor(t, newUser.Email), Code: strings.ToUpper(code)})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = postJSON("/auth/login/mfa", MFALoginRequest{MFAToken: mfaChallengeFor(t, newUser.Email), Code: code})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	t.Cleanup(func() {
+		config.DB.Where("user_id = ?", newUser.ID).Delete(&models.RecoveryCode{})
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestTooManyWrongCodesInvalidateTheChallenge(t *testing.T) {
+	// Well before the wrong codes lock the account out
+	t.Setenv("LOGIN_MAX_ACCOUNT_FAILURES", "10")
+	t.Setenv("LOGIN_DELAY_AFTER", "10")
+
+	newUser, secret := createMFAUser("mfa.bruteforce@test.com")
+
+	challenge := mfaChallengeFor(t, newUser.Email)
+
+	for i := 0; i < auth.MaxMFAAttempts; i++ {
+		w := postJSON("/auth/login/mfa", MFALoginRequest{MFAToken: challenge, Code: "000000"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	w := postJSON("/auth/login/mfa", MFALoginRequest{MFAToken: challenge, Code: currentTOTPCode(secret)})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var errorResponse *handlers.ErrorResponse
+	err := json.Unmarshal(w.Body.Bytes(), &errorResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, handlers.InvalidMFAChallengeMessage, errorResponse.Message)
+
+	t.Cleanup(func() {
+		auth.UnlockAccount(newUser.Email)
+		auth.UnlockIP("")
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestWrongCodesCountTowardsTheAccountLockout(t *testing.T) {
+	t.Setenv("LOGIN_MAX_ACCOUNT_FAILURES", "3")
+	t.Setenv("LOGIN_DELAY_AFTER", "10")
+
+	newUser, secret := createMFAUser("mfa.lockout@test.com")
+
+	t.Cleanup(func() {
+		auth.UnlockAccount(newUser.Email)
+		auth.UnlockIP("")
+		config.DB.Delete(&newUser)
+	})
+
+	challenge := mfaChallengeFor(t, newUser.Email)
+	for i := 0; i < 3; i++ {
+		w := postJSON("/auth/login/mfa", MFALoginRequest{MFAToken: challenge, Code: "000000"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	}
+
+	// The right password no longer earns a new challenge to guess with
+	w := postJSON("/auth/login", LoginRequest{Email: newUser.Email, Password: "validpassword"})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// Nor does the challenge already issued take the right code
+	w = postJSON("/auth/login/mfa", MFALoginRequest{MFAToken: challenge, Code: currentTOTPCode(secret)})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
+
+func TestAdminCanResetMFA(t *testing.T) {
+	newUser, _ := createMFAUser("mfa.reset@test.com")
+
+	relativeURL := "/api/users/" + strconv.Itoa(int(newUser.ID)) + "/mfa"
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", relativeURL, nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	actAs(t, models.RoleAdmin)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", relativeURL, nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// A plain password login works again
+	loginToken := loginAs(t, newUser.Email, "validpassword")
+	assert.NotEmpty(t, loginToken.Token)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&newUser)
+	})
+}
@@ -0,0 +1,369 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOIDCProvider is a minimal OpenID Connect provider that signs in whoever
+// is set as its next user without asking for credentials.
+type fakeOIDCProvider struct {
+	*httptest.Server
+
+	key      *rsa.PrivateKey
+	clientID string
+
+	mu       sync.Mutex
+	nextUser jwt.MapClaims
+	codes    map[string]fakeAuthorization
+}
+
+type fakeAuthorization struct {
+	claims      jwt.MapClaims
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+func newFakeOIDCProvider(t *testing.T, name string) (*fakeOIDCProvider, *auth.OIDCProvider) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	fake := &fakeOIDCProvider{key: key, clientID: "books-store", codes: map[string]fakeAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 fake.URL,
+			"authorization_endpoint": fake.URL + "/authorize",
+			"token_endpoint":         fake.URL + "/token",
+			"jwks_uri":               fake.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		signing, _ := auth.NewSigningKey(fake.key, "fake-key")
+		json.NewEncoder(w).Encode(auth.NewKeyManager(signing).JWKS())
+	})
+	mux.HandleFunc("/authorize", fake.authorize)
+	mux.HandleFunc("/token", fake.token)
+
+	fake.Server = httptest.NewServer(mux)
+	t.Cleanup(fake.Close)
+
+	provider := &auth.OIDCProvider{
+		Name:         name,
+		Issuer:       fake.URL,
+		ClientID:     fake.clientID,
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/auth/oidc/" + name + "/callback",
+	}
+	auth.SetOIDCProvider(provider)
+
+	return fake, provider
+}
+
+func (f *fakeOIDCProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != f.clientID || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	code := auth.NewSecret()
+
+	f.mu.Lock()
+	f.codes[code] = fakeAuthorization{
+		claims:      f.nextUser,
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+		redirectURI: query.Get("redirect_uri"),
+	}
+	f.mu.Unlock()
+
+	callback, _ := url.Parse(query.Get("redirect_uri"))
+	callback.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, callback.String(), http.StatusFound)
+}
+
+func (f *fakeOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	f.mu.Lock()
+	authorization, ok := f.codes[r.PostForm.Get("code")]
+	delete(f.codes, r.PostForm.Get("code"))
+	f.mu.Unlock()
+
+	if !ok ||
+		r.PostForm.Get("client_id") != f.clientID ||
+		r.PostForm.Get("redirect_uri") != authorization.redirectURI ||
+		auth.PKCEChallenge(r.PostForm.Get("code_verifier")) != authorization.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   f.URL,
+		"aud":   f.clientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": authorization.nonce,
+	}
+	for name, value := range authorization.claims {
+		claims[name] = value
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"id_token": f.sign(claims), "token_type": "Bearer"})
+}
+
+func (f *fakeOIDCProvider) sign(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "fake-key"
+	signed, err := token.SignedString(f.key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+// signInWith walks through the redirects of a sign in as a browser would and returns our callback's response.
+func signInWith(t *testing.T, provider string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/oidc/"+provider, nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	browser := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := browser.Get(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	assert.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", callback.RequestURI(), nil)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func cleanupIdentityUser(t *testing.T, email string) {
+	t.Cleanup(func() {
+		var user models.User
+		if config.DB.Where("email = ?", email).First(&user).Error == nil {
+			config.DB.Where("user_id = ?", user.ID).Delete(&models.Identity{})
+			config.DB.Where("user_id = ?", user.ID).Delete(&models.Session{})
+			config.DB.Delete(&user)
+		}
+	})
+}
+
+func TestOIDCLoginCreatesAUserAndIssuesTokens(t *testing.T) {
+	fake, _ := newFakeOIDCProvider(t, "fake")
+	fake.nextUser = jwt.MapClaims{
+		"sub":            "new-subject",
+		"email":          "oidc-new@test.com",
+		"email_verified": true,
+		"given_name":     "Ada",
+		"family_name":    "Lovelace",
+	}
+	cleanupIdentityUser(t, "oidc-new@test.com")
+
+	w := signInWith(t, "fake")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var loginToken handlers.LoginToken
+	err := json.Unmarshal(w.Body.Bytes(), &loginToken)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, loginToken.Token)
+
+	claims, err := auth.ParseToken(loginToken.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, "oidc-new@test.com", claims.Email)
+
+	var user models.User
+	config.DB.Where("email = ?", "oidc-new@test.com").First(&user)
+	assert.Equal(t, "Ada", user.Firstname)
+	assert.Equal(t, models.RoleReader, user.Role)
+	assert.True(t, user.IsEmailVerified())
+
+	var identity models.Identity
+	err = config.DB.Where("provider = ? AND subject = ?", "fake", "new-subject").First(&identity).Error
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, identity.UserID)
+}
+
+func TestOIDCLoginLinksAnExistingUserByVerifiedEmail(t *testing.T) {
+	existing := createLoginUser("oidc-existing@test.com")
+	cleanupIdentityUser(t, existing.Email)
+
+	fake, _ := newFakeOIDCProvider(t, "fake")
+	fake.nextUser = jwt.MapClaims{"sub": "existing-subject", "email": existing.Email, "email_verified": true}
+
+	w := signInWith(t, "fake")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var identity models.Identity
+	err := config.DB.Where("provider = ? AND subject = ?", "fake", "existing-subject").First(&identity).Error
+	assert.NoError(t, err)
+	assert.Equal(t, existing.ID, identity.UserID)
+
+	// Signing in again finds the user through the identity, even if the provider's email changed
+	fake.nextUser = jwt.MapClaims{"sub": "existing-subject", "email": "renamed@test.com", "email_verified": false}
+
+	w = signInWith(t, "fake")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var loginToken handlers.LoginToken
+	json.Unmarshal(w.Body.Bytes(), &loginToken)
+	claims, err := auth.ParseToken(loginToken.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, existing.Email, claims.Email)
+}
+
+func TestOIDCLoginTakesBackAnAccountRegisteredAheadOfItsOwner(t *testing.T) {
+	// Someone else registered the address with a password of their choosing and never verified it
+	existing := createLoginUser("oidc-squatted@test.com")
+	cleanupIdentityUser(t, existing.Email)
+	squatter := loginAs(t, existing.Email, "validpassword")
+
+	fake, _ := newFakeOIDCProvider(t, "fake")
+	fake.nextUser = jwt.MapClaims{"sub": "owner-subject", "email": existing.Email, "email_verified": true}
+
+	w := signInWith(t, "fake")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var user models.User
+	config.DB.First(&user, existing.ID)
+	assert.True(t, user.IsEmailVerified())
+	assert.False(t, user.CheckPassword("validpassword"))
+
+	w = loginFrom("203.0.113.17", existing.Email, "validpassword")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	claims, err := auth.ParseToken(squatter.RefreshToken)
+	assert.NoError(t, err)
+	var active int64
+	config.DB.Model(&models.Session{}).Where("id = ? AND revoked_at IS NULL", claims.Family).Count(&active)
+	assert.Zero(t, active)
+
+	t.Cleanup(func() {
+		auth.UnlockAccount(existing.Email)
+		auth.UnlockIP("203.0.113.17")
+	})
+}
+
+func TestOIDCLoginRefusesUnverifiedEmails(t *testing.T) {
+	existing := createLoginUser("oidc-unverified@test.com")
+	cleanupIdentityUser(t, existing.Email)
+
+	fake, _ := newFakeOIDCProvider(t, "fake")
+	fake.nextUser = jwt.MapClaims{"sub": "attacker", "email": existing.Email, "email_verified": false}
+
+	w := signInWith(t, "fake")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var count int64
+	config.DB.Model(&models.Identity{}).Where("user_id = ?", existing.ID).Count(&count)
+	assert.Zero(t, count)
+}
+
+func TestOIDCCallbackRejectsUnknownOrReusedState(t *testing.T) {
+	fake, _ := newFakeOIDCProvider(t, "fake")
+	fake.nextUser = jwt.MapClaims{"sub": "state-subject", "email": "oidc-state@test.com", "email_verified": true}
+	cleanupIdentityUser(t, "oidc-state@test.com")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/oidc/fake/callback?code=abc&state=forged", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/auth/oidc/fake", nil)
+	router.ServeHTTP(w, req)
+
+	browser := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := browser.Get(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", callback.RequestURI(), nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Replaying the callback must not log in again
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", callback.RequestURI(), nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestOIDCVerifyIDTokenChecksNonceAudienceAndIssuer(t *testing.T) {
+	fake, provider := newFakeOIDCProvider(t, "fake")
+
+	valid := jwt.MapClaims{
+		"iss":   fake.URL,
+		"aud":   fake.clientID,
+		"sub":   "subject",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": "expected",
+	}
+
+	_, err := provider.VerifyIDToken(config.Ctx, fake.sign(valid), "expected")
+	assert.NoError(t, err)
+
+	_, err = provider.VerifyIDToken(config.Ctx, fake.sign(valid), "other")
+	assert.ErrorIs(t, err, auth.ErrInvalidIDToken)
+
+	for name, value := range map[string]interface{}{
+		"aud": "someone-else",
+		"iss": "https://evil.example.com",
+		"exp": time.Now().Add(-time.Minute).Unix(),
+	} {
+		claims := jwt.MapClaims{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[name] = value
+
+		_, err = provider.VerifyIDToken(config.Ctx, fake.sign(claims), "expected")
+		assert.ErrorIs(t, err, auth.ErrInvalidIDToken, name)
+	}
+
+	// A token signed by anyone else is refused
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, valid)
+	forged.Header["kid"] = "fake-key"
+	signed, _ := forged.SignedString(other)
+
+	_, err = provider.VerifyIDToken(config.Ctx, signed, "expected")
+	assert.ErrorIs(t, err, auth.ErrInvalidIDToken)
+}
+
+func TestOIDCLoginWithUnknownProviderIsNotFound(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/oidc/nope", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,230 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/events"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/fokosun/go-rest-api/webhooks"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// recordingSink remembers the events it is sent, fails those listed in failures once and
+// those listed in down every time.
+type recordingSink struct {
+	published []uint
+	failures  map[uint]bool
+	down      map[uint]bool
+}
+
+func (s *recordingSink) Name() string {
+	return "recording"
+}
+
+func (s *recordingSink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	if event.AggregateType != "test" {
+		return nil
+	}
+	if s.failures[event.ID] {
+		delete(s.failures, event.ID)
+		return errors.New("sink is down")
+	}
+	if s.down[event.ID] {
+		return errors.New("sink is down")
+	}
+	s.published = append(s.published, event.ID)
+	return nil
+}
+
+// pauseRelay stops the background relay so that a test can run the relay itself.
+func pauseRelay(t *testing.T) {
+	relay.Stop()
+	t.Cleanup(relay.Start)
+}
+
+func recordTestEvent(t *testing.T, aggregateID uint) models.OutboxEvent {
+	err := events.Record(config.DB, "test", aggregateID, "test.happened", map[string]uint{"id": aggregateID})
+	assert.NoError(t, err)
+
+	var event models.OutboxEvent
+	config.DB.Where("aggregate_type = ?", "test").Order("id desc").First(&event)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&event)
+	})
+
+	return event
+}
+
+func TestCreatingAnAuthorPublishesAnEvent(t *testing.T) {
+	received := make(chan models.OutboxEvent, 10)
+	events.DefaultBus.Subscribe(webhooks.EventAuthorCreated, func(ctx context.Context, event models.OutboxEvent) error {
+		received <- event
+		return nil
+	})
+
+	w := postJSON("/api/users/authors", CreateAuthorRequest{Firstname: "Outbox", Lastname: "Author"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var author models.Author
+	json.Unmarshal(w.Body.Bytes(), &author)
+	t.Cleanup(func() {
+		config.DB.Delete(&author)
+	})
+
+	for {
+		select {
+		case event := <-received:
+			if event.AggregateID != author.ID {
+				continue
+			}
+
+			assert.Equal(t, events.AggregateAuthor, event.AggregateType)
+
+			var published models.Author
+			err := json.Unmarshal([]byte(event.Payload), &published)
+			assert.NoError(t, err)
+			assert.Equal(t, "Outbox", published.Firstname)
+			return
+		case <-time.After(5 * time.Second):
+			t.Fatal("the event was not published")
+		}
+	}
+}
+
+func TestEventsOfARolledBackChangeAreNeverPublished(t *testing.T) {
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := events.Record(tx, "test", 424242, "test.happened", map[string]uint{"id": 424242}); err != nil {
+			return err
+		}
+		return errors.New("the change failed")
+	})
+	assert.Error(t, err)
+
+	var count int64
+	config.DB.Model(&models.OutboxEvent{}).Where("aggregate_type = ? AND aggregate_id = ?", "test", 424242).Count(&count)
+	assert.Zero(t, count)
+}
+
+func TestRelayKeepsEventsOfAnAggregateInOrder(t *testing.T) {
+	pauseRelay(t)
+
+	first := recordTestEvent(t, 1)
+	second := recordTestEvent(t, 1)
+	other := recordTestEvent(t, 2)
+
+	sink := &recordingSink{failures: map[uint]bool{first.ID: true}}
+	testRelay := events.NewRelay(sink)
+	testRelay.RetryBackoff = 0
+
+	// The first event fails, so the second has to wait while the other aggregate goes ahead
+	_, err := testRelay.RelayOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{other.ID}, sink.published)
+
+	var failed models.OutboxEvent
+	config.DB.First(&failed, first.ID)
+	assert.Nil(t, failed.PublishedAt)
+	assert.Equal(t, 1, failed.Attempts)
+	assert.Contains(t, failed.LastError, "sink is down")
+
+	_, err = testRelay.RelayOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{other.ID, first.ID, second.ID}, sink.published)
+
+	// Published events are not sent again
+	_, err = testRelay.RelayOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, sink.published, 3)
+}
+
+func TestRelayIsNotStalledByAnAggregateWaitingForARetry(t *testing.T) {
+	pauseRelay(t)
+
+	first := recordTestEvent(t, 1)
+	second := recordTestEvent(t, 1)
+	other := recordTestEvent(t, 2)
+
+	sink := &recordingSink{down: map[uint]bool{first.ID: true}}
+	testRelay := events.NewRelay(sink)
+	testRelay.BatchSize = 1
+	testRelay.RetryBackoff = time.Hour
+
+	// Whatever else is waiting in the outbox goes first, one event at a time
+	for i := 0; i < 20 && !assert.ObjectsAreEqual([]uint{other.ID}, sink.published); i++ {
+		_, err := testRelay.RelayOnce(context.Background())
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []uint{other.ID}, sink.published)
+
+	var waiting models.OutboxEvent
+	config.DB.First(&waiting, first.ID)
+	assert.Equal(t, 1, waiting.Attempts)
+	assert.NotNil(t, waiting.NextAttemptAt)
+	assert.True(t, waiting.NextAttemptAt.After(time.Now().Add(59*time.Minute)))
+
+	config.DB.First(&waiting, second.ID)
+	assert.Nil(t, waiting.PublishedAt)
+	assert.Zero(t, waiting.Attempts)
+}
+
+func TestRelayGivesUpOnAnEventAfterMaxAttempts(t *testing.T) {
+	pauseRelay(t)
+
+	poison := recordTestEvent(t, 1)
+	next := recordTestEvent(t, 1)
+
+	sink := &recordingSink{down: map[uint]bool{poison.ID: true}}
+	testRelay := events.NewRelay(sink)
+	testRelay.RetryBackoff = 0
+	testRelay.MaxAttempts = 3
+
+	for i := 0; i < 3; i++ {
+		_, err := testRelay.RelayOnce(context.Background())
+		assert.NoError(t, err)
+	}
+
+	var dead models.OutboxEvent
+	config.DB.First(&dead, poison.ID)
+	assert.Equal(t, 3, dead.Attempts)
+	assert.NotNil(t, dead.DeadAt)
+	assert.Nil(t, dead.PublishedAt)
+
+	// The aggregate's later events go ahead without it
+	assert.Equal(t, []uint{next.ID}, sink.published)
+
+	_, err := testRelay.RelayOnce(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{next.ID}, sink.published)
+}
+
+func TestRedisStreamSinkAppendsEvents(t *testing.T) {
+	stream := "test:outbox:events"
+	t.Cleanup(func() {
+		config.Client.Del(config.Ctx, stream)
+	})
+
+	sink := events.RedisStreamSink{Stream: stream, MaxLen: 100}
+	err := sink.Publish(context.Background(), models.OutboxEvent{
+		ID:            7,
+		AggregateType: events.AggregateBook,
+		AggregateID:   3,
+		EventType:     webhooks.EventBookCreated,
+		Payload:       `{"id":3}`,
+		CreatedAt:     time.Now(),
+	})
+	assert.NoError(t, err)
+
+	entries, err := config.Client.XRange(config.Ctx, stream, "-", "+").Result()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, webhooks.EventBookCreated, entries[0].Values["type"])
+	assert.Equal(t, `{"id":3}`, entries[0].Values["payload"])
+}
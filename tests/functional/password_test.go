@@ -0,0 +1,257 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/mail"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+var resetTokenPattern = regexp.MustCompile(`token=([0-9a-f]+)`)
+
+func postJSON(url string, body interface{}) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		panic(err)
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		panic(err)
+	}
+
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// requestPasswordReset asks for a reset link and returns the token emailed to the user.
+func requestPasswordReset(t *testing.T, email string) string {
+	w := postJSON("/auth/password/forgot", ForgotPasswordRequest{Email: email})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	msg := waitForMail(t, email)
+	match := resetTokenPattern.FindStringSubmatch(msg.Body)
+	assert.Len(t, match, 2)
+
+	return match[1]
+}
+
+// waitForMail waits for the job queue to send a message to the address.
+func waitForMail(t *testing.T, email string) mail.Message {
+	var msg mail.Message
+	assert.Eventually(t, func() bool {
+		var ok bool
+		msg, ok = testMailer.LastTo(email)
+		return ok
+	}, 5*time.Second, 10*time.Millisecond, "no message was sent to %s", email)
+	return msg
+}
+
+func forgotPasswordFrom(ip string, email string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	jsonData, _ := json.Marshal(ForgotPasswordRequest{Email: email})
+	req, _ := http.NewRequest("POST", "/auth/password/forgot", bytes.NewBuffer(jsonData))
+	req.RemoteAddr = ip + ":4321"
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestForgotPasswordDoesNotRevealUnknownEmails(t *testing.T) {
+	known := createLoginUser("forgot.known@test.com")
+	t.Cleanup(func() {
+		config.DB.Where("user_id = ?", known.ID).Delete(&models.PasswordResetToken{})
+		config.DB.Delete(&known)
+	})
+
+	unknown := postJSON("/auth/password/forgot", ForgotPasswordRequest{Email: "nobody@test.com"})
+	w := postJSON("/auth/password/forgot", ForgotPasswordRequest{Email: known.Email})
+
+	assert.Equal(t, http.StatusOK, unknown.Code)
+	assert.Equal(t, w.Code, unknown.Code)
+	assert.Equal(t, w.Body.String(), unknown.Body.String())
+
+	waitForMail(t, known.Email)
+	_, sent := testMailer.LastTo("nobody@test.com")
+	assert.False(t, sent)
+}
+
+func TestForgotPasswordSendsAFewLinksToAnAddressAtMost(t *testing.T) {
+	t.Setenv("PASSWORD_RESET_MAX_PER_EMAIL", "1")
+
+	newUser := createLoginUser("forgot.flood@test.com")
+	t.Cleanup(func() {
+		config.DB.Where("user_id = ?", newUser.ID).Delete(&models.PasswordResetToken{})
+		config.DB.Delete(&newUser)
+		config.Client.Del(config.Ctx, auth.PasswordResetThrottlePrefix+"email:"+newUser.Email)
+	})
+
+	for i := 0; i < 3; i++ {
+		w := postJSON("/auth/password/forgot", ForgotPasswordRequest{Email: newUser.Email})
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	waitForMail(t, newUser.Email)
+
+	sent := 0
+	for _, msg := range testMailer.Sent() {
+		if msg.To == newUser.Email {
+			sent++
+		}
+	}
+	assert.Equal(t, 1, sent)
+}
+
+func TestForgotPasswordThrottlesAClientIP(t *testing.T) {
+	t.Setenv("PASSWORD_RESET_MAX_PER_IP", "2")
+
+	ip := "203.0.113.18"
+	t.Cleanup(func() {
+		config.Client.Del(config.Ctx, auth.PasswordResetThrottlePrefix+"ip:"+ip)
+	})
+
+	for i := 0; i < 2; i++ {
+		w := forgotPasswordFrom(ip, fmt.Sprintf("nobody%d@test.com", i))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	w := forgotPasswordFrom(ip, "nobody2@test.com")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
+
+func TestForgotPasswordStoresOnlyAHashOfTheToken(t *testing.T) {
+	newUser := createLoginUser("forgot@test.com")
+
+	token := requestPasswordReset(t, newUser.Email)
+
+	var stored models.PasswordResetToken
+	err := config.DB.Where("user_id = ?", newUser.ID).First(&stored).Error
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, token, stored.TokenHash)
+	assert.Equal(t, auth.HashToken(token), stored.TokenHash)
+
+	t.Cleanup(func() {
+		config.DB.Where("user_id = ?", newUser.ID).Delete(&models.PasswordResetToken{})
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestResetPasswordChangesThePasswordAndIsSingleUse(t *testing.T) {
+	newUser := createLoginUser("reset@test.com")
+
+	token := requestPasswordReset(t, newUser.Email)
+
+	w := postJSON("/auth/password/reset", ResetPasswordRequest{Token: token, Password: "myNewPassword"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Read the response body
+	bodyBytes, err := io.ReadAll(w.Result().Body)
+	assert.NoError(t, err)
+
+	// Print the response body for debugging purposes
+	fmt.Println(string(bodyBytes))
+
+	var reloaded models.User
+	config.DB.First(&reloaded, newUser.ID)
+	assert.True(t, reloaded.CheckPassword("myNewPassword"))
+	assert.False(t, reloaded.CheckPassword("validpassword"))
+
+	// The same link cannot be used twice
+	w = postJSON("/auth/password/reset", ResetPasswordRequest{Token: token, Password: "anotherPassword"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var errorResponse *handlers.ErrorResponse
+	err = json.Unmarshal(w.Body.Bytes(), &errorResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, handlers.InvalidResetTokenMessage, errorResponse.Message)
+
+	t.Cleanup(func() {
+		config.DB.Where("user_id = ?", newUser.ID).Delete(&models.PasswordResetToken{})
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestResetPasswordRejectsAnExpiredToken(t *testing.T) {
+	newUser := createLoginUser("expired.reset@test.com")
+
+	token := requestPasswordReset(t, newUser.Email)
+
+	config.DB.Model(&models.PasswordResetToken{}).
+		Where("user_id = ?", newUser.ID).
+		Update("expires_at", time.Now().Add(-time.Minute))
+
+	w := postJSON("/auth/password/reset", ResetPasswordRequest{Token: token, Password: "myNewPassword"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	t.Cleanup(func() {
+		config.DB.Where("user_id = ?", newUser.ID).Delete(&models.PasswordResetToken{})
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestResetPasswordValidatesTheNewPassword(t *testing.T) {
+	newUser := createLoginUser("short.reset@test.com")
+
+	token := requestPasswordReset(t, newUser.Email)
+
+	w := postJSON("/auth/password/reset", ResetPasswordRequest{Token: token, Password: "short"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// A failed attempt does not burn the token
+	w = postJSON("/auth/password/reset", ResetPasswordRequest{Token: token, Password: "longEnoughNow"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	t.Cleanup(func() {
+		config.DB.Where("user_id = ?", newUser.ID).Delete(&models.PasswordResetToken{})
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestResetPasswordSignsOutExistingSessions(t *testing.T) {
+	newUser := createLoginUser("reset.sessions@test.com")
+
+	loginToken := loginAs(t, newUser.Email, "validpassword")
+
+	token := requestPasswordReset(t, newUser.Email)
+
+	w := postJSON("/auth/password/reset", ResetPasswordRequest{Token: token, Password: "myNewPassword"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	claims, err := auth.ParseToken(loginToken.Token)
+	assert.NoError(t, err)
+
+	revoked, err := auth.IsRevoked(claims)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	w = refreshWith(loginToken.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	t.Cleanup(func() {
+		config.DB.Where("user_id = ?", newUser.ID).Delete(&models.PasswordResetToken{})
+		config.DB.Delete(&newUser)
+	})
+}
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func ratingsURL(bookID uint) string {
+	return "/api/books/" + strconv.Itoa(int(bookID)) + "/ratings"
+}
+
+func TestRatingsBelongToTheUserWhoRates(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+	forgetRatings(t, book.ID)
+
+	other := createLoginUser("rating.owner@test.com")
+	t.Cleanup(func() {
+		config.DB.Unscoped().Delete(&other)
+	})
+
+	rate(t, book.ID, 4, loginAs(t, other.Email, "validpassword").Token)
+	rate(t, book.ID, 2, "")
+
+	var ratings []models.Rating
+	config.DB.Where("book_id = ?", book.ID).Find(&ratings)
+	if assert.Len(t, ratings, 2) {
+		assert.ElementsMatch(t, []uint{testUser.ID, other.ID}, []uint{ratings[0].UserID, ratings[1].UserID})
+	}
+}
+
+func TestRatingABookAgainReplacesTheRating(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+	forgetRatings(t, book.ID)
+
+	w := sendRequest("POST", ratingsURL(book.ID), `{"rating": 4, "comment": "Good"}`, "")
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	w = sendRequest("POST", ratingsURL(book.ID), `{"rating": 2, "comment": "Less good on second reading"}`, "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var rating models.Rating
+	config.DB.Where("book_id = ? AND user_id = ?", book.ID, testUser.ID).First(&rating)
+
+	// Sending the same rating again changes nothing
+	w = sendRequest("POST", ratingsURL(book.ID), `{"rating": 2, "comment": "Less good on second reading"}`, "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var ratings []models.Rating
+	config.DB.Where("book_id = ?", book.ID).Find(&ratings)
+	if assert.Len(t, ratings, 1) {
+		assert.Equal(t, 2, ratings[0].Rating)
+		assert.True(t, rating.UpdatedAt.Equal(ratings[0].UpdatedAt))
+	}
+	assert.Equal(t, int64(1), ratingSummaryOf(t, book.ID).Count)
+}
+
+func TestRatingsMustBeBetweenOneAndFive(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+	forgetRatings(t, book.ID)
+
+	for _, body := range []string{`{"rating": 0}`, `{"rating": 6}`, `{"comment": "No rating"}`} {
+		w := sendRequest("POST", ratingsURL(book.ID), body, "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	// The database refuses them too
+	err := config.DB.Create(&models.Rating{UserID: testUser.ID, BookID: int(book.ID), Rating: 9}).Error
+	assert.Error(t, err)
+}
+
+func TestUsersCanDeleteTheirOwnRating(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+	forgetRatings(t, book.ID)
+
+	other := createLoginUser("rating.deleter@test.com")
+	t.Cleanup(func() {
+		config.DB.Unscoped().Delete(&other)
+	})
+	token := loginAs(t, other.Email, "validpassword").Token
+
+	rate(t, book.ID, 5, "")
+	rate(t, book.ID, 3, token)
+
+	w := sendRequest("DELETE", ratingsURL(book.ID), "", token)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// Only their own rating is gone
+	var ratings []models.Rating
+	config.DB.Where("book_id = ?", book.ID).Find(&ratings)
+	if assert.Len(t, ratings, 1) {
+		assert.Equal(t, testUser.ID, ratings[0].UserID)
+	}
+	assert.Equal(t, int64(1), ratingSummaryOf(t, book.ID).Count)
+
+	w = sendRequest("DELETE", ratingsURL(book.ID), "", token)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// A deleted rating does not stop the user from rating the book again
+	rate(t, book.ID, 4, token)
+	assert.Equal(t, int64(2), ratingSummaryOf(t, book.ID).Count)
+}
@@ -0,0 +1,159 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+type ratingSummary struct {
+	Count     int64            `json:"count"`
+	Mean      float64          `json:"mean"`
+	Score     float64          `json:"score"`
+	Histogram map[string]int64 `json:"histogram"`
+}
+
+// rate rates a book as the user the token belongs to, or as the test user without one.
+func rate(t *testing.T, bookID uint, rating int, token string) {
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", "/api/books/"+strconv.Itoa(int(bookID))+"/ratings", bytes.NewBufferString(fmt.Sprintf(`{"rating": %d}`, rating)))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	router.ServeHTTP(w, req)
+	assert.Contains(t, []int{http.StatusOK, http.StatusCreated}, w.Code, w.Body.String())
+}
+
+func forgetRatings(t *testing.T, bookID uint) {
+	t.Cleanup(func() {
+		config.DB.Unscoped().Where("book_id = ?", bookID).Delete(&models.Rating{})
+		config.DB.Where("book_id = ?", bookID).Delete(&models.BookRatingStats{})
+	})
+}
+
+func ratingSummaryOf(t *testing.T, bookID uint) ratingSummary {
+	w := sendRequest("GET", "/api/books/"+strconv.Itoa(int(bookID))+"/ratings/summary", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var summary ratingSummary
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &summary))
+	return summary
+}
+
+func TestUnratedBooksScoreThePrior(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+
+	summary := ratingSummaryOf(t, book.ID)
+	mean, _ := config.RatingPrior()
+	assert.Equal(t, int64(0), summary.Count)
+	assert.Equal(t, mean, summary.Score)
+	assert.Equal(t, map[string]int64{"1": 0, "2": 0, "3": 0, "4": 0, "5": 0}, summary.Histogram)
+}
+
+func TestRatingSummaryFollowsTheRatings(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+	forgetRatings(t, book.ID)
+
+	other := createLoginUser("rating.stats@test.com")
+	t.Cleanup(func() {
+		config.DB.Unscoped().Delete(&other)
+	})
+	token := loginAs(t, other.Email, "validpassword").Token
+
+	rate(t, book.ID, 5, "")
+	rate(t, book.ID, 4, token)
+
+	mean, weight := config.RatingPrior()
+	summary := ratingSummaryOf(t, book.ID)
+	assert.Equal(t, int64(2), summary.Count)
+	assert.Equal(t, 4.5, summary.Mean)
+	assert.InDelta(t, (weight*mean+9)/(weight+2), summary.Score, 1e-9)
+	assert.Equal(t, map[string]int64{"1": 0, "2": 0, "3": 0, "4": 1, "5": 1}, summary.Histogram)
+
+	// Changing a rating moves it to another bar
+	rate(t, book.ID, 3, "")
+
+	summary = ratingSummaryOf(t, book.ID)
+	assert.Equal(t, int64(2), summary.Count)
+	assert.Equal(t, 3.5, summary.Mean)
+	assert.Equal(t, map[string]int64{"1": 0, "2": 0, "3": 1, "4": 1, "5": 0}, summary.Histogram)
+
+	// The book itself is sent with the same summary
+	w := sendRequest("GET", "/api/books/"+strconv.Itoa(int(book.ID)), "", "")
+	var found struct {
+		Ratings ratingSummary `json:"ratings"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &found)
+	assert.Equal(t, summary, found.Ratings)
+}
+
+func TestBooksCanBeSortedByRating(t *testing.T) {
+	author := createListedBooks(t, "Liked", "Loved", "Disliked")
+
+	var books []models.Book
+	config.DB.Where("author_id = ?", author.ID).Find(&books)
+	ratings := map[string]int{"Liked": 4, "Loved": 5, "Disliked": 1}
+	for _, book := range books {
+		forgetRatings(t, book.ID)
+		rate(t, book.ID, ratings[book.Title], "")
+	}
+
+	w := sendRequest("GET", fmt.Sprintf("/api/books?author_id=%d&sort=-rating", author.ID), "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{"Loved", "Liked", "Disliked"}, bookTitles(t, w))
+
+	// The cursor carries the score to the next page
+	w = sendRequest("GET", fmt.Sprintf("/api/books?author_id=%d&sort=-rating&limit=2", author.ID), "", "")
+	assert.Equal(t, []string{"Loved", "Liked"}, bookTitles(t, w))
+
+	w = sendRequest("GET", pageLinks(w)["next"], "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{"Disliked"}, bookTitles(t, w))
+}
+
+func TestCursorsPageOverBooksNobodyHasRated(t *testing.T) {
+	author := createListedBooks(t, "Loved", "Unrated", "Disliked")
+
+	var books []models.Book
+	config.DB.Where("author_id = ?", author.ID).Find(&books)
+	ratings := map[string]int{"Loved": 5, "Disliked": 1}
+	for _, book := range books {
+		forgetRatings(t, book.ID)
+		if rating, ok := ratings[book.Title]; ok {
+			rate(t, book.ID, rating, "")
+		}
+	}
+
+	// The unrated book has no stats and sorts by the prior, between the other two
+	var titles []string
+	w := sendRequest("GET", fmt.Sprintf("/api/books?author_id=%d&sort=-rating&limit=1", author.ID), "", "")
+	for {
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		titles = append(titles, bookTitles(t, w)...)
+
+		next, ok := pageLinks(w)["next"]
+		if !ok || len(titles) > len(books) {
+			break
+		}
+		w = sendRequest("GET", next, "", "")
+	}
+	assert.Equal(t, []string{"Loved", "Unrated", "Disliked"}, titles)
+
+	w = sendRequest("GET", fmt.Sprintf("/api/books?author_id=%d&sort=rating_count&limit=1", author.ID), "", "")
+	assert.Equal(t, []string{"Unrated"}, bookTitles(t, w))
+
+	w = sendRequest("GET", pageLinks(w)["next"], "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Len(t, bookTitles(t, w), 1)
+	assert.NotEqual(t, "Unrated", bookTitles(t, w)[0])
+}
@@ -0,0 +1,310 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+// actAs gives the authenticated test user a role for the duration of a test.
+func actAs(t *testing.T, role string) {
+	config.DB.Model(&models.User{}).Where("email = ?", testUser.Email).UpdateColumn("role", role)
+
+	t.Cleanup(func() {
+		config.DB.Model(&models.User{}).Where("email = ?", testUser.Email).UpdateColumn("role", models.RoleReader)
+	})
+}
+
+func TestReaderCannotUpdateAnotherUsersAccount(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	var otherUser models.User
+	otherUser.Firstname = "Other"
+	otherUser.Lastname = "Reader"
+	otherUser.Email = "other.reader@test.com"
+	otherUser.SetPassword("myValidPassword")
+	config.DB.Create(&otherUser)
+
+	jsonData, err := json.Marshal(RegisterRequest{Firstname: "Hijacked"})
+	if err != nil {
+		panic(err)
+	}
+
+	relativeURL := "/api/users/" + strconv.Itoa(int(otherUser.ID))
+	req, err := http.NewRequest("PUT", relativeURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		panic(err)
+	}
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Read the response body
+	bodyBytes, err := io.ReadAll(w.Result().Body)
+	assert.NoError(t, err)
+
+	// Print the response body for debugging purposes
+	fmt.Println(string(bodyBytes))
+
+	var errorResponse *handlers.ErrorResponse
+	err = json.Unmarshal(bodyBytes, &errorResponse)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "You are not authorized to perform this action.", errorResponse.Message)
+
+	var unchanged models.User
+	config.DB.First(&unchanged, otherUser.ID)
+	assert.Equal(t, "Other", unchanged.Firstname)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&otherUser)
+	})
+}
+
+func TestUpdatingOwnAccountCannotTargetAnotherAccountThroughTheBody(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	var otherUser models.User
+	otherUser.Firstname = "Other"
+	otherUser.Lastname = "Reader"
+	otherUser.Email = "other.reader.body@test.com"
+	otherUser.SetPassword("myValidPassword")
+	config.DB.Create(&otherUser)
+
+	t.Cleanup(func() {
+		config.DB.Unscoped().Delete(&otherUser)
+	})
+
+	body := fmt.Sprintf(`{"ID": %d, "firstname": %q, "password": "hijackedpassword"}`, otherUser.ID, testUser.Firstname)
+	relativeURL := "/api/users/" + strconv.Itoa(int(testUser.ID))
+	req, err := http.NewRequest("PUT", relativeURL, bytes.NewBufferString(body))
+	if err != nil {
+		panic(err)
+	}
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var unchanged models.User
+	config.DB.First(&unchanged, otherUser.ID)
+	assert.Equal(t, "Other", unchanged.Firstname)
+	assert.Equal(t, otherUser.PasswordHash, unchanged.PasswordHash)
+	assert.True(t, unchanged.CheckPassword("myValidPassword"))
+
+	// The password belongs to the account in the URL, which is put back for the other tests
+	var own models.User
+	config.DB.First(&own, testUser.ID)
+	assert.True(t, own.CheckPassword("hijackedpassword"))
+	config.DB.Model(&own).UpdateColumn("password_hash", testUser.PasswordHash)
+}
+
+func TestReaderCannotDeleteAnotherUsersAccount(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	var otherUser models.User
+	otherUser.Firstname = "Other"
+	otherUser.Lastname = "Reader"
+	otherUser.Email = "other.reader.delete@test.com"
+	otherUser.SetPassword("myValidPassword")
+	config.DB.Create(&otherUser)
+
+	relativeURL := "/api/users/" + strconv.Itoa(int(otherUser.ID))
+	req, err := http.NewRequest("DELETE", relativeURL, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var stillThere models.User
+	assert.NoError(t, config.DB.First(&stillThere, otherUser.ID).Error)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&otherUser)
+	})
+}
+
+func TestUpdatingAUserCannotChangeTheirRole(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	jsonData, err := json.Marshal(map[string]string{"firstname": "Promoted", "role": models.RoleAdmin})
+	if err != nil {
+		panic(err)
+	}
+
+	relativeURL := "/api/users/" + strconv.Itoa(int(testUser.ID))
+	req, err := http.NewRequest("PUT", relativeURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		panic(err)
+	}
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var reloaded models.User
+	config.DB.First(&reloaded, testUser.ID)
+	assert.Equal(t, models.RoleReader, reloaded.Role)
+}
+
+func TestOnlyAnAdminCanChangeARole(t *testing.T) {
+	var otherUser models.User
+	otherUser.Firstname = "Future"
+	otherUser.Lastname = "Librarian"
+	otherUser.Email = "future.librarian@test.com"
+	otherUser.SetPassword("myValidPassword")
+	config.DB.Create(&otherUser)
+
+	jsonData, err := json.Marshal(RoleRequest{Role: models.RoleLibrarian})
+	if err != nil {
+		panic(err)
+	}
+
+	relativeURL := "/api/users/" + strconv.Itoa(int(otherUser.ID)) + "/role"
+
+	// As a reader
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", relativeURL, bytes.NewBuffer(jsonData))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// As an admin
+	actAs(t, models.RoleAdmin)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", relativeURL, bytes.NewBuffer(jsonData))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var reloaded models.User
+	config.DB.First(&reloaded, otherUser.ID)
+	assert.Equal(t, models.RoleLibrarian, reloaded.Role)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&otherUser)
+	})
+}
+
+func TestRegisteringCannotChooseARole(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	jsonData, err := json.Marshal(map[string]string{
+		"firstname": "Sneaky",
+		"lastname":  "Admin",
+		"email":     "sneaky.admin@test.com",
+		"password":  "myValidPassword",
+		"role":      models.RoleAdmin,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	req, _ := http.NewRequest("POST", "/register", bytes.NewBuffer(jsonData))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var registered models.User
+	config.DB.Where("email = ?", "sneaky.admin@test.com").First(&registered)
+	assert.Equal(t, models.RoleReader, registered.Role)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&registered)
+	})
+}
+
+func TestReaderCannotEditAnAuthorCreatedBySomeoneElse(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	var otherUser models.User
+	otherUser.Firstname = "Author"
+	otherUser.Lastname = "Owner"
+	otherUser.Email = "author.owner@test.com"
+	otherUser.SetPassword("myValidPassword")
+	config.DB.Create(&otherUser)
+
+	var author models.Author
+	author.Firstname = "Owned"
+	author.Lastname = "Author"
+	author.CreatedBy = otherUser.ID
+	config.DB.Create(&author)
+
+	jsonData, err := json.Marshal(CreateAuthorRequest{Firstname: "Renamed", Lastname: "Author"})
+	if err != nil {
+		panic(err)
+	}
+
+	relativeURL := "/api/users/authors/" + strconv.Itoa(int(author.ID))
+	req, _ := http.NewRequest("PUT", relativeURL, bytes.NewBuffer(jsonData))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// A librarian can curate it
+	actAs(t, models.RoleLibrarian)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", relativeURL, bytes.NewBuffer(jsonData))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var reloaded models.Author
+	config.DB.First(&reloaded, author.ID)
+	assert.Equal(t, "Renamed", reloaded.Firstname)
+	assert.Equal(t, otherUser.ID, reloaded.CreatedBy)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&author)
+		config.DB.Delete(&otherUser)
+	})
+}
+
+func TestLibrarianCanDeleteABookCreatedBySomeoneElse(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	actAs(t, models.RoleLibrarian)
+
+	var otherUser models.User
+	otherUser.Firstname = "Book"
+	otherUser.Lastname = "Owner"
+	otherUser.Email = "book.owner@test.com"
+	otherUser.SetPassword("myValidPassword")
+	config.DB.Create(&otherUser)
+
+	var newBook models.Book
+	newBook.Title = "Curated Away"
+	newBook.Isbn = "ISN-192-168-71-72"
+	newBook.UserID = otherUser.ID
+	newBook.AuthorID = testAuthor.ID
+	config.DB.Create(&newBook)
+
+	relativeURL := "/api/books/" + strconv.Itoa(int(newBook.ID))
+	req, _ := http.NewRequest("DELETE", relativeURL, nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&newBook)
+		config.DB.Delete(&otherUser)
+	})
+}
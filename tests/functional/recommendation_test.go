@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/fokosun/go-rest-api/recommend"
+	"github.com/stretchr/testify/assert"
+)
+
+// createRatedBooks creates books rated by a few readers, so that the first two are similar.
+func createRatedBooks(t *testing.T) []models.Book {
+	author := createListedBooks(t, "Liked", "Liked Alike", "Disliked")
+
+	books := []models.Book{}
+	config.DB.Where("author_id = ?", author.ID).Order("id").Find(&books)
+	for _, book := range books {
+		forgetRatings(t, book.ID)
+	}
+
+	for _, email := range []string{"recommend.one@test.com", "recommend.two@test.com"} {
+		reader := createLoginUser(email)
+		t.Cleanup(func() {
+			config.DB.Unscoped().Delete(&reader)
+		})
+		token := loginAs(t, reader.Email, "validpassword").Token
+
+		rate(t, books[0].ID, 5, token)
+		rate(t, books[1].ID, 5, token)
+		rate(t, books[2].ID, 1, token)
+	}
+
+	t.Cleanup(func() {
+		config.DB.Exec("DELETE FROM book_similarities")
+	})
+	assert.NoError(t, recommend.Rebuild(config.DB, 1, 50))
+
+	return books
+}
+
+func recommendationsOf(t *testing.T, w *httptest.ResponseRecorder) map[uint]handlers.RecommendationResponse {
+	var results []handlers.RecommendationResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+
+	byBook := map[uint]handlers.RecommendationResponse{}
+	for _, result := range results {
+		byBook[uint(result.Book.ID)] = result
+	}
+	return byBook
+}
+
+func TestBooksRatedAlikeAreSimilar(t *testing.T) {
+	books := createRatedBooks(t)
+
+	w := sendRequest("GET", "/api/books/"+strconv.Itoa(int(books[0].ID))+"/similar", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	similar := recommendationsOf(t, w)
+	assert.Contains(t, similar, books[1].ID)
+	assert.NotContains(t, similar, books[0].ID)
+	assert.Greater(t, similar[books[1].ID].Score, similar[books[2].ID].Score)
+	assert.Equal(t, recommend.ReasonSimilar, similar[books[1].ID].Reason)
+}
+
+func TestRecommendationsAreSimilarToWhatTheUserLiked(t *testing.T) {
+	books := createRatedBooks(t)
+	rate(t, books[0].ID, 5, "")
+
+	w := sendRequest("GET", "/api/me/recommendations", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	recommended := recommendationsOf(t, w)
+	assert.Equal(t, recommend.ReasonSimilar, recommended[books[1].ID].Reason)
+	assert.NotContains(t, recommended, books[0].ID, "books the user rated are not recommended")
+}
+
+func TestNewUsersAreRecommendedPopularBooks(t *testing.T) {
+	books := createRatedBooks(t)
+
+	w := sendRequest("GET", "/api/me/recommendations?limit=50", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	recommended := recommendationsOf(t, w)
+	assert.Equal(t, recommend.ReasonPopular, recommended[books[0].ID].Reason)
+}
+
+func TestRecommendationsRejectABadLimit(t *testing.T) {
+	for _, url := range []string{"/api/me/recommendations?limit=0", "/api/me/recommendations?limit=51", "/api/me/recommendations?sort=score"} {
+		w := sendRequest("GET", url, "", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, url)
+	}
+}
+
+func TestSimilarBooksOfAMissingBook(t *testing.T) {
+	w := sendRequest("GET", "/api/books/999999999/similar", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
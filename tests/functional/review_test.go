@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/fokosun/go-rest-api/moderation"
+	"github.com/stretchr/testify/assert"
+)
+
+func useModerationFilter(t *testing.T, words ...string) {
+	filter, err := moderation.NewFilter(words, moderation.DefaultPatterns)
+	assert.NoError(t, err)
+
+	previous := moderation.Default()
+	moderation.SetDefault(filter)
+	t.Cleanup(func() {
+		moderation.SetDefault(previous)
+	})
+}
+
+func reviewURL(ratingID uint, action string) string {
+	return "/api/books/ratings/" + strconv.Itoa(int(ratingID)) + "/" + action
+}
+
+func ratingIDs(t *testing.T, w *httptest.ResponseRecorder) []uint {
+	var ratings []models.Rating
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &ratings))
+
+	ids := make([]uint, len(ratings))
+	for i, rating := range ratings {
+		ids[i] = rating.ID
+	}
+	return ids
+}
+
+// createReview saves an approved review of a book by a new user.
+func createReview(t *testing.T, bookID uint, email string, helpful int64) models.Rating {
+	user := createLoginUser(email)
+	rating := models.Rating{UserID: user.ID, BookID: int(bookID), Rating: 4, Comment: "Worth reading", HelpfulCount: helpful}
+	config.DB.Create(&rating)
+
+	t.Cleanup(func() {
+		config.DB.Where("rating_id = ?", rating.ID).Delete(&models.ReviewVote{})
+		config.DB.Unscoped().Delete(&rating)
+		config.DB.Unscoped().Delete(&user)
+	})
+
+	return rating
+}
+
+func TestFlaggedReviewsWaitForAModerator(t *testing.T) {
+	useModerationFilter(t, "spoiler")
+	book := createEditableBook(t, testUser.ID)
+	forgetRatings(t, book.ID)
+
+	w := sendRequest("POST", ratingsURL(book.ID), `{"rating": 2, "comment": "Spoiler: the butler did it"}`, "")
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var rating models.Rating
+	json.Unmarshal(w.Body.Bytes(), &rating)
+	assert.Equal(t, models.ReviewPending, rating.ReviewStatus)
+	assert.True(t, rating.Flagged)
+	assert.Equal(t, "Spoiler", rating.FlaggedFor)
+
+	assert.NotContains(t, ratingIDs(t, sendRequest("GET", ratingsURL(book.ID), "", "")), rating.ID)
+
+	actAs(t, models.RoleLibrarian)
+	w = sendRequest("GET", fmt.Sprintf("/api/books/ratings/moderation?book_id=%d", book.ID), "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []uint{rating.ID}, ratingIDs(t, w))
+
+	w = sendRequest("POST", reviewURL(rating.ID, "approve"), "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, ratingIDs(t, sendRequest("GET", ratingsURL(book.ID), "", "")), rating.ID)
+}
+
+func TestRejectedReviewsAreNotListed(t *testing.T) {
+	useModerationFilter(t)
+	book := createEditableBook(t, testUser.ID)
+	forgetRatings(t, book.ID)
+
+	// Links are flagged by default
+	w := sendRequest("POST", ratingsURL(book.ID), `{"rating": 5, "comment": "Cheap copies at https://example.com/cheap"}`, "")
+	var rating models.Rating
+	json.Unmarshal(w.Body.Bytes(), &rating)
+	assert.Equal(t, models.ReviewPending, rating.ReviewStatus)
+
+	actAs(t, models.RoleLibrarian)
+	w = sendRequest("POST", reviewURL(rating.ID, "reject"), "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	assert.NotContains(t, ratingIDs(t, sendRequest("GET", ratingsURL(book.ID), "", "")), rating.ID)
+
+	w = sendRequest("GET", fmt.Sprintf("/api/books/ratings/moderation?book_id=%d&status=rejected", book.ID), "", "")
+	assert.Equal(t, []uint{rating.ID}, ratingIDs(t, w))
+}
+
+func TestOnlyModeratorsCanModerateReviews(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+	review := createReview(t, book.ID, "moderated.reviewer@test.com", 0)
+
+	w := sendRequest("POST", reviewURL(review.ID, "reject"), "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = sendRequest("GET", "/api/books/ratings/moderation", "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestReviewsAreMarkedHelpfulOncePerUser(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+	review := createReview(t, book.ID, "helpful.reviewer@test.com", 0)
+
+	for i := 0; i < 2; i++ {
+		w := sendRequest("POST", reviewURL(review.ID, "helpful"), "", "")
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var stored models.Rating
+	config.DB.First(&stored, review.ID)
+	assert.Equal(t, int64(1), stored.HelpfulCount)
+
+	w := sendRequest("DELETE", reviewURL(review.ID, "helpful"), "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	config.DB.First(&stored, review.ID)
+	assert.Equal(t, int64(0), stored.HelpfulCount)
+}
+
+func TestUsersCannotMarkTheirOwnReviewHelpful(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+	forgetRatings(t, book.ID)
+
+	w := sendRequest("POST", ratingsURL(book.ID), `{"rating": 5, "comment": "I liked it"}`, "")
+	var rating models.Rating
+	json.Unmarshal(w.Body.Bytes(), &rating)
+
+	w = sendRequest("POST", reviewURL(rating.ID, "helpful"), "", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestReviewsAreListedByHelpfulness(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+	useful := createReview(t, book.ID, "useful.reviewer@test.com", 3)
+	useless := createReview(t, book.ID, "useless.reviewer@test.com", 0)
+	most := createReview(t, book.ID, "most.useful.reviewer@test.com", 7)
+
+	w := sendRequest("GET", ratingsURL(book.ID), "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []uint{most.ID, useful.ID, useless.ID}, ratingIDs(t, w))
+}
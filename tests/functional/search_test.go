@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// createSearchCatalogue creates an author with books whose words appear nowhere else. The
+// second book also has a translator.
+func createSearchCatalogue(t *testing.T) (models.Author, []models.Book) {
+	author := models.Author{Firstname: "Quillon", Lastname: "Vexhammer", CreatedBy: testUser.ID}
+	config.DB.Create(&author)
+	translator := models.Author{Firstname: "Ondrix", Lastname: "Pellweather", CreatedBy: testUser.ID}
+	config.DB.Create(&translator)
+
+	books := []models.Book{
+		{Title: "Xylophone Quantum Mechanics", Isbn: "9781402894626", UserID: testUser.ID, AuthorID: author.ID},
+		{Title: "Zyzzyva & <Friends>", Isbn: "9780306406157", UserID: testUser.ID, AuthorID: author.ID},
+	}
+	config.DB.Create(&books)
+
+	credits := []models.BookAuthor{
+		{BookID: books[0].ID, AuthorID: author.ID, Role: models.ContributorAuthor},
+		{BookID: books[1].ID, AuthorID: author.ID, Role: models.ContributorAuthor},
+		{BookID: books[1].ID, AuthorID: translator.ID, Role: models.ContributorTranslator, Position: 1},
+	}
+	config.DB.Create(&credits)
+
+	t.Cleanup(func() {
+		config.DB.Where("book_id IN ?", []uint{books[0].ID, books[1].ID}).Delete(&models.BookAuthor{})
+		config.DB.Delete(&books)
+		config.DB.Delete(&translator)
+		config.DB.Delete(&author)
+	})
+
+	return author, books
+}
+
+func searchFor(t *testing.T, query string) handlers.SearchResponse {
+	w := sendRequest("GET", "/api/search?"+query, "", "")
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var response handlers.SearchResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response
+}
+
+func TestSearchFindsBooksByTheBeginningOfTheirWords(t *testing.T) {
+	_, books := createSearchCatalogue(t)
+
+	response := searchFor(t, "q=xyloph+quant")
+	assert.NotEmpty(t, response.Results)
+
+	first := response.Results[0]
+	assert.Equal(t, handlers.SearchResultBook, first.Type)
+	assert.Equal(t, books[0].ID, first.ID)
+	assert.Equal(t, "<mark>Xylophone</mark> <mark>Quantum</mark> Mechanics", first.Snippet)
+	assert.Equal(t, "Quillon", first.Book.Author.Firstname)
+}
+
+func TestSearchToleratesTypos(t *testing.T) {
+	_, books := createSearchCatalogue(t)
+
+	response := searchFor(t, "q=xylophoen&type=book")
+	assert.NotEmpty(t, response.Results)
+	assert.Equal(t, books[0].ID, response.Results[0].ID)
+}
+
+func TestSearchFindsBooksByISBN(t *testing.T) {
+	_, books := createSearchCatalogue(t)
+
+	response := searchFor(t, "q="+url.QueryEscape("978-1-4028-9462-6"))
+	assert.NotEmpty(t, response.Results)
+	assert.Equal(t, books[0].ID, response.Results[0].ID)
+}
+
+func TestSearchFindsBooksByTheirContributors(t *testing.T) {
+	_, books := createSearchCatalogue(t)
+
+	response := searchFor(t, "q=vexham&type=book")
+	assert.Len(t, response.Results, 2)
+
+	response = searchFor(t, "q=pellweath&type=book")
+	assert.Len(t, response.Results, 1)
+	assert.Equal(t, books[1].ID, response.Results[0].ID)
+	assert.Equal(t, "Zyzzyva &amp; &lt;Friends&gt;", response.Results[0].Snippet)
+}
+
+func TestSearchFindsAuthors(t *testing.T) {
+	author, _ := createSearchCatalogue(t)
+
+	response := searchFor(t, "q=vexham&type=author")
+	assert.Len(t, response.Results, 1)
+	assert.Equal(t, handlers.SearchResultAuthor, response.Results[0].Type)
+	assert.Equal(t, author.ID, response.Results[0].Author.ID)
+	assert.Equal(t, "Quillon <mark>Vexhammer</mark>", response.Results[0].Snippet)
+}
+
+func TestSearchSnippetsAreEscaped(t *testing.T) {
+	createSearchCatalogue(t)
+
+	response := searchFor(t, "q=zyzzyva&type=book")
+	assert.NotEmpty(t, response.Results)
+	assert.Equal(t, "<mark>Zyzzyva</mark> &amp; &lt;Friends&gt;", response.Results[0].Snippet)
+}
+
+func TestSearchRefusesInvalidQueries(t *testing.T) {
+	for _, query := range []string{"", "q=", "q=%21%21", "q=go&type=user", "q=go&limit=500", "q=go&page=2"} {
+		w := sendRequest("GET", "/api/search?"+query, "", "")
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
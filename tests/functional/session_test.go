@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func sessionsFor(t *testing.T, token string) []handlers.SessionResponse {
+	w := sendRequest("GET", "/api/me/sessions", "", token)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var sessions []handlers.SessionResponse
+	err := json.Unmarshal(w.Body.Bytes(), &sessions)
+	assert.NoError(t, err)
+
+	return sessions
+}
+
+func TestLoginCreatesASessionListedAsCurrent(t *testing.T) {
+	newUser := createLoginUser("sessions@test.com")
+
+	loginAs(t, newUser.Email, "validpassword")
+	second := loginAs(t, newUser.Email, "validpassword")
+
+	sessions := sessionsFor(t, second.Token)
+	assert.Len(t, sessions, 2)
+
+	claims, err := auth.ParseToken(second.Token)
+	assert.NoError(t, err)
+
+	for _, session := range sessions {
+		assert.Equal(t, session.ID == claims.Family, session.Current)
+	}
+
+	t.Cleanup(func() {
+		config.DB.Where("user_id = ?", newUser.ID).Delete(&models.Session{})
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestRevokedSessionCanNoLongerBeUsed(t *testing.T) {
+	newUser := createLoginUser("revoke-session@test.com")
+
+	lost := loginAs(t, newUser.Email, "validpassword")
+	current := loginAs(t, newUser.Email, "validpassword")
+
+	claims, err := auth.ParseToken(lost.Token)
+	assert.NoError(t, err)
+
+	w := sendRequest("DELETE", "/api/me/sessions/"+claims.Family, "", current.Token)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = sendRequest("GET", "/api/books", "", lost.Token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = refreshWith(lost.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// The other device is still signed in
+	sessions := sessionsFor(t, current.Token)
+	assert.Len(t, sessions, 1)
+	assert.True(t, sessions[0].Current)
+
+	t.Cleanup(func() {
+		config.DB.Where("user_id = ?", newUser.ID).Delete(&models.Session{})
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestCannotRevokeAnotherUsersSession(t *testing.T) {
+	owner := createLoginUser("session-owner@test.com")
+	other := createLoginUser("session-other@test.com")
+
+	ownerToken := loginAs(t, owner.Email, "validpassword")
+	otherToken := loginAs(t, other.Email, "validpassword")
+
+	claims, err := auth.ParseToken(ownerToken.Token)
+	assert.NoError(t, err)
+
+	w := sendRequest("DELETE", "/api/me/sessions/"+claims.Family, "", otherToken.Token)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = sendRequest("GET", "/api/books", "", ownerToken.Token)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	t.Cleanup(func() {
+		config.DB.Where("user_id IN ?", []uint{owner.ID, other.ID}).Delete(&models.Session{})
+		config.DB.Delete(&owner)
+		config.DB.Delete(&other)
+	})
+}
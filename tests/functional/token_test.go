@@ -0,0 +1,216 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func loginAs(t *testing.T, email string, password string) handlers.LoginToken {
+	w := httptest.NewRecorder()
+
+	jsonData, err := json.Marshal(LoginRequest{Email: email, Password: password})
+	if err != nil {
+		panic(err)
+	}
+
+	req, err := http.NewRequest("POST", "/auth/login", bytes.NewBuffer(jsonData))
+	if err != nil {
+		panic(err)
+	}
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var loginToken handlers.LoginToken
+	err = json.Unmarshal(w.Body.Bytes(), &loginToken)
+	assert.NoError(t, err)
+
+	return loginToken
+}
+
+func refreshWith(refreshToken string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	jsonData, err := json.Marshal(RefreshRequest{RefreshToken: refreshToken})
+	if err != nil {
+		panic(err)
+	}
+
+	req, err := http.NewRequest("POST", "/auth/refresh", bytes.NewBuffer(jsonData))
+	if err != nil {
+		panic(err)
+	}
+
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func createLoginUser(email string) models.User {
+	var newUser models.User
+	newUser.Firstname = "refresh"
+	newUser.Lastname = "token"
+	newUser.Email = email
+	newUser.SetPassword("validpassword")
+	config.DB.Create(&newUser)
+
+	return newUser
+}
+
+func TestLoginReturnsAnAccessAndARefreshToken(t *testing.T) {
+	newUser := createLoginUser("pair@test.com")
+
+	loginToken := loginAs(t, newUser.Email, "validpassword")
+
+	assert.NotEmpty(t, loginToken.Token)
+	assert.NotEmpty(t, loginToken.RefreshToken)
+	assert.Equal(t, int64(auth.AccessTokenTTL.Seconds()), loginToken.ExpiresIn)
+
+	claims, err := auth.ParseToken(loginToken.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, auth.AccessTokenType, claims.Type)
+	assert.NotEmpty(t, claims.ID)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestRefreshRotatesTheRefreshToken(t *testing.T) {
+	newUser := createLoginUser("rotate@test.com")
+
+	loginToken := loginAs(t, newUser.Email, "validpassword")
+
+	w := refreshWith(loginToken.RefreshToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Read the response body
+	bodyBytes, err := io.ReadAll(w.Result().Body)
+	assert.NoError(t, err)
+
+	// Print the response body for debugging purposes
+	fmt.Println(string(bodyBytes))
+
+	var refreshed handlers.LoginToken
+	err = json.Unmarshal(bodyBytes, &refreshed)
+	assert.NoError(t, err)
+
+	assert.NotEmpty(t, refreshed.Token)
+	assert.NotEqual(t, loginToken.RefreshToken, refreshed.RefreshToken)
+
+	oldClaims, _ := auth.ParseToken(loginToken.RefreshToken)
+	newClaims, _ := auth.ParseToken(refreshed.RefreshToken)
+	assert.Equal(t, oldClaims.Family, newClaims.Family)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestRefreshRejectsAnAccessToken(t *testing.T) {
+	newUser := createLoginUser("wrongtype@test.com")
+
+	loginToken := loginAs(t, newUser.Email, "validpassword")
+
+	w := refreshWith(loginToken.Token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	var errorResponse *handlers.ErrorResponse
+	err := json.Unmarshal(w.Body.Bytes(), &errorResponse)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Invalid refresh token", errorResponse.Message)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestReusingARefreshTokenRevokesTheWholeFamily(t *testing.T) {
+	newUser := createLoginUser("reuse@test.com")
+
+	loginToken := loginAs(t, newUser.Email, "validpassword")
+
+	w := refreshWith(loginToken.RefreshToken)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var refreshed handlers.LoginToken
+	err := json.Unmarshal(w.Body.Bytes(), &refreshed)
+	assert.NoError(t, err)
+
+	// Replay the refresh token that was already rotated
+	w = refreshWith(loginToken.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// The legitimate successor is now revoked as well
+	w = refreshWith(refreshed.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	accessClaims, err := auth.ParseToken(refreshed.Token)
+	assert.NoError(t, err)
+
+	revoked, err := auth.IsRevoked(accessClaims)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestLogoutRevokesTheAccessAndRefreshTokens(t *testing.T) {
+	newUser := createLoginUser("logout@test.com")
+
+	loginToken := loginAs(t, newUser.Email, "validpassword")
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/auth/logout", nil)
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+loginToken.Token)
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	claims, err := auth.ParseToken(loginToken.Token)
+	assert.NoError(t, err)
+
+	revoked, err := auth.IsRevoked(claims)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	w = refreshWith(loginToken.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestLogoutRequiresAToken(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	req, err := http.NewRequest("POST", "/auth/logout", nil)
+	if err != nil {
+		panic(err)
+	}
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func trashedBookIDs(t *testing.T, w *httptest.ResponseRecorder) []uint {
+	var books []models.Book
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &books))
+
+	ids := make([]uint, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+	}
+	return ids
+}
+
+func TestDeletedBooksCanBeRestored(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+	url := fmt.Sprintf("/api/books/%d", book.ID)
+
+	w := sendRequest("DELETE", url, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusNotFound, sendRequest("GET", url, "", "").Code)
+
+	var stored models.Book
+	config.DB.Unscoped().First(&stored, book.ID)
+	assert.True(t, stored.DeletedAt.Valid)
+
+	w = sendRequest("GET", "/api/books/trash", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, trashedBookIDs(t, w), book.ID)
+
+	w = sendRequest("POST", url+"/restore", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusOK, sendRequest("GET", url, "", "").Code)
+	assert.NotContains(t, trashedBookIDs(t, sendRequest("GET", "/api/books/trash", "", "")), book.ID)
+
+	// Only deleted books can be restored
+	w = sendRequest("POST", url+"/restore", "", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestReadersOnlySeeTheirOwnDeletedBooks(t *testing.T) {
+	owner := createLoginUser("trash-owner@example.com")
+	t.Cleanup(func() {
+		config.DB.Unscoped().Delete(&owner)
+	})
+	book := createEditableBook(t, owner.ID)
+	config.DB.Delete(&book)
+
+	assert.NotContains(t, trashedBookIDs(t, sendRequest("GET", "/api/books/trash", "", "")), book.ID)
+
+	w := sendRequest("POST", fmt.Sprintf("/api/books/%d/restore", book.ID), "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	actAs(t, models.RoleLibrarian)
+	assert.Contains(t, trashedBookIDs(t, sendRequest("GET", "/api/books/trash?sort=-deleted_at&limit=200", "", "")), book.ID)
+}
+
+func TestOnlyAdminsCanPurgeBooks(t *testing.T) {
+	book := createEditableBook(t, testUser.ID)
+	rating := models.Rating{UserID: testUser.ID, BookID: int(book.ID), Rating: 4}
+	config.DB.Create(&rating)
+	config.DB.Delete(&book)
+
+	url := fmt.Sprintf("/api/books/trash/%d", book.ID)
+
+	actAs(t, models.RoleLibrarian)
+	assert.Equal(t, http.StatusForbidden, sendRequest("DELETE", url, "", "").Code)
+
+	actAs(t, models.RoleAdmin)
+	assert.Equal(t, http.StatusNoContent, sendRequest("DELETE", url, "", "").Code)
+
+	var count int64
+	config.DB.Unscoped().Model(&models.Book{}).Where("id = ?", book.ID).Count(&count)
+	assert.Zero(t, count)
+	config.DB.Unscoped().Model(&models.Rating{}).Where("id = ?", rating.ID).Count(&count)
+	assert.Zero(t, count)
+
+	// Books that are not in the trash cannot be purged
+	live := createEditableBook(t, testUser.ID)
+	assert.Equal(t, http.StatusNotFound, sendRequest("DELETE", fmt.Sprintf("/api/books/trash/%d", live.ID), "", "").Code)
+}
+
+func TestAuthorsWithBooksCannotBeDeleted(t *testing.T) {
+	author := createListedBooks(t, "Still Here")
+
+	w := sendRequest("DELETE", fmt.Sprintf("/api/users/authors/%d", author.ID), "", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestDeletedAuthorsCanBeRestored(t *testing.T) {
+	author := models.Author{Firstname: "Trashed", Lastname: "Author", CreatedBy: testUser.ID}
+	config.DB.Create(&author)
+	t.Cleanup(func() {
+		config.DB.Unscoped().Delete(&author)
+	})
+	url := fmt.Sprintf("/api/users/authors/%d", author.ID)
+
+	assert.Equal(t, http.StatusOK, sendRequest("DELETE", url, "", "").Code)
+	assert.Equal(t, http.StatusNotFound, sendRequest("GET", url, "", "").Code)
+
+	w := sendRequest("GET", "/api/users/authors/trash", "", "")
+	var authors []models.Author
+	json.Unmarshal(w.Body.Bytes(), &authors)
+	found := false
+	for _, trashed := range authors {
+		found = found || trashed.ID == author.ID
+	}
+	assert.True(t, found)
+
+	assert.Equal(t, http.StatusOK, sendRequest("POST", url+"/restore", "", "").Code)
+	assert.Equal(t, http.StatusOK, sendRequest("GET", url, "", "").Code)
+}
+
+func TestDeletedUsersCannotLoginUntilRestored(t *testing.T) {
+	user := createLoginUser("trash-user@example.com")
+	t.Cleanup(func() {
+		config.DB.Unscoped().Where("email = ?", user.Email).Delete(&models.User{})
+	})
+	url := fmt.Sprintf("/api/users/%d", user.ID)
+
+	actAs(t, models.RoleAdmin)
+	assert.Equal(t, http.StatusNoContent, sendRequest("DELETE", url, "", "").Code)
+
+	w := postJSON("/auth/login", LoginRequest{Email: user.Email, Password: "validpassword"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = sendRequest("GET", "/api/users/trash?email="+user.Email, "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var users []models.User
+	json.Unmarshal(w.Body.Bytes(), &users)
+	assert.Len(t, users, 1)
+
+	assert.Equal(t, http.StatusOK, sendRequest("POST", url+"/restore", "", "").Code)
+	loginAs(t, user.Email, "validpassword")
+}
+
+func TestDeletedUsersAddressCanBeUsedAgain(t *testing.T) {
+	user := createLoginUser("trash-reuse@example.com")
+	t.Cleanup(func() {
+		config.DB.Unscoped().Where("email = ?", user.Email).Delete(&models.User{})
+	})
+	config.DB.Delete(&user)
+
+	again := createLoginUser(user.Email)
+	assert.NotZero(t, again.ID)
+
+	// The old account cannot come back while the new one has its address
+	actAs(t, models.RoleAdmin)
+	w := sendRequest("POST", fmt.Sprintf("/api/users/%d/restore", user.ID), "", "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestTrashIsPurgedAfterTheRetentionPeriod(t *testing.T) {
+	author := models.Author{Firstname: "Purged", Lastname: "Author", CreatedBy: testUser.ID}
+	config.DB.Create(&author)
+	old := models.Book{Title: "Old", UserID: testUser.ID, AuthorID: author.ID, Version: 1}
+	recent := models.Book{Title: "Recent", UserID: testUser.ID, AuthorID: author.ID, Version: 1}
+	config.DB.Create(&old)
+	config.DB.Create(&recent)
+	t.Cleanup(func() {
+		config.DB.Unscoped().Delete(&recent)
+		config.DB.Unscoped().Delete(&author)
+	})
+
+	longAgo := time.Now().Add(-48 * time.Hour)
+	config.DB.Unscoped().Model(&old).Update("deleted_at", longAgo)
+	config.DB.Unscoped().Model(&author).Update("deleted_at", longAgo)
+	config.DB.Delete(&recent)
+
+	_, err := models.PurgeTrash(config.DB, time.Now().Add(-24*time.Hour))
+	assert.NoError(t, err)
+
+	var count int64
+	config.DB.Unscoped().Model(&models.Book{}).Where("id = ?", old.ID).Count(&count)
+	assert.Zero(t, count)
+	config.DB.Unscoped().Model(&models.Book{}).Where("id = ?", recent.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+
+	// The author waits for their last book to be purged
+	config.DB.Unscoped().Model(&models.Author{}).Where("id = ?", author.ID).Count(&count)
+	assert.Equal(t, int64(1), count)
+}
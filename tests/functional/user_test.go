@@ -582,6 +582,8 @@ func TestUpdateUserRespondsWith400BadRequestIfTryingToUpdateEmail(t *testing.T)
 func TestCanUpdateUserWithAllowedFields(t *testing.T) {
 	w := httptest.NewRecorder()
 
+	actAs(t, models.RoleAdmin)
+
 	requestData := RegisterRequest{
 		Firstname: "Freshman",
 		Lastname:  "Jamrock",
@@ -665,6 +667,8 @@ func TestDeleteUserRespondsWith404NotFoundIfUserNotFound(t *testing.T) {
 func TestDeleteUserSucceedsIfUserFound(t *testing.T) {
 	w := httptest.NewRecorder()
 
+	actAs(t, models.RoleAdmin)
+
 	//create a test user
 	var newUser models.User
 	newUser.Firstname = "test"
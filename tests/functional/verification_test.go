@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/fokosun/go-rest-api/auth"
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+var verificationLinkPattern = regexp.MustCompile(`/auth/verify\?token=(\S+)`)
+
+// verificationTokenSentTo returns the token from the last verification email sent to the address.
+func verificationTokenSentTo(t *testing.T, email string) string {
+	msg, ok := testMailer.LastTo(email)
+	assert.True(t, ok)
+
+	match := verificationLinkPattern.FindStringSubmatch(msg.Body)
+	assert.Len(t, match, 2)
+
+	token, err := url.QueryUnescape(match[1])
+	assert.NoError(t, err)
+
+	return token
+}
+
+func verify(token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	req, err := http.NewRequest("GET", "/auth/verify?token="+url.QueryEscape(token), nil)
+	if err != nil {
+		panic(err)
+	}
+
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRegisterSendsAVerificationLinkThatVerifiesTheEmail(t *testing.T) {
+	w := postJSON("/register", RegisterRequest{
+		Firstname: "Verify",
+		Lastname:  "Me",
+		Email:     "verify.me@test.com",
+		Password:  "myValidPassword",
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var newUser handlers.NewUser
+	err := json.Unmarshal(w.Body.Bytes(), &newUser)
+	assert.NoError(t, err)
+	assert.Nil(t, newUser.EmailVerifiedAt)
+
+	token := verificationTokenSentTo(t, "verify.me@test.com")
+
+	w = verify(token)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Read the response body
+	bodyBytes, err := io.ReadAll(w.Result().Body)
+	assert.NoError(t, err)
+
+	// Print the response body for debugging purposes
+	fmt.Println(string(bodyBytes))
+
+	var reloaded models.User
+	config.DB.First(&reloaded, newUser.ID)
+	assert.True(t, reloaded.IsEmailVerified())
+
+	t.Cleanup(func() {
+		config.DB.Delete(&models.User{}, newUser.ID)
+	})
+}
+
+func TestVerifyRejectsAnInvalidToken(t *testing.T) {
+	w := verify("not-a-token")
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var errorResponse *handlers.ErrorResponse
+	err := json.Unmarshal(w.Body.Bytes(), &errorResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, handlers.InvalidVerificationTokenMessage, errorResponse.Message)
+}
+
+func TestVerifyRejectsAnAccessToken(t *testing.T) {
+	newUser := createLoginUser("verify.access@test.com")
+
+	loginToken := loginAs(t, newUser.Email, "validpassword")
+
+	w := verify(loginToken.Token)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&newUser)
+	})
+}
+
+func TestVerificationTokenCannotBeUsedAsAnAccessToken(t *testing.T) {
+	token, err := auth.GenerateEmailVerificationToken(testUser.Email, time.Hour)
+	assert.NoError(t, err)
+
+	claims, err := auth.ParseToken(token)
+	assert.NoError(t, err)
+	assert.False(t, claims.IsAccessToken())
+}
+
+func TestResendOnlySendsToUnverifiedAccounts(t *testing.T) {
+	unverified := createLoginUser("resend.unverified@test.com")
+
+	now := time.Now()
+	verified := createLoginUser("resend.verified@test.com")
+	config.DB.Model(&verified).Update("email_verified_at", &now)
+
+	testMailer.Reset()
+
+	w := postJSON("/auth/verify/resend", ResendVerificationRequest{Email: unverified.Email})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = postJSON("/auth/verify/resend", ResendVerificationRequest{Email: verified.Email})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	_, sent := testMailer.LastTo(unverified.Email)
+	assert.True(t, sent)
+
+	_, sent = testMailer.LastTo(verified.Email)
+	assert.False(t, sent)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&unverified)
+		config.DB.Delete(&verified)
+	})
+}
+
+func TestLoginRefusesUnverifiedAccountsWhenRequired(t *testing.T) {
+	t.Setenv("REQUIRE_EMAIL_VERIFICATION", "true")
+
+	newUser := createLoginUser("login.unverified@test.com")
+
+	w := postJSON("/auth/login", LoginRequest{Email: newUser.Email, Password: "validpassword"})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	var errorResponse *handlers.ErrorResponse
+	err := json.Unmarshal(w.Body.Bytes(), &errorResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, handlers.EmailNotVerifiedMessage, errorResponse.Message)
+
+	now := time.Now()
+	config.DB.Model(&newUser).Update("email_verified_at", &now)
+
+	w = postJSON("/auth/login", LoginRequest{Email: newUser.Email, Password: "validpassword"})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&newUser)
+	})
+}
@@ -0,0 +1,418 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/handlers"
+	"github.com/fokosun/go-rest-api/models"
+	"github.com/fokosun/go-rest-api/webhooks"
+	"github.com/stretchr/testify/assert"
+)
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret,omitempty"`
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver is a local endpoint that records every delivery it is sent.
+func webhookReceiver(t *testing.T, status int) (*httptest.Server, chan receivedWebhook) {
+	received := make(chan receivedWebhook, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedWebhook{header: r.Header.Clone(), body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, received
+}
+
+func createWebhookSubscription(t *testing.T, url string, events ...string) handlers.CreatedWebhookSubscription {
+	w := postJSON("/api/webhooks", CreateWebhookRequest{URL: url, Events: events})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var created handlers.CreatedWebhookSubscription
+	err := json.Unmarshal(w.Body.Bytes(), &created)
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		config.DB.Delete(&models.WebhookSubscription{}, created.ID)
+	})
+
+	return created
+}
+
+func waitForWebhook(t *testing.T, received chan receivedWebhook) receivedWebhook {
+	select {
+	case delivery := <-received:
+		return delivery
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook was delivered")
+		return receivedWebhook{}
+	}
+}
+
+func TestOnlyAdminsCanManageWebhookSubscriptions(t *testing.T) {
+	w := postJSON("/api/webhooks", CreateWebhookRequest{URL: "https://example.com/hook", Events: []string{webhooks.EventBookCreated}})
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestCreateWebhookSubscriptionRejectsUnknownEventsAndURLs(t *testing.T) {
+	actAs(t, models.RoleAdmin)
+
+	w := postJSON("/api/webhooks", CreateWebhookRequest{URL: "https://example.com/hook", Events: []string{"book.burned"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = postJSON("/api/webhooks", CreateWebhookRequest{URL: "ftp://example.com/hook", Events: []string{webhooks.EventBookCreated}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestWebhookSubscriptionSecretIsOnlyShownOnCreate(t *testing.T) {
+	actAs(t, models.RoleAdmin)
+
+	created := createWebhookSubscription(t, "https://example.com/hook", webhooks.EventBookCreated)
+	assert.NotEmpty(t, created.Secret)
+	assert.Equal(t, []string{webhooks.EventBookCreated}, created.Events)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/webhooks/"+strconv.Itoa(int(created.ID)), nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), created.Secret)
+}
+
+func TestSubscribedEventsAreDeliveredSigned(t *testing.T) {
+	actAs(t, models.RoleAdmin)
+
+	receiver, received := webhookReceiver(t, http.StatusOK)
+	created := createWebhookSubscription(t, receiver.URL, webhooks.EventAuthorCreated)
+
+	w := postJSON("/api/users/authors", CreateAuthorRequest{Firstname: "Webhook", Lastname: "Author"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var author models.Author
+	json.Unmarshal(w.Body.Bytes(), &author)
+	t.Cleanup(func() {
+		config.DB.Delete(&author)
+	})
+
+	delivery := waitForWebhook(t, received)
+
+	assert.Equal(t, webhooks.EventAuthorCreated, delivery.header.Get(webhooks.EventHeader))
+	assert.True(t, webhooks.Verify(
+		created.Secret,
+		delivery.header.Get(webhooks.TimestampHeader),
+		delivery.body,
+		delivery.header.Get(webhooks.SignatureHeader),
+	))
+
+	var event struct {
+		ID   string        `json:"id"`
+		Type string        `json:"type"`
+		Data models.Author `json:"data"`
+	}
+	err := json.Unmarshal(delivery.body, &event)
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.header.Get(webhooks.IDHeader), event.ID)
+	assert.Equal(t, webhooks.EventAuthorCreated, event.Type)
+	assert.Equal(t, author.ID, event.Data.ID)
+}
+
+func TestEventsAreOnlyDeliveredToActiveSubscribers(t *testing.T) {
+	actAs(t, models.RoleAdmin)
+
+	receiver, received := webhookReceiver(t, http.StatusOK)
+	createWebhookSubscription(t, receiver.URL, webhooks.EventBookDeleted)
+	inactive := createWebhookSubscription(t, receiver.URL, webhooks.EventAuthorCreated)
+	config.DB.Model(&models.WebhookSubscription{}).Where("id = ?", inactive.ID).Update("active", false)
+
+	w := postJSON("/api/users/authors", CreateAuthorRequest{Firstname: "Quiet", Lastname: "Author"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var author models.Author
+	json.Unmarshal(w.Body.Bytes(), &author)
+	t.Cleanup(func() {
+		config.DB.Delete(&author)
+	})
+
+	select {
+	case delivery := <-received:
+		t.Fatalf("unexpected delivery of %s", delivery.header.Get(webhooks.EventHeader))
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestWebhookSignatureDependsOnSecretTimestampAndBody(t *testing.T) {
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	body := []byte(`{"type":"book.created"}`)
+
+	signature := webhooks.Sign("secret", now, body)
+
+	assert.True(t, webhooks.Verify("secret", timestamp, body, signature))
+	assert.False(t, webhooks.Verify("other", timestamp, body, signature))
+	assert.False(t, webhooks.Verify("secret", strconv.FormatInt(now.Unix()+1, 10), body, signature))
+	assert.False(t, webhooks.Verify("secret", timestamp, []byte(`{"type":"book.deleted"}`), signature))
+}
+
+// flakyReceiver answers with the status returned by respond for each delivery, counting from 1.
+func flakyReceiver(t *testing.T, respond func(n int32) int) *httptest.Server {
+	var count int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := respond(atomic.AddInt32(&count, 1))
+		w.WriteHeader(status)
+		w.Write([]byte("answered " + strconv.Itoa(status)))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func deliveryFor(t *testing.T, subscriptionID uint) models.WebhookDelivery {
+	var delivery models.WebhookDelivery
+
+	assert.Eventually(t, func() bool {
+		return config.DB.Where("subscription_id = ?", subscriptionID).Order("id desc").First(&delivery).Error == nil
+	}, 5*time.Second, 20*time.Millisecond)
+
+	t.Cleanup(func() {
+		config.DB.Where("delivery_id IN (?)", config.DB.Model(&models.WebhookDelivery{}).Select("id").Where("subscription_id = ?", subscriptionID)).Delete(&models.WebhookDeliveryAttempt{})
+		config.DB.Where("subscription_id = ?", subscriptionID).Delete(&models.WebhookDelivery{})
+	})
+
+	return delivery
+}
+
+// emitBookDeleted sends a book.deleted event to the subscribed endpoints, as the outbox relay would.
+func emitBookDeleted(t *testing.T, bookID uint) {
+	err := webhooks.Default().EmitEvent(webhooks.Event{
+		ID:        "evt_" + t.Name() + "_" + strconv.Itoa(int(bookID)),
+		Type:      webhooks.EventBookDeleted,
+		CreatedAt: time.Now().UTC(),
+		Data:      map[string]uint{"id": bookID},
+	})
+	assert.NoError(t, err)
+}
+
+func waitForDeliveryStatus(t *testing.T, id uint, status string) models.WebhookDelivery {
+	var delivery models.WebhookDelivery
+
+	assert.Eventually(t, func() bool {
+		config.DB.Preload("AttemptLog").First(&delivery, id)
+		return delivery.Status == status
+	}, 5*time.Second, 20*time.Millisecond)
+
+	return delivery
+}
+
+func TestFailedDeliveriesAreRetriedUntilTheyAreDeadLettered(t *testing.T) {
+	actAs(t, models.RoleAdmin)
+
+	receiver := flakyReceiver(t, func(int32) int { return http.StatusInternalServerError })
+	created := createWebhookSubscription(t, receiver.URL, webhooks.EventBookDeleted)
+
+	emitBookDeleted(t, 1)
+
+	delivery := waitForDeliveryStatus(t, deliveryFor(t, created.ID).ID, models.WebhookDeliveryDead)
+
+	assert.Equal(t, 3, delivery.Attempts)
+	assert.Len(t, delivery.AttemptLog, 3)
+	for _, attempt := range delivery.AttemptLog {
+		assert.Equal(t, http.StatusInternalServerError, attempt.StatusCode)
+		assert.Equal(t, "answered 500", attempt.ResponseSnippet)
+		assert.NotEmpty(t, attempt.Error)
+	}
+}
+
+func TestFailedDeliveryIsRetriedUntilItSucceeds(t *testing.T) {
+	actAs(t, models.RoleAdmin)
+
+	receiver := flakyReceiver(t, func(n int32) int {
+		if n == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	created := createWebhookSubscription(t, receiver.URL, webhooks.EventBookDeleted)
+
+	emitBookDeleted(t, 1)
+
+	delivery := waitForDeliveryStatus(t, deliveryFor(t, created.ID).ID, models.WebhookDeliverySucceeded)
+
+	assert.Equal(t, 2, delivery.Attempts)
+	assert.NotNil(t, delivery.DeliveredAt)
+	assert.Nil(t, delivery.NextAttemptAt)
+
+	var subscription models.WebhookSubscription
+	config.DB.First(&subscription, created.ID)
+	assert.Zero(t, subscription.ConsecutiveFailures)
+}
+
+func TestEndpointThatKeepsFailingIsDisabled(t *testing.T) {
+	actAs(t, models.RoleAdmin)
+
+	receiver := flakyReceiver(t, func(int32) int { return http.StatusInternalServerError })
+	created := createWebhookSubscription(t, receiver.URL, webhooks.EventBookDeleted)
+
+	// Two deliveries of three attempts each fail more often than the five allowed in a row
+	emitBookDeleted(t, 1)
+	emitBookDeleted(t, 2)
+	deliveryFor(t, created.ID)
+
+	var subscription models.WebhookSubscription
+	assert.Eventually(t, func() bool {
+		config.DB.First(&subscription, created.ID)
+		return !subscription.Active
+	}, 5*time.Second, 20*time.Millisecond)
+
+	assert.NotNil(t, subscription.DisabledAt)
+	assert.NotEmpty(t, subscription.DisabledReason)
+
+	// Re-enabling the endpoint starts counting again
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/api/webhooks/"+strconv.Itoa(int(created.ID)), strings.NewReader(`{"active":true}`))
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	config.DB.First(&subscription, created.ID)
+	assert.True(t, subscription.Active)
+	assert.Zero(t, subscription.ConsecutiveFailures)
+	assert.Nil(t, subscription.DisabledAt)
+}
+
+func TestAdminCanInspectAndReplayADeadDelivery(t *testing.T) {
+	actAs(t, models.RoleAdmin)
+
+	var healthy atomic.Bool
+	receiver := flakyReceiver(t, func(int32) int {
+		if healthy.Load() {
+			return http.StatusOK
+		}
+		return http.StatusBadGateway
+	})
+	created := createWebhookSubscription(t, receiver.URL, webhooks.EventBookDeleted)
+
+	emitBookDeleted(t, 1)
+	dead := waitForDeliveryStatus(t, deliveryFor(t, created.ID).ID, models.WebhookDeliveryDead)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/webhooks/deliveries/"+strconv.Itoa(int(dead.ID)), nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var shown handlers.WebhookDeliveryResponse
+	json.Unmarshal(w.Body.Bytes(), &shown)
+	assert.Equal(t, models.WebhookDeliveryDead, shown.Status)
+	assert.Len(t, shown.AttemptLog, 3)
+	assert.Equal(t, http.StatusBadGateway, shown.AttemptLog[0].StatusCode)
+	assert.Contains(t, string(shown.Payload), webhooks.EventBookDeleted)
+
+	healthy.Store(true)
+
+	w = postJSON("/api/webhooks/deliveries/"+strconv.Itoa(int(dead.ID))+"/replay", nil)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	var replay handlers.WebhookDeliveryResponse
+	json.Unmarshal(w.Body.Bytes(), &replay)
+	assert.Equal(t, dead.ID, *replay.ReplayOf)
+	assert.Equal(t, dead.EventID, replay.EventID)
+
+	waitForDeliveryStatus(t, replay.ID, models.WebhookDeliverySucceeded)
+}
+
+func TestAdminCanReplayDeadDeliveriesInARange(t *testing.T) {
+	actAs(t, models.RoleAdmin)
+
+	var healthy atomic.Bool
+	receiver := flakyReceiver(t, func(int32) int {
+		if healthy.Load() {
+			return http.StatusOK
+		}
+		return http.StatusInternalServerError
+	})
+	created := createWebhookSubscription(t, receiver.URL, webhooks.EventBookDeleted)
+
+	from := time.Now().Add(-time.Second)
+	emitBookDeleted(t, 1)
+	dead := waitForDeliveryStatus(t, deliveryFor(t, created.ID).ID, models.WebhookDeliveryDead)
+
+	healthy.Store(true)
+
+	w := postJSON("/api/webhooks/deliveries/replay", map[string]interface{}{
+		"from":            from,
+		"to":              time.Now().Add(time.Second),
+		"subscription_id": created.ID,
+	})
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	var replayed []handlers.WebhookDeliveryResponse
+	json.Unmarshal(w.Body.Bytes(), &replayed)
+	assert.Len(t, replayed, 1)
+	assert.Equal(t, dead.ID, *replayed[0].ReplayOf)
+
+	waitForDeliveryStatus(t, replayed[0].ID, models.WebhookDeliverySucceeded)
+}
+
+func TestWebhookBackoffGrowsAndIsCapped(t *testing.T) {
+	for attempts := 1; attempts <= 10; attempts++ {
+		delay := webhooks.Backoff(attempts, time.Second, time.Minute)
+
+		expected := time.Second << (attempts - 1)
+		if expected > time.Minute {
+			expected = time.Minute
+		}
+
+		assert.GreaterOrEqual(t, delay, expected/2)
+		assert.LessOrEqual(t, delay, expected)
+	}
+}
+
+func TestDeliveriesAreListedAPageAtATime(t *testing.T) {
+	actAs(t, models.RoleAdmin)
+
+	receiver := flakyReceiver(t, func(int32) int { return http.StatusOK })
+	created := createWebhookSubscription(t, receiver.URL, webhooks.EventBookDeleted)
+
+	emitBookDeleted(t, 1)
+	emitBookDeleted(t, 2)
+	emitBookDeleted(t, 3)
+	deliveryFor(t, created.ID)
+
+	path := "/api/webhooks/deliveries?subscription_id=" + strconv.Itoa(int(created.ID))
+	w := sendRequest("GET", path+"&limit=2", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var first []handlers.WebhookDeliveryResponse
+	json.Unmarshal(w.Body.Bytes(), &first)
+	assert.Len(t, first, 2)
+	assert.Greater(t, first[0].ID, first[1].ID)
+
+	w = sendRequest("GET", pageLinks(w)["next"], "", "")
+	var second []handlers.WebhookDeliveryResponse
+	json.Unmarshal(w.Body.Bytes(), &second)
+	assert.Len(t, second, 1)
+	assert.Less(t, second[0].ID, first[1].ID)
+	assert.NotContains(t, pageLinks(w), "next")
+
+	w = sendRequest("GET", path+"&subscription="+strconv.Itoa(int(created.ID)), "", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,386 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fokosun/go-rest-api/config"
+	"github.com/fokosun/go-rest-api/jobs"
+	"github.com/fokosun/go-rest-api/models"
+	"gorm.io/gorm"
+)
+
+// responseSnippetSize is how much of a receiver's answer is kept with each attempt.
+const responseSnippetSize = 1024
+
+// requeueBatchSize is how many lost deliveries are queued again per check.
+const requeueBatchSize = 100
+
+const (
+	// DeliverJob is the job type that makes an attempt at a delivery.
+	DeliverJob = "webhooks.deliver"
+	// RequeueJob is the recurring job that queues again the deliveries whose job was lost.
+	RequeueJob = "webhooks.requeue"
+)
+
+// Dispatcher sends deliveries from the job queue, so that handlers never wait on a slow
+// receiver. Every delivery is stored first and its attempts are jobs, each queued for the
+// time in the delivery's NextAttemptAt. A delivery whose job was lost, because it could not
+// be queued or its worker died, is queued again by RequeueJob.
+type Dispatcher struct {
+	Client *http.Client
+	Retry  config.WebhookRetry
+	// Queue runs the deliveries, the default job queue when nil
+	Queue *jobs.Queue
+}
+
+// deliveryJob is the payload of DeliverJob. At is the NextAttemptAt the job was queued for, so
+// that a job for an attempt that was made already, or queued again since, does nothing.
+type deliveryJob struct {
+	ID uint      `json:"id"`
+	At time.Time `json:"at"`
+}
+
+var (
+	dispatcher     *Dispatcher
+	dispatcherOnce sync.Once
+)
+
+// SetDefault replaces the dispatcher used by the handlers.
+func SetDefault(d *Dispatcher) {
+	dispatcherOnce.Do(func() {})
+	dispatcher = d
+}
+
+// Default returns the dispatcher in use, configuring it from the environment on first use:
+//
+//	WEBHOOK_TIMEOUT how long a receiver has to answer, defaults to 10s
+//
+// Retries are configured with config.WebhookRetrySettings, and deliveries are only sent once
+// HandleJobs has registered them with a running queue.
+func Default() *Dispatcher {
+	dispatcherOnce.Do(func() {
+		dispatcher = NewDispatcher(config.WebhookRetrySettings())
+		dispatcher.Client.Timeout = config.EnvDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+	})
+	return dispatcher
+}
+
+// NewDispatcher creates a dispatcher that retries deliveries as configured.
+func NewDispatcher(retry config.WebhookRetry) *Dispatcher {
+	if retry.PollInterval <= 0 {
+		retry.PollInterval = 5 * time.Second
+	}
+
+	return &Dispatcher{
+		Client: &http.Client{Timeout: 10 * time.Second},
+		Retry:  retry,
+	}
+}
+
+// HandleJobs registers the jobs that send deliveries with queue, which the dispatcher queues
+// them on from then on, and looks for lost deliveries every PollInterval.
+func (d *Dispatcher) HandleJobs(queue *jobs.Queue) {
+	d.Queue = queue
+	queue.Register(DeliverJob, d.deliver)
+	queue.Register(RequeueJob, d.requeue)
+	queue.Every(RequeueJob, d.Retry.PollInterval)
+}
+
+// EmitEvent stores a delivery for every active endpoint subscribed to the event and queues them.
+// An event that was emitted before is not delivered again, so that it is safe to emit events
+// from an at least once source.
+func (d *Dispatcher) EmitEvent(event Event) error {
+	subscriptions := []models.WebhookSubscription{}
+	if err := config.DB.Where("active = ?", true).
+		Where("id NOT IN (?)", config.DB.Model(&models.WebhookDelivery{}).Select("subscription_id").Where("event_id = ?", event.ID)).
+		Find(&subscriptions).Error; err != nil {
+		return err
+	}
+
+	return d.emit(subscriptions, event)
+}
+
+func (d *Dispatcher) emit(subscriptions []models.WebhookSubscription, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		if !subscription.Subscribes(event.Type) {
+			continue
+		}
+
+		delivery := models.WebhookDelivery{
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			Payload:        string(body),
+		}
+		if err := d.create(&delivery); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Replay sends a stored delivery again as a new delivery, keeping the original for the record.
+func (d *Dispatcher) Replay(original models.WebhookDelivery) (models.WebhookDelivery, error) {
+	delivery := models.WebhookDelivery{
+		SubscriptionID: original.SubscriptionID,
+		EventID:        original.EventID,
+		EventType:      original.EventType,
+		Payload:        original.Payload,
+		ReplayOf:       &original.ID,
+	}
+	return delivery, d.create(&delivery)
+}
+
+// create stores a pending delivery and queues its first attempt.
+func (d *Dispatcher) create(delivery *models.WebhookDelivery) error {
+	now := attemptTime(time.Now())
+	delivery.Status = models.WebhookDeliveryPending
+	delivery.NextAttemptAt = &now
+
+	if err := config.DB.Create(delivery).Error; err != nil {
+		return err
+	}
+
+	d.schedule(delivery.ID, now)
+	return nil
+}
+
+// Deliver makes a single signed attempt at a delivery and records how the receiver answered.
+func (d *Dispatcher) Deliver(ctx context.Context, subscription models.WebhookSubscription, delivery models.WebhookDelivery) models.WebhookDeliveryAttempt {
+	attempt := models.WebhookDeliveryAttempt{DeliveryID: delivery.ID}
+	body := []byte(delivery.Payload)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-rest-api-webhooks/1.0")
+	req.Header.Set(IDHeader, delivery.EventID)
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(SignatureHeader, Sign(subscription.Secret, now, body))
+
+	resp, err := d.Client.Do(req)
+	attempt.LatencyMs = time.Since(now).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, responseSnippetSize))
+	attempt.StatusCode = resp.StatusCode
+	attempt.ResponseSnippet = string(snippet)
+
+	if !attempt.Succeeded() {
+		attempt.Error = fmt.Sprintf("receiver answered %s", resp.Status)
+	}
+	return attempt
+}
+
+// schedule queues the attempt at a delivery that is due at. One that cannot be queued stays
+// pending and is queued again by RequeueJob.
+func (d *Dispatcher) schedule(id uint, at time.Time) {
+	queue := d.Queue
+	if queue == nil {
+		queue = jobs.Default()
+	}
+
+	if _, err := queue.EnqueueAt(config.Ctx, DeliverJob, deliveryJob{ID: id, At: at}, at); err != nil {
+		log.Printf("webhooks: delivery %d could not be queued and will be queued again later: %v", id, err)
+	}
+}
+
+// deliver runs DeliverJob. The delivery is leased before it is sent, so that a job that
+// was queued again for it while it is being sent does nothing.
+func (d *Dispatcher) deliver(ctx context.Context, job jobs.Job) error {
+	var payload deliveryJob
+	if err := job.Decode(&payload); err != nil {
+		return err
+	}
+
+	claimed := config.DB.WithContext(ctx).Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ? AND next_attempt_at = ?", payload.ID, models.WebhookDeliveryPending, payload.At).
+		UpdateColumn("next_attempt_at", time.Now().Add(d.lease()))
+	if claimed.Error != nil || claimed.RowsAffected == 0 {
+		return claimed.Error
+	}
+
+	return d.process(ctx, payload.ID)
+}
+
+// process attempts a delivery and schedules what happens next: nothing when it succeeded,
+// a retry with backoff when it failed, or dead-lettering when it is out of attempts.
+func (d *Dispatcher) process(ctx context.Context, id uint) error {
+	var delivery models.WebhookDelivery
+	if err := config.DB.WithContext(ctx).First(&delivery, id).Error; err != nil {
+		return err
+	}
+
+	var subscription models.WebhookSubscription
+	if err := config.DB.WithContext(ctx).First(&subscription, delivery.SubscriptionID).Error; err != nil || !subscription.Active {
+		// Nobody is listening anymore, keep the delivery so that it can be replayed later
+		return d.deadLetter(delivery.ID)
+	}
+
+	attempt := d.Deliver(ctx, subscription, delivery)
+
+	var retryAt *time.Time
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&attempt).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		attempts := delivery.Attempts + 1
+
+		if attempt.Succeeded() {
+			if err := tx.Model(&subscription).UpdateColumn("consecutive_failures", 0).Error; err != nil {
+				return err
+			}
+			return tx.Model(&delivery).Updates(map[string]interface{}{
+				"status":          models.WebhookDeliverySucceeded,
+				"attempts":        attempts,
+				"delivered_at":    now,
+				"next_attempt_at": nil,
+			}).Error
+		}
+
+		if err := d.recordFailure(tx, subscription); err != nil {
+			return err
+		}
+
+		if attempts >= d.Retry.MaxAttempts {
+			return tx.Model(&delivery).Updates(map[string]interface{}{
+				"status":          models.WebhookDeliveryDead,
+				"attempts":        attempts,
+				"next_attempt_at": nil,
+			}).Error
+		}
+
+		next := attemptTime(now.Add(Backoff(attempts, d.Retry.BaseDelay, d.Retry.MaxDelay)))
+		retryAt = &next
+		return tx.Model(&delivery).Updates(map[string]interface{}{
+			"attempts":        attempts,
+			"next_attempt_at": next,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if retryAt != nil {
+		d.schedule(delivery.ID, *retryAt)
+	}
+	return nil
+}
+
+// recordFailure counts a failed attempt against an endpoint and disables it once too many have failed in a row.
+func (d *Dispatcher) recordFailure(tx *gorm.DB, subscription models.WebhookSubscription) error {
+	if err := tx.Model(&models.WebhookSubscription{}).Where("id = ?", subscription.ID).
+		UpdateColumn("consecutive_failures", gorm.Expr("consecutive_failures + 1")).Error; err != nil {
+		return err
+	}
+
+	if d.Retry.DisableAfter <= 0 {
+		return nil
+	}
+
+	disabled := tx.Model(&models.WebhookSubscription{}).
+		Where("id = ? AND active = ? AND consecutive_failures >= ?", subscription.ID, true, d.Retry.DisableAfter).
+		UpdateColumns(map[string]interface{}{
+			"active":          false,
+			"disabled_at":     time.Now(),
+			"disabled_reason": fmt.Sprintf("disabled after %d failed attempts in a row", d.Retry.DisableAfter),
+		})
+	if disabled.RowsAffected > 0 {
+		log.Printf("webhooks: disabled subscription %d after %d failed attempts in a row", subscription.ID, d.Retry.DisableAfter)
+	}
+	return disabled.Error
+}
+
+func (d *Dispatcher) deadLetter(id uint) error {
+	return config.DB.Model(&models.WebhookDelivery{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":          models.WebhookDeliveryDead,
+		"next_attempt_at": nil,
+	}).Error
+}
+
+// requeue runs RequeueJob. It queues again the pending deliveries that are overdue by more
+// than RequeueAfter, which their job would have sent by then unless it was lost.
+func (d *Dispatcher) requeue(ctx context.Context, job jobs.Job) error {
+	cutoff := time.Now().Add(-d.Retry.RequeueAfter)
+
+	overdue := []models.WebhookDelivery{}
+	if err := config.DB.WithContext(ctx).Select("id").
+		Where("status = ? AND next_attempt_at < ?", models.WebhookDeliveryPending, cutoff).
+		Order("next_attempt_at").Limit(requeueBatchSize).
+		Find(&overdue).Error; err != nil {
+		return err
+	}
+
+	for _, delivery := range overdue {
+		at := attemptTime(time.Now())
+		claimed := config.DB.WithContext(ctx).Model(&models.WebhookDelivery{}).
+			Where("id = ? AND status = ? AND next_attempt_at < ?", delivery.ID, models.WebhookDeliveryPending, cutoff).
+			UpdateColumn("next_attempt_at", at)
+		if claimed.Error != nil {
+			return claimed.Error
+		}
+		if claimed.RowsAffected > 0 {
+			log.Printf("webhooks: queueing delivery %d again", delivery.ID)
+			d.schedule(delivery.ID, at)
+		}
+	}
+	return nil
+}
+
+// lease is how long a delivery that is being sent is kept from RequeueJob.
+func (d *Dispatcher) lease() time.Duration {
+	return d.Client.Timeout + 30*time.Second
+}
+
+// attemptTime rounds t to what Postgres keeps of a timestamp, so that a job can find its
+// delivery by the time it was queued for.
+func attemptTime(t time.Time) time.Time {
+	return t.Truncate(time.Microsecond)
+}
+
+// Backoff is how long to wait before the next attempt after the given number of failed ones:
+// base doubled for every attempt, capped at max, of which a random half is waited so that
+// retries of many deliveries that failed together are spread out.
+func Backoff(attempts int, base time.Duration, max time.Duration) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + time.Duration(mathrand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,45 @@
+package webhooks
+
+import "time"
+
+const (
+	EventUserDeleted    = "user.deleted"
+	EventUserRestored   = "user.restored"
+	EventAuthorCreated  = "author.created"
+	EventAuthorUpdated  = "author.updated"
+	EventAuthorDeleted  = "author.deleted"
+	EventAuthorRestored = "author.restored"
+	EventBookCreated    = "book.created"
+	EventBookUpdated    = "book.updated"
+	EventBookDeleted    = "book.deleted"
+	EventBookRestored   = "book.restored"
+	EventRatingCreated  = "rating.created"
+	EventRatingUpdated  = "rating.updated"
+	EventRatingDeleted  = "rating.deleted"
+)
+
+// AllEvents lists every event type an endpoint can subscribe to.
+var AllEvents = []string{
+	EventUserDeleted, EventUserRestored,
+	EventAuthorCreated, EventAuthorUpdated, EventAuthorDeleted, EventAuthorRestored,
+	EventBookCreated, EventBookUpdated, EventBookDeleted, EventBookRestored,
+	EventRatingCreated, EventRatingUpdated, EventRatingDeleted,
+}
+
+// IsEvent reports whether the string names a known event type.
+func IsEvent(name string) bool {
+	for _, event := range AllEvents {
+		if event == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the body of every delivery.
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
@@ -0,0 +1,149 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fokosun/go-rest-api/config"
+)
+
+const inboundNoncePrefix = "webhooks:inbound:nonce:"
+
+var ErrUnknownSource = errors.New("unknown webhook source")
+
+// Source is an upstream system that sends us webhooks, signed the same way we sign ours:
+// an HMAC-SHA256 keyed with Secret over the timestamp and the body.
+type Source struct {
+	Name            string
+	Secret          string
+	SignatureHeader string
+	TimestampHeader string
+	// NonceHeader carries a value that is unique per delivery, used to refuse replays
+	NonceHeader string
+	// Tolerance is how far the timestamp may be from our clock
+	Tolerance time.Duration
+}
+
+// InboundHandler processes the verified payload of a webhook from a source. A payload that
+// cannot be processed is reported with a *PayloadError, which is shown to the source.
+type InboundHandler func(ctx context.Context, payload []byte) error
+
+// PayloadError is returned by an InboundHandler for a payload it refuses.
+type PayloadError struct {
+	Message string
+}
+
+func (e *PayloadError) Error() string {
+	return e.Message
+}
+
+// InvalidPayload returns a PayloadError with a formatted message.
+func InvalidPayload(format string, args ...interface{}) error {
+	return &PayloadError{Message: fmt.Sprintf(format, args...)}
+}
+
+var (
+	sources     map[string]Source
+	sourcesOnce sync.Once
+	handlers    = map[string]InboundHandler{}
+	inboundMu   sync.RWMutex
+)
+
+// LoadSources reads the webhook sources named in INBOUND_WEBHOOK_SOURCES (comma separated).
+// Each source NAME is configured with:
+//
+//	INBOUND_WEBHOOK_NAME_SECRET           shared secret the source signs with
+//	INBOUND_WEBHOOK_NAME_SIGNATURE_HEADER defaults to X-Webhook-Signature
+//	INBOUND_WEBHOOK_NAME_TIMESTAMP_HEADER defaults to X-Webhook-Timestamp
+//	INBOUND_WEBHOOK_NAME_NONCE_HEADER     defaults to X-Webhook-Id
+//	INBOUND_WEBHOOK_NAME_TOLERANCE        defaults to 5m
+func LoadSources() map[string]Source {
+	loaded := map[string]Source{}
+
+	for _, name := range strings.Split(config.Env("INBOUND_WEBHOOK_SOURCES", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "INBOUND_WEBHOOK_" + strings.ToUpper(name) + "_"
+		loaded[name] = Source{
+			Name:            name,
+			Secret:          config.Env(prefix+"SECRET", ""),
+			SignatureHeader: config.Env(prefix+"SIGNATURE_HEADER", SignatureHeader),
+			TimestampHeader: config.Env(prefix+"TIMESTAMP_HEADER", TimestampHeader),
+			NonceHeader:     config.Env(prefix+"NONCE_HEADER", IDHeader),
+			Tolerance:       config.EnvDuration(prefix+"TOLERANCE", 5*time.Minute),
+		}
+	}
+
+	return loaded
+}
+
+// SetSource registers a webhook source, replacing any with the same name.
+func SetSource(source Source) {
+	sourcesOnce.Do(loadSources)
+
+	inboundMu.Lock()
+	defer inboundMu.Unlock()
+	sources[source.Name] = source
+}
+
+// GetSource looks up a configured webhook source by name.
+func GetSource(name string) (Source, error) {
+	sourcesOnce.Do(loadSources)
+
+	inboundMu.RLock()
+	defer inboundMu.RUnlock()
+
+	source, ok := sources[name]
+	if !ok || source.Secret == "" {
+		return Source{}, ErrUnknownSource
+	}
+	return source, nil
+}
+
+// Handle registers the handler that verified webhooks from a source are sent to.
+func Handle(source string, handler InboundHandler) {
+	inboundMu.Lock()
+	defer inboundMu.Unlock()
+	handlers[source] = handler
+}
+
+// HandlerFor returns the handler registered for a source.
+func HandlerFor(source string) (InboundHandler, bool) {
+	inboundMu.RLock()
+	defer inboundMu.RUnlock()
+
+	handler, ok := handlers[source]
+	return handler, ok
+}
+
+// UseNonce records a nonce from a source, reporting false when it has been seen before.
+// Nonces only need to be remembered for as long as their timestamp would be accepted.
+func UseNonce(source Source, nonce string) (bool, error) {
+	return config.Client.SetNX(config.Ctx, inboundNoncePrefix+source.Name+":"+nonce, 1, 2*source.Tolerance).Result()
+}
+
+// ForgetNonce lets a nonce be used again, so that a source can retry a webhook we failed to process.
+func ForgetNonce(source Source, nonce string) error {
+	return config.Client.Del(config.Ctx, inboundNoncePrefix+source.Name+":"+nonce).Err()
+}
+
+// SignatureNonce is the nonce remembered for a signature, refusing a captured request that is sent again.
+func SignatureNonce(signature string) string {
+	return "sig:" + signature
+}
+
+// DeliveryNonce is the nonce remembered for a delivery id, refusing a delivery the source sent twice.
+func DeliveryNonce(id string) string {
+	return "id:" + id
+}
+
+func loadSources() {
+	sources = LoadSources()
+}
@@ -0,0 +1,34 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/fokosun/go-rest-api/models"
+)
+
+// OutboxSink delivers outbox events to the endpoints subscribed to them. The event ID is
+// derived from the outbox ID, so an event the relay publishes twice is only delivered once.
+type OutboxSink struct {
+	// Dispatcher defaults to the default dispatcher
+	Dispatcher *Dispatcher
+}
+
+func (s OutboxSink) Name() string {
+	return "webhooks"
+}
+
+func (s OutboxSink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	dispatcher := s.Dispatcher
+	if dispatcher == nil {
+		dispatcher = Default()
+	}
+
+	return dispatcher.EmitEvent(Event{
+		ID:        "evt_" + strconv.FormatUint(uint64(event.ID), 10),
+		Type:      event.EventType,
+		CreatedAt: event.CreatedAt.UTC(),
+		Data:      json.RawMessage(event.Payload),
+	})
+}